@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// runCommand executes a maintenance subcommand instead of starting the server
+func runCommand(name string, args []string, productRepo *repository.ProductRepo) {
+	switch name {
+	case "repair-names":
+		repairNames(args, productRepo)
+	case "migrate":
+		migrate(args, productRepo)
+	case "purge-trash":
+		purgeTrash(args, productRepo)
+	default:
+		log.Fatalf("Unknown command %q (available: repair-names, migrate, purge-trash)", name)
+	}
+}
+
+// repairNames reports duplicate product names and fixes missing or stale name reservations
+func repairNames(args []string, productRepo *repository.ProductRepo) {
+	fs := flag.NewFlagSet("repair-names", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report problems without writing any changes")
+	fs.Parse(args)
+
+	report, err := productRepo.RepairNameReservations(context.Background(), *dryRun)
+	if err != nil {
+		log.Fatalf("Name repair failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if len(report.Duplicates) > 0 {
+		log.Printf("Found %d duplicate product names; rename or delete the listed products", len(report.Duplicates))
+		os.Exit(1)
+	}
+}
+
+// migrate upgrades product documents to the current schema version
+func migrate(args []string, productRepo *repository.ProductRepo) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report pending migrations without writing any changes")
+	batchSize := fs.Int("batch-size", repository.DefaultMigrationBatchSize, "number of documents read and written per batch")
+	fs.Parse(args)
+
+	report, err := productRepo.MigrateProducts(context.Background(), repository.MigrationOptions{DryRun: *dryRun, BatchSize: *batchSize})
+	if err != nil {
+		log.Fatalf("Product migration failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if len(report.Failed) > 0 {
+		log.Printf("%d products could not be migrated", len(report.Failed))
+		os.Exit(1)
+	}
+}
+
+// purgeTrash removes products that have been in the trash longer than -older-than
+func purgeTrash(args []string, productRepo *repository.ProductRepo) {
+	fs := flag.NewFlagSet("purge-trash", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 30*24*time.Hour, "remove products trashed longer ago than this")
+	hard := fs.Bool("hard", false, "_purge the documents instead of deleting them")
+	dryRun := fs.Bool("dry-run", false, "list the products that would be removed without removing them")
+	fs.Parse(args)
+
+	report, err := productRepo.PurgeTrash(context.Background(), repository.PurgeOptions{
+		Before: time.Now().Add(-*olderThan),
+		Hard:   *hard,
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		log.Fatalf("Trash purge failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if len(report.Failed) > 0 {
+		log.Printf("%d trashed products could not be removed", len(report.Failed))
+		os.Exit(1)
+	}
+}
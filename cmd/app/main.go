@@ -1,36 +1,285 @@
-package main
-
-import (
-	"e-learning/go-with-couchdb/internal/controller"
-	"e-learning/go-with-couchdb/internal/database"
-	"e-learning/go-with-couchdb/internal/repository"
-	"e-learning/go-with-couchdb/internal/usecase"
-	"e-learning/go-with-couchdb/routes"
-	"github.com/joho/godotenv"
-	"log"
-)
-
-func main() {
-	
-	// Load environment variables from a .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Initialize the database
-	if err := database.InitDB(); err != nil {
-		log.Fatalf("Database initialization failed: %v", err)
-	}
-
-	// Inject dependencies for product module
-	productRepo := &repository.ProductRepo{}
-	productService := usecase.NewProductService(productRepo)
-	productController := controller.NewProductController(productService)
-
-	// Initialize routes and pass the ProductController
-	router := routes.InitRoutes(productController)
-
-	// Start server on port 8081
-	router.Run(":8081")
-}
+package main
+
+import (
+	"context"
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/controller"
+	"e-learning/go-with-couchdb/internal/database"
+	"e-learning/go-with-couchdb/internal/middleware"
+	"e-learning/go-with-couchdb/internal/publisher"
+	"e-learning/go-with-couchdb/internal/ratelimit"
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+	"e-learning/go-with-couchdb/routes"
+	"fmt"
+	"github.com/joho/godotenv"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	
+	// Load environment variables from a .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	// Initialize the database
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Database initialization failed: %v", err)
+	}
+
+	// Inject dependencies for product module
+	productRepo := repository.NewProductRepo(database.Store(database.StoreProducts))
+
+	// Run a maintenance command, e.g. "go-api repair-names -dry-run", if one was given
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:], productRepo)
+		return
+	}
+
+	// Optionally upgrade product documents to the current schema version before serving traffic
+	if os.Getenv("MIGRATE_ON_STARTUP") == "true" {
+		if _, err := productRepo.MigrateProducts(context.Background(), repository.MigrationOptions{}); err != nil {
+			log.Fatalf("Product migration failed: %v", err)
+		}
+	}
+
+	// Relay the domain events written with each product to the configured publisher
+	eventPublisher, err := newEventPublisher()
+	if err != nil {
+		log.Fatalf("Event publisher initialization failed: %v", err)
+	}
+	relay := usecase.NewOutboxRelay(productRepo, eventPublisher, usecase.DefaultOutboxRelayConfig())
+	go relay.Run(context.Background())
+
+	// Remove products that have been in the trash longer than the retention period
+	purgeConfig, err := trashPurgeConfig()
+	if err != nil {
+		log.Fatalf("Invalid trash purge configuration: %v", err)
+	}
+	go usecase.NewTrashPurger(productRepo, purgeConfig).Run(context.Background())
+
+	productService := usecase.NewProductService(productRepo)
+	productController := controller.NewProductController(productService)
+
+	// Inject dependencies for webhook module and start delivering product events
+	webhookDB, err := database.InitStore(context.Background(), database.StoreWebhooks, database.WebhookDesignDocs)
+	if err != nil {
+		log.Fatalf("Webhook store initialization failed: %v", err)
+	}
+	webhookRepo := repository.NewWebhookRepo(webhookDB)
+	dispatcherConfig := usecase.DefaultWebhookDispatcherConfig()
+	if dispatcherConfig.Targets, err = usecase.ParseWebhookTargets(os.Getenv("WEBHOOK_ALLOWED_NETWORKS")); err != nil {
+		log.Fatalf("Invalid webhook configuration: %v", err)
+	}
+	webhookService := usecase.NewWebhookService(webhookRepo, dispatcherConfig.Targets)
+	webhookController := controller.NewWebhookController(webhookService)
+	dispatcher := usecase.NewWebhookDispatcher(productRepo, webhookRepo, dispatcherConfig)
+	go dispatcher.Run(context.Background())
+
+	// Inject dependencies for the audit log of mutating API calls
+	auditDB, err := database.InitStore(context.Background(), database.StoreAudit, database.AuditDesignDocs)
+	if err != nil {
+		log.Fatalf("Audit store initialization failed: %v", err)
+	}
+	auditService := usecase.NewAuditService(repository.NewAuditRepo(auditDB))
+	auditController := controller.NewAuditController(auditService)
+
+	// Authenticate API calls with JWT bearer tokens or API keys
+	authPolicy, err := authPolicyConfig()
+	if err != nil {
+		log.Fatalf("Invalid authentication configuration: %v", err)
+	}
+
+	// Inject dependencies for the API keys of machine clients
+	apiKeyDB, err := database.InitStore(context.Background(), database.StoreAPIKeys, database.APIKeyDesignDocs)
+	if err != nil {
+		log.Fatalf("API key store initialization failed: %v", err)
+	}
+	apiKeyService := usecase.NewAPIKeyService(repository.NewAPIKeyRepo(apiKeyDB), authPolicy.Access)
+	apiKeyController := controller.NewAPIKeyController(apiKeyService)
+	authPolicy.APIKeys = apiKeyService
+
+	// Limit the requests of each client
+	rateLimits, err := rateLimitConfig()
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+
+	// Keep the responses to creates sent with an Idempotency-Key for replays
+	idempotencyDB, err := database.InitStore(context.Background(), database.StoreIdempotency, database.IdempotencyDesignDocs)
+	if err != nil {
+		log.Fatalf("Idempotency store initialization failed: %v", err)
+	}
+	idempotencyConfig := usecase.DefaultIdempotencyConfig()
+	if value := os.Getenv("IDEMPOTENCY_TTL"); value != "" {
+		if idempotencyConfig.TTL, err = time.ParseDuration(value); err != nil || idempotencyConfig.TTL <= 0 {
+			log.Fatalf("IDEMPOTENCY_TTL must be a positive duration, got '%s'", value)
+		}
+	}
+	idempotencyService := usecase.NewIdempotencyService(repository.NewIdempotencyRepo(idempotencyDB), idempotencyConfig)
+	go idempotencyService.Run(context.Background())
+
+	// Initialize routes and pass the controllers
+	router := routes.InitRoutes(productController, webhookController, auditController, apiKeyController, auditService, authPolicy, rateLimits, idempotencyService)
+
+	// Start server on port 8081
+	router.Run(":8081")
+}
+
+// newEventPublisher creates the publisher selected by EVENT_PUBLISHER: "nats", "kafka",
+// or the in-process one by default
+func newEventPublisher() (usecase.EventPublisher, error) {
+	switch os.Getenv("EVENT_PUBLISHER") {
+	case "nats":
+		return publisher.NewNATS(os.Getenv("NATS_URL"), envOr("NATS_SUBJECT_PREFIX", "products.events"))
+	case "kafka":
+		return publisher.NewKafka(strings.Split(os.Getenv("KAFKA_BROKERS"), ","), envOr("KAFKA_TOPIC", "product-events")), nil
+	case "", "inprocess":
+		return publisher.NewInProcess(), nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_PUBLISHER '%s'", os.Getenv("EVENT_PUBLISHER"))
+	}
+}
+
+// trashPurgeConfig reads TRASH_RETENTION and TRASH_PURGE_INTERVAL as durations, e.g. "720h",
+// and TRASH_PURGE_MODE, "delete" (default) or "purge"
+func trashPurgeConfig() (usecase.TrashPurgeConfig, error) {
+	cfg := usecase.DefaultTrashPurgeConfig()
+	for key, target := range map[string]*time.Duration{
+		"TRASH_RETENTION":      &cfg.Retention,
+		"TRASH_PURGE_INTERVAL": &cfg.Interval,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("%s must be a positive duration, got '%s'", key, value)
+		}
+		*target = d
+	}
+
+	switch mode := os.Getenv("TRASH_PURGE_MODE"); mode {
+	case "", "delete":
+	case "purge":
+		cfg.Hard = true
+	default:
+		return cfg, fmt.Errorf("unknown TRASH_PURGE_MODE '%s'", mode)
+	}
+	return cfg, nil
+}
+
+// authPolicyConfig reads the token verification keys and the per route group modes:
+// JWT_HS256_SECRETS, a comma separated list of secrets, each optionally prefixed with
+// "<key id>:"; JWT_JWKS_FILE or JWT_JWKS_URL for RS256 keys, reloaded every JWT_JWKS_REFRESH;
+// JWT_ISSUER and JWT_AUDIENCE; and AUTH_MODES, e.g. "products=optional,audit=required",
+// where groups not listed use AUTH_DEFAULT_MODE. ACCESS_POLICY_FILE names a JSON file
+// granting permissions to roles, see auth.LoadPolicy; without it the default policy
+// applies. Without any token key bearer tokens are rejected and AUTH_DEFAULT_MODE
+// defaults to "optional" instead of "required"; API keys are verified either way, and
+// anonymous callers only hold the policy's "anonymous" permissions, read-only by default.
+func authPolicyConfig() (middleware.AuthPolicy, error) {
+	cfg := auth.Config{
+		JWKSFile: os.Getenv("JWT_JWKS_FILE"),
+		JWKSURL:  os.Getenv("JWT_JWKS_URL"),
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Leeway:   30 * time.Second,
+	}
+	for _, entry := range strings.Split(os.Getenv("JWT_HS256_SECRETS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key := auth.HMACKey{Secret: []byte(entry)}
+		if id, secret, ok := strings.Cut(entry, ":"); ok {
+			key = auth.HMACKey{ID: id, Secret: []byte(secret)}
+		}
+		if len(key.Secret) < 32 {
+			return middleware.AuthPolicy{}, fmt.Errorf("HS256 secrets must be at least 32 bytes long")
+		}
+		cfg.HMACKeys = append(cfg.HMACKeys, key)
+	}
+	if value := os.Getenv("JWT_JWKS_REFRESH"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return middleware.AuthPolicy{}, fmt.Errorf("JWT_JWKS_REFRESH must be a positive duration, got '%s'", value)
+		}
+		cfg.JWKSRefresh = d
+	}
+
+	policy := middleware.AuthPolicy{Modes: make(map[string]auth.Mode)}
+	// Without token keys authentication is optional unless configured otherwise: API keys
+	// are verified when sent, anonymous calls get the policy's anonymous permissions
+	defaultMode := auth.ModeRequired
+	if !cfg.Enabled() {
+		defaultMode = auth.ModeOptional
+	}
+	mode, err := auth.ParseMode(envOr("AUTH_DEFAULT_MODE", string(defaultMode)))
+	if err != nil {
+		return policy, err
+	}
+	policy.Default = mode
+	for _, entry := range strings.Split(os.Getenv("AUTH_MODES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			return policy, fmt.Errorf("invalid AUTH_MODES entry '%s', expected group=mode", entry)
+		}
+		mode, err := auth.ParseMode(strings.TrimSpace(name))
+		if err != nil {
+			return policy, err
+		}
+		policy.Modes[strings.TrimSpace(group)] = mode
+	}
+
+	policy.Access = auth.DefaultPolicy()
+	if path := os.Getenv("ACCESS_POLICY_FILE"); path != "" {
+		if policy.Access, err = auth.LoadPolicy(path); err != nil {
+			return policy, err
+		}
+	}
+
+	if !cfg.Enabled() {
+		log.Println("No JWT verification key configured; only API keys are accepted and anonymous calls get the access policy's anonymous permissions where authentication is optional")
+		return policy, nil
+	}
+	policy.Authenticator, err = auth.NewAuthenticator(cfg)
+	return policy, err
+}
+
+// rateLimitConfig reads RATE_LIMIT_DEFAULT, RATE_LIMIT_BULK and RATE_LIMIT_IP, each
+// "<requests>/<duration>" such as "300/1m" or "off". The IP limit is applied before
+// authentication and is higher, as clients behind one NAT share it. Limits are kept in memory, so each instance enforces its own.
+func rateLimitConfig() (middleware.RateLimitPolicy, error) {
+	policy := middleware.RateLimitPolicy{Store: ratelimit.NewMemoryStore(), Limits: make(map[string]ratelimit.Limit)}
+	for _, setting := range []struct{ class, key, fallback string }{
+		{middleware.RateLimitDefault, "RATE_LIMIT_DEFAULT", "300/1m"},
+		{middleware.RateLimitBulk, "RATE_LIMIT_BULK", "20/1m"},
+		{middleware.RateLimitIP, "RATE_LIMIT_IP", "1200/1m"},
+	} {
+		limit, err := ratelimit.ParseLimit(envOr(setting.key, setting.fallback))
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", setting.key, err)
+		}
+		policy.Limits[setting.class] = limit
+	}
+	return policy, nil
+}
+
+// envOr returns the environment variable, or fallback if it is unset
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,37 @@
+// Package actor carries the identity of whoever performs a request through its context
+package actor
+
+import "context"
+
+// Anonymous is reported for requests that did not identify their actor
+const Anonymous = "anonymous"
+
+type contextKey struct{}
+
+// claimedKey stores the name a request gave itself without proving it
+type claimedKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, contextKey{}, actor)
+}
+
+// FromContext returns the actor stored in ctx, or Anonymous
+func FromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(contextKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return Anonymous
+}
+
+// WithClaimed returns a copy of ctx carrying the name the caller claims to have. It is
+// kept for the record only and never becomes the actor.
+func WithClaimed(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, claimedKey{}, name)
+}
+
+// ClaimedFromContext returns the name claimed by the caller, or "" if it claimed none
+func ClaimedFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(claimedKey{}).(string)
+	return name
+}
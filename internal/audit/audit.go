@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+type contextKey struct{}
+
+// Change is a product change recorded while serving a request
+type Change struct {
+	Action    string
+	ProductID string
+	Changes   map[string]entity.FieldChange
+}
+
+// Trail collects the product changes made while serving one request. The audit
+// middleware attaches it to the request context and writes it to the audit log.
+type Trail struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// bookkeepingFields are maintained by the repository and left out of diffs
+var bookkeepingFields = []string{"_id", "_rev", "_conflicts", "schema_version", "updated_at", "updated_by"}
+
+// WithTrail returns a copy of ctx carrying the trail
+func WithTrail(ctx context.Context, trail *Trail) context.Context {
+	return context.WithValue(ctx, contextKey{}, trail)
+}
+
+// Enabled reports whether changes made with ctx are audited
+func Enabled(ctx context.Context) bool {
+	_, ok := ctx.Value(contextKey{}).(*Trail)
+	return ok
+}
+
+// Record adds a product change to the trail in ctx. before is nil for creates.
+// Without a trail, e.g. in maintenance commands, nothing is recorded.
+func Record(ctx context.Context, action string, productID string, before *entity.Product, after *entity.Product) {
+	trail, ok := ctx.Value(contextKey{}).(*Trail)
+	if !ok {
+		return
+	}
+
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	trail.changes = append(trail.changes, Change{Action: action, ProductID: productID, Changes: Diff(before, after)})
+}
+
+// Changes returns the changes recorded so far
+func (t *Trail) Changes() []Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Change(nil), t.changes...)
+}
+
+// Diff returns the product fields that differ between two versions, by their JSON names
+func Diff(before *entity.Product, after *entity.Product) map[string]entity.FieldChange {
+	from, to := fields(before), fields(after)
+	diff := make(map[string]entity.FieldChange)
+	for name, value := range from {
+		if !reflect.DeepEqual(value, to[name]) {
+			diff[name] = entity.FieldChange{Before: value, After: to[name]}
+		}
+	}
+	for name, value := range to {
+		if _, ok := from[name]; !ok {
+			diff[name] = entity.FieldChange{After: value}
+		}
+	}
+	return diff
+}
+
+// fields returns the JSON fields of a product version without the bookkeeping ones
+func fields(product *entity.Product) map[string]interface{} {
+	result := make(map[string]interface{})
+	if product == nil {
+		return result
+	}
+	raw, _ := json.Marshal(product)
+	_ = json.Unmarshal(raw, &result)
+	for _, name := range bookkeepingFields {
+		delete(result, name)
+	}
+	return result
+}
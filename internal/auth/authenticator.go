@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnauthenticated is reported for requests without valid credentials
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Mode is how a route group authenticates its requests
+type Mode string
+
+const (
+	// ModeRequired rejects requests without a valid bearer token
+	ModeRequired Mode = "required"
+	// ModeOptional verifies a bearer token if one is sent and lets anonymous requests through
+	ModeOptional Mode = "optional"
+	// ModeOff ignores bearer tokens
+	ModeOff Mode = "off"
+)
+
+// ParseMode validates a mode name
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(name); mode {
+	case ModeRequired, ModeOptional, ModeOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown authentication mode '%s'", name)
+}
+
+// HMACKey is a shared secret for HS256 tokens. ID matches the kid header of tokens
+// signed with it and may be empty.
+type HMACKey struct {
+	ID     string
+	Secret []byte
+}
+
+// Config selects the keys bearer tokens are verified with. HS256 tokens naming a key ID
+// are checked against that key of HMACKeys, others against every key, so a new secret can
+// be rolled out next to the old one. RS256 tokens are checked against the keys of the
+// JWKS at JWKSFile or JWKSURL.
+type Config struct {
+	HMACKeys []HMACKey
+	JWKSFile string
+	JWKSURL  string
+	// JWKSRefresh is how long JWKS keys are used before they are loaded again
+	JWKSRefresh time.Duration
+	// Issuer and Audience, if set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// Enabled reports whether any verification key is configured
+func (c Config) Enabled() bool {
+	return len(c.HMACKeys) > 0 || c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// Authenticator verifies JWT bearer tokens
+type Authenticator struct {
+	cfg    Config
+	rsa    *remoteKeys
+	parser *jwt.Parser
+}
+
+// NewAuthenticator creates an authenticator for the configured keys. JWKS keys are
+// loaded on first use.
+func NewAuthenticator(cfg Config) (*Authenticator, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("no token verification key configured")
+	}
+	if cfg.JWKSFile != "" && cfg.JWKSURL != "" {
+		return nil, errors.New("configure either a JWKS file or a JWKS URL, not both")
+	}
+	if cfg.JWKSRefresh <= 0 {
+		cfg.JWKSRefresh = time.Hour
+	}
+
+	methods := []string{}
+	if len(cfg.HMACKeys) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	var rsaKeys *remoteKeys
+	switch {
+	case cfg.JWKSFile != "":
+		rsaKeys = newFileKeys(cfg.JWKSFile, cfg.JWKSRefresh)
+	case cfg.JWKSURL != "":
+		rsaKeys = newURLKeys(cfg.JWKSURL, cfg.JWKSRefresh, &http.Client{Timeout: 10 * time.Second})
+	}
+	if rsaKeys != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &Authenticator{cfg: cfg, rsa: rsaKeys, parser: jwt.NewParser(opts...)}, nil
+}
+
+// Verify checks the signature and the time, issuer and audience claims of a token and
+// returns its claims. Failures wrap ErrUnauthenticated.
+func (a *Authenticator) Verify(ctx context.Context, token string) (Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return a.keys(ctx, t)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if sub, _ := claims.GetSubject(); sub == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	return Claims(claims), nil
+}
+
+// keys returns the keys a token may be signed with, based on its algorithm and key ID
+func (a *Authenticator) keys(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	var set jwt.VerificationKeySet
+
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		for _, key := range a.cfg.HMACKeys {
+			if kid == "" || key.ID == kid {
+				set.Keys = append(set.Keys, key.Secret)
+			}
+		}
+	case jwt.SigningMethodRS256.Alg():
+		for _, key := range a.rsa.lookup(ctx, kid) {
+			set.Keys = append(set.Keys, key)
+		}
+	}
+
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("no key for key ID %q", kid)
+	}
+	return set, nil
+}
+
+// BearerToken extracts the token of an "Authorization: Bearer <token>" header
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testClaims returns the claims of a token for subject expiring after ttl
+func testClaims(subject string, ttl time.Duration) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub": subject,
+		"iss": "https://issuer.test",
+		"aud": "products-api",
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+}
+
+func signHS256(t *testing.T, kid string, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func signRS256(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// issuerKeys are the signing keys of a token issuer
+type issuerKeys struct {
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+}
+
+func (k *issuerKeys) add(kid string, key *rsa.PrivateKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[kid] = key
+}
+
+// serveJWKS publishes the public halves of the issuer's keys as a JWKS
+func serveJWKS(keys *issuerKeys) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		keys.mu.Lock()
+		defer keys.mu.Unlock()
+		var set jwks
+		for kid, key := range keys.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+}
+
+func TestAuthenticatorVerifiesHS256Tokens(t *testing.T) {
+	authn, err := NewAuthenticator(Config{
+		HMACKeys: []HMACKey{{ID: "old", Secret: []byte("old-secret")}, {ID: "new", Secret: []byte("new-secret")}},
+		Issuer:   "https://issuer.test",
+		Audience: "products-api",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noSubject := testClaims("", time.Minute)
+	wrongAudience := testClaims("alice", time.Minute)
+	wrongAudience["aud"] = "other-api"
+	wrongIssuer := testClaims("alice", time.Minute)
+	wrongIssuer["iss"] = "https://evil.test"
+	noExpiry := testClaims("alice", time.Minute)
+	delete(noExpiry, "exp")
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims("alice", time.Minute)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", signHS256(t, "new", "new-secret", testClaims("alice", time.Minute)), true},
+		{"valid without key ID", signHS256(t, "", "old-secret", testClaims("alice", time.Minute)), true},
+		{"expired", signHS256(t, "new", "new-secret", testClaims("alice", -time.Minute)), false},
+		{"bad signature", signHS256(t, "new", "guessed-secret", testClaims("alice", time.Minute)), false},
+		{"key ID of another key", signHS256(t, "new", "old-secret", testClaims("alice", time.Minute)), false},
+		{"unknown key ID", signHS256(t, "other", "new-secret", testClaims("alice", time.Minute)), false},
+		{"no subject", signHS256(t, "new", "new-secret", noSubject), false},
+		{"wrong audience", signHS256(t, "new", "new-secret", wrongAudience), false},
+		{"wrong issuer", signHS256(t, "new", "new-secret", wrongIssuer), false},
+		{"no expiry", signHS256(t, "new", "new-secret", noExpiry), false},
+		{"unsigned", unsigned, false},
+		{"malformed", "not.a.token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := authn.Verify(context.Background(), tt.token)
+			if !tt.valid {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("got error %v, want ErrUnauthenticated", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject() != "alice" {
+				t.Errorf("got subject %q", claims.Subject())
+			}
+		})
+	}
+}
+
+func TestAuthenticatorVerifiesRS256TokensFromJWKS(t *testing.T) {
+	current := generateRSAKey(t)
+	keys := &issuerKeys{keys: map[string]*rsa.PrivateKey{"k1": current}}
+	server := serveJWKS(keys)
+	defer server.Close()
+
+	authn, err := NewAuthenticator(Config{JWKSURL: server.URL, JWKSRefresh: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := authn.Verify(ctx, signRS256(t, "k1", current, testClaims("alice", time.Minute))); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if _, err := authn.Verify(ctx, signRS256(t, "k1", current, testClaims("alice", -time.Minute))); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("got error %v for an expired token, want ErrUnauthenticated", err)
+	}
+	forged := generateRSAKey(t)
+	if _, err := authn.Verify(ctx, signRS256(t, "k1", forged, testClaims("alice", time.Minute))); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("got error %v for a token signed with another key, want ErrUnauthenticated", err)
+	}
+	// An HS256 token must not be verified with the RSA public key as its secret
+	if _, err := authn.Verify(ctx, signHS256(t, "k1", string(current.N.Bytes()), testClaims("alice", time.Minute))); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("got error %v for an HS256 token, want ErrUnauthenticated", err)
+	}
+
+	// A key rotated in by the issuer is picked up on first use of its key ID, once the
+	// keys are a tenth of the refresh interval old
+	rotated := generateRSAKey(t)
+	keys.add("k2", rotated)
+	time.Sleep(150 * time.Millisecond)
+	if _, err := authn.Verify(ctx, signRS256(t, "k2", rotated, testClaims("alice", time.Minute))); err != nil {
+		t.Errorf("token signed with a rotated key rejected: %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer  abc ", "abc", true},
+		{"Basic abc", "", false},
+		{"Bearer", "", false},
+		{"Bearer ", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if token, ok := BearerToken(tt.header); token != tt.token || ok != tt.ok {
+			t.Errorf("BearerToken(%q) = %q, %v, want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
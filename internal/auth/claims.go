@@ -0,0 +1,42 @@
+package auth
+
+import "context"
+
+type contextKey struct{}
+
+// Claims are the verified claims of a bearer token, by name
+type Claims map[string]interface{}
+
+// Subject returns the "sub" claim
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Strings returns a claim holding a string or a list of strings, e.g. "aud"
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var result []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// WithClaims returns a copy of ctx carrying the verified claims of the caller
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// ClaimsFromContext returns the verified claims in ctx, if the caller was authenticated
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
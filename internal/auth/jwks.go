@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxJWKSSize bounds the JWKS documents read from files or URLs
+const maxJWKSSize = 1 << 20
+
+// jwk is a JSON Web Key; only RSA signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwks is a JSON Web Key Set document
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// parseJWKS returns the RSA signing keys of a JWKS document by key ID
+func parseJWKS(raw []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") || (key.Alg != "" && key.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of key %q", key.Kid)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// remoteKeys holds the RSA keys of a JWKS file or URL. They are reloaded when they are
+// older than the refresh interval, and early when a token names an unknown key ID, so
+// keys rotated in by the issuer are picked up without a restart.
+type remoteKeys struct {
+	source     string
+	load       func(ctx context.Context) ([]byte, error)
+	refresh    time.Duration
+	minRefresh time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PublicKey
+	loadedAt time.Time
+}
+
+// newFileKeys reads the JWKS at path
+func newFileKeys(path string, refresh time.Duration) *remoteKeys {
+	return &remoteKeys{
+		source:     path,
+		refresh:    refresh,
+		minRefresh: refresh / 10,
+		load: func(ctx context.Context) ([]byte, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return io.ReadAll(io.LimitReader(f, maxJWKSSize))
+		},
+	}
+}
+
+// newURLKeys fetches the JWKS at url
+func newURLKeys(url string, refresh time.Duration, client *http.Client) *remoteKeys {
+	return &remoteKeys{
+		source:     url,
+		refresh:    refresh,
+		minRefresh: refresh / 10,
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+		},
+	}
+}
+
+// lookup returns the key with the given ID, or all keys for an empty ID
+func (k *remoteKeys) lookup(ctx context.Context, kid string) []*rsa.PublicKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	age := time.Since(k.loadedAt)
+	_, known := k.keys[kid]
+	if age > k.refresh || (kid != "" && !known && age > k.minRefresh) {
+		k.reload(ctx)
+	}
+
+	if kid != "" {
+		if key, ok := k.keys[kid]; ok {
+			return []*rsa.PublicKey{key}
+		}
+		return nil
+	}
+	keys := make([]*rsa.PublicKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// reload reads the key set again. On failure the previous keys stay in use. Callers must hold the lock.
+func (k *remoteKeys) reload(ctx context.Context) {
+	k.loadedAt = time.Now()
+	raw, err := k.load(ctx)
+	if err != nil {
+		log.Println("Failed to load JWKS:", k.source, err)
+		return
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		log.Println("Failed to load JWKS:", k.source, err)
+		return
+	}
+	k.keys = keys
+}
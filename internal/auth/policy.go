@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrForbidden is reported when the caller lacks a permission
+var ErrForbidden = errors.New("forbidden")
+
+// Permissions on products
+const (
+	PermProductsRead   = "products:read"
+	PermProductsWrite  = "products:write"
+	PermProductsDelete = "products:delete"
+	PermProductsBulk   = "products:bulk"
+)
+
+// PermAPIKeysManage allows creating, rotating and revoking API keys
+const PermAPIKeysManage = "api_keys:manage"
+
+// PermWebhooksManage allows registering webhooks and reading their deliveries
+const PermWebhooksManage = "webhooks:manage"
+
+// PermAuditRead allows reading the audit log
+const PermAuditRead = "audit:read"
+
+// ScopesClaim lists permissions granted to the caller directly rather than through
+// roles. API keys carry their scopes in it.
+const ScopesClaim = "scopes"
+
+// Policy grants permissions to the roles listed in a token's roles claim. A grant of
+// "products:*" covers every product permission and "*" covers all permissions.
+type Policy struct {
+	// RolesClaim names the claim listing the caller's roles, "roles" unless set
+	RolesClaim string `json:"roles_claim"`
+	// Roles maps role names to the permissions they grant
+	Roles map[string][]string `json:"roles"`
+	// Anonymous lists the permissions of callers without credentials on routes where
+	// authentication is optional, also when no token key is configured
+	Anonymous []string `json:"anonymous"`
+}
+
+// DefaultPolicy lets viewers read, catalog editors also create and update, and admins do everything
+func DefaultPolicy() *Policy {
+	return &Policy{
+		RolesClaim: "roles",
+		Roles: map[string][]string{
+			"viewer": {PermProductsRead},
+			"editor": {PermProductsRead, PermProductsWrite},
+			"admin":  {"products:*", "api_keys:*", "webhooks:*", "audit:*"},
+		},
+		Anonymous: []string{PermProductsRead},
+	}
+}
+
+// LoadPolicy reads a policy from a JSON file, e.g.
+//
+//	{"roles": {"editor": ["products:read", "products:write"], "admin": ["*"]}, "anonymous": []}
+func LoadPolicy(path string) (*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy: %w", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return nil, fmt.Errorf("invalid access policy %s: %w", path, err)
+	}
+	if policy.RolesClaim == "" {
+		policy.RolesClaim = "roles"
+	}
+	for role, grants := range policy.Roles {
+		for _, grant := range grants {
+			if !ValidGrant(grant) {
+				return nil, fmt.Errorf("invalid permission '%s' of role '%s' in %s", grant, role, path)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// Allows reports whether a caller with the given claims holds a permission, through one
+// of its roles or its scopes. Callers without claims get the anonymous permissions.
+func (p *Policy) Allows(claims Claims, authenticated bool, permission string) bool {
+	if !authenticated {
+		return grants(p.Anonymous, permission)
+	}
+	if grants(claims.Strings(ScopesClaim), permission) {
+		return true
+	}
+	for _, role := range claims.Strings(p.RolesClaim) {
+		if grants(p.Roles[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidGrant reports whether a permission grant is well formed: "*", "resource:*" or
+// "resource:action"
+func ValidGrant(grant string) bool {
+	resource, action, ok := strings.Cut(grant, ":")
+	return grant == "*" || (ok && resource != "" && action != "")
+}
+
+// grants reports whether a list of granted permissions covers a permission
+func grants(granted []string, permission string) bool {
+	resource, _, _ := strings.Cut(permission, ":")
+	for _, grant := range granted {
+		if grant == "*" || grant == permission || grant == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package auth
+
+import "testing"
+
+func TestDefaultPolicyAllows(t *testing.T) {
+	policy := DefaultPolicy()
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{"viewer", PermProductsRead, true},
+		{"viewer", PermProductsWrite, false},
+		{"editor", PermProductsWrite, true},
+		{"editor", PermProductsDelete, false},
+		{"editor", PermWebhooksManage, false},
+		{"editor", PermAuditRead, false},
+		{"admin", PermProductsBulk, true},
+		{"admin", PermAPIKeysManage, true},
+		{"admin", PermWebhooksManage, true},
+		{"admin", PermAuditRead, true},
+	}
+	for _, tt := range tests {
+		claims := Claims{"sub": "tester", "roles": []interface{}{tt.role}}
+		if got := policy.Allows(claims, true, tt.permission); got != tt.want {
+			t.Errorf("%s allowed %s: got %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+	for _, permission := range []string{PermWebhooksManage, PermAuditRead, PermAPIKeysManage} {
+		if policy.Allows(nil, false, permission) {
+			t.Errorf("anonymous callers allowed %s", permission)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type APIKeyController struct {
+	service  *usecase.APIKeyService
+	validate *validator.Validate
+}
+
+func NewAPIKeyController(s *usecase.APIKeyService) *APIKeyController {
+	return &APIKeyController{
+		service:  s,
+		validate: validator.New(),
+	}
+}
+
+// withoutHash hides the key hash, which is of no use to clients
+func withoutHash(key entity.APIKey) entity.APIKey {
+	key.Hash = ""
+	return key
+}
+
+// CreateAPIKey issues an API key. The key is only part of this response.
+func (c *APIKeyController) CreateAPIKey(ctx *gin.Context) {
+	var key entity.APIKey
+	if err := ctx.ShouldBindJSON(&key); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	if err := c.validate.Struct(key); err != nil {
+		ctx.Error(validationError("Validation failed", err))
+		return
+	}
+
+	created, secret, err := c.service.CreateAPIKey(ctx.Request.Context(), key)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Header("Location", path.Join(ctx.Request.URL.Path, url.PathEscape(created.ID)))
+	ctx.JSON(http.StatusCreated, gin.H{"message": "API key created successfully", "api_key": withoutHash(*created), "key": secret})
+}
+
+func (c *APIKeyController) ListAPIKeys(ctx *gin.Context) {
+	keys, err := c.service.ListAPIKeys(ctx.Request.Context())
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	for i := range keys {
+		keys[i] = withoutHash(keys[i])
+	}
+	ctx.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (c *APIKeyController) GetAPIKeyById(ctx *gin.Context) {
+	key, err := c.service.GetAPIKeyById(ctx.Request.Context(), ctx.Param("_id"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"api_key": withoutHash(*key)})
+}
+
+// RotateAPIKey issues a new key for an API key; the old one stops working. An optional
+// body {"expires_at": "..."} moves the expiry.
+func (c *APIKeyController) RotateAPIKey(ctx *gin.Context) {
+	var body struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+			return
+		}
+	}
+
+	id := ctx.Param("_id")
+	existing, err := c.service.GetAPIKeyById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Without If-Match the rotation applies to whatever revision is current
+	conditional, err := checkIfMatch(ctx, existing.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	rotated, secret, err := c.service.RotateAPIKey(ctx.Request.Context(), id, existing.Rev, body.ExpiresAt)
+	if err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key rotated successfully", "api_key": withoutHash(*rotated), "key": secret})
+}
+
+// RevokeAPIKey disables an API key for good. It stays listed with its revocation time.
+func (c *APIKeyController) RevokeAPIKey(ctx *gin.Context) {
+	id := ctx.Param("_id")
+	existing, err := c.service.GetAPIKeyById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Without If-Match the revocation applies to whatever revision is current
+	conditional, err := checkIfMatch(ctx, existing.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	revoked, err := c.service.RevokeAPIKey(ctx.Request.Context(), id, existing.Rev)
+	if err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully", "api_key": withoutHash(*revoked)})
+}
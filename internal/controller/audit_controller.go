@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditController struct {
+	service *usecase.AuditService
+}
+
+func NewAuditController(s *usecase.AuditService) *AuditController {
+	return &AuditController{service: s}
+}
+
+// ListAuditEntries returns the audit log, newest entry first. It can be filtered by
+// ?actor=, ?product_id= and a time range given as RFC 3339 ?from= and ?to=.
+func (c *AuditController) ListAuditEntries(ctx *gin.Context) {
+	q := repository.AuditQuery{
+		Actor:     ctx.Query("actor"),
+		ProductID: ctx.Query("product_id"),
+		Cursor:    ctx.Query("cursor"),
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("limit must be an integer", nil))
+			return
+		}
+		q.Limit = n
+	}
+	for param, target := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		value := ctx.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			ctx.Error(repository.NewValidationError(param+" must be an RFC 3339 time", nil))
+			return
+		}
+		*target = t
+	}
+
+	page, err := c.service.ListAuditEntries(ctx.Request.Context(), q)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// changesHeartbeat is how often an SSE comment is sent on an idle stream, so proxies
+// keep the connection open and clients notice dead connections
+const changesHeartbeat = 15 * time.Second
+
+// StreamProductChanges streams product changes as Server-Sent Events. Each event is
+// named after the change type and carries the feed sequence as its ID, so a reconnecting
+// EventSource resumes through Last-Event-ID. Without it the stream starts at ?since=,
+// or at the current end of the feed.
+func (c *ProductController) StreamProductChanges(ctx *gin.Context) {
+	since := ctx.GetHeader("Last-Event-ID")
+	if since == "" {
+		since = ctx.DefaultQuery("since", repository.SinceNow)
+	}
+
+	// Cancelling the feed context stops the follower once the client disconnects
+	feedCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+
+	feed, err := c.service.WatchProducts(feedCtx, since)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	defer feed.Close()
+
+	changes := make(chan repository.ProductChange)
+	go func() {
+		defer close(changes)
+		for feed.Next() {
+			select {
+			case changes <- feed.Change():
+			case <-feedCtx.Done():
+				return
+			}
+		}
+	}()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no") // Disable response buffering in nginx
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(changesHeartbeat)
+	defer heartbeat.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				if err := feed.Err(); err != nil {
+					log.Println("Product change stream ended:", err)
+				}
+				return false
+			}
+			ctx.Render(-1, sse.Event{
+				Id:    change.Seq,
+				Event: change.Type,
+				Data:  change,
+			})
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		case <-feedCtx.Done():
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveRequest selects how the conflicts of a product are resolved. Rev is the
+// revision to keep with the manual strategy.
+type resolveRequest struct {
+	Strategy string `json:"strategy"`
+	Rev      string `json:"rev"`
+}
+
+// ListConflicts returns one page of products with conflicting revisions, ordered by ID
+func (c *ProductController) ListConflicts(ctx *gin.Context) {
+	opts := repository.ListOptions{Cursor: ctx.Query("cursor")}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("limit must be an integer", nil))
+			return
+		}
+		opts.Limit = n
+	}
+
+	page, err := c.service.ListConflicts(ctx.Request.Context(), opts)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// ResolveProductConflicts resolves the conflicting revisions of a product with the
+// strategy named in the body: "last_writer_wins" (default), "merge", or "manual" with
+// the revision to keep. If-Match is checked against the current revision.
+func (c *ProductController) ResolveProductConflicts(ctx *gin.Context) {
+	var req resolveRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	strategy, err := usecase.NewConflictStrategy(req.Strategy, req.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	conditional := false
+	precondition := func(currentRev string) error {
+		var err error
+		conditional, err = checkIfMatch(ctx, currentRev)
+		return err
+	}
+	resolved, err := c.service.ResolveProductConflicts(ctx.Request.Context(), ctx.Param("_id"), strategy, precondition)
+	if err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.Header("ETag", etag(resolved.Rev))
+	ctx.JSON(http.StatusOK, gin.H{"message": "Product conflicts resolved successfully", "product": resolved})
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag formats a CouchDB revision as a strong entity tag
+func etag(rev string) string {
+	return `"` + rev + `"`
+}
+
+// parseETags splits an If-Match or If-None-Match header into bare revisions.
+// Weak tags are compared like strong ones, since a revision identifies the exact body.
+func parseETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		tag = strings.TrimPrefix(tag, "W/")
+		tag = strings.Trim(tag, `"`)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// matchesETag reports whether any tag in the header matches the revision
+func matchesETag(header string, rev string) bool {
+	for _, tag := range parseETags(header) {
+		if tag == "*" || tag == rev {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header against the current revision. It reports
+// whether the request is conditional and fails with ErrPrecondition when no tag matches.
+func checkIfMatch(ctx *gin.Context, currentRev string) (bool, error) {
+	header := ctx.GetHeader("If-Match")
+	if header == "" {
+		return false, nil
+	}
+	if !matchesETag(header, currentRev) {
+		return true, repository.NewPreconditionFailedError(
+			fmt.Sprintf("If-Match %s does not match the current revision", header), nil)
+	}
+	return true, nil
+}
+
+// conditionalWriteError turns a revision conflict on a conditional request into a
+// precondition failure: the document changed after If-Match was checked
+func conditionalWriteError(conditional bool, err error) error {
+	if conditional && errors.Is(err, repository.ErrRevisionConflict) {
+		return repository.NewPreconditionFailedError("the product was modified concurrently", err)
+	}
+	return err
+}
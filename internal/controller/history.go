@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProductHistory returns the versions of a product, newest first. Each version
+// carries the time and actor of its write in updated_at and updated_by.
+func (c *ProductController) GetProductHistory(ctx *gin.Context) {
+	opts := repository.ListOptions{Cursor: ctx.Query("cursor")}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("limit must be an integer", nil))
+			return
+		}
+		opts.Limit = n
+	}
+
+	page, err := c.service.GetProductHistory(ctx.Request.Context(), ctx.Param("_id"), opts)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// RevertProductById restores the name and price of the version given by ?to=<rev> as
+// a new revision of the product
+func (c *ProductController) RevertProductById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+
+	// Fetch the existing product to evaluate If-Match
+	existingProduct, err := c.service.GetProductById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	conditional, err := checkIfMatch(ctx, existingProduct.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	reverted, err := c.service.RevertProductById(ctx.Request.Context(), id, ctx.Query("to"), existingProduct.Rev)
+	if err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.Header("ETag", etag(reverted.Rev))
+	ctx.JSON(http.StatusOK, gin.H{"message": "Product reverted successfully", "product": reverted})
+}
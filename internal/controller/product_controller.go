@@ -1,271 +1,503 @@
-package controller
-
-import (
-	"net/http"
-	"fmt"
-	"e-learning/go-with-couchdb/internal/entity"
-	"e-learning/go-with-couchdb/internal/usecase"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-)
-
-type ProductController struct {
-	service  *usecase.ProductService
-	validate *validator.Validate
-}
-
-func NewProductController(s *usecase.ProductService) *ProductController {
-	return &ProductController{
-		service:  s,
-		validate: validator.New(),
-	}
-}
-
-func (c *ProductController) CreateProduct(ctx *gin.Context) {
-	var product entity.Product
-	if err := ctx.ShouldBindJSON(&product); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
-		return
-	}
-
-	if err := c.validate.Struct(product); err != nil {
-		validationErrors := err.(validator.ValidationErrors)
-		errorMessages := make(map[string]string)
-		for _, fieldError := range validationErrors {
-			errorMessages[fieldError.Field()] = fieldError.Error()
-		}
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Validation failed",
-			"details": errorMessages,
-		})
-		return
-	}
-
-	// Pass the request context to the service
-	if err := c.service.CreateProduct(ctx.Request.Context(), product); err != nil {
-		// Check for specific errors (e.g., duplicate name)
-		if err.Error() == fmt.Sprintf("product with name '%s' already exists", product.Name) {
-			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product: " + err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusCreated, gin.H{"message": "Product created successfully", "product": product})
-}
-
-func (c *ProductController) GetAllProducts(ctx *gin.Context) {
-	products, err := c.service.GetAllProducts(ctx.Request.Context())
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products: " + err.Error()})
-		return
-	}
-	ctx.JSON(http.StatusOK, gin.H{"products": products})
-}
-
-func (c *ProductController) GetProductById(ctx *gin.Context) {
-	id := ctx.Param("_id")
-	if id == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
-		return
-	}
-
-	product, err := c.service.GetProductById(ctx.Request.Context(), id)
-	if err != nil {
-		if err.Error() == fmt.Sprintf("product with ID %s not found", id) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product: " + err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{"product": product})
-}
-
-func (c *ProductController) UpdateProductById(ctx *gin.Context) {
-	id := ctx.Param("_id")
-	if id == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
-		return
-	}
-
-	// Fetch the existing product to get the current revision
-	existingProduct, err := c.service.GetProductById(ctx.Request.Context(), id)
-	if err != nil {
-		if err.Error() == fmt.Sprintf("product with ID %s not found", id) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product: " + err.Error()})
-		return
-	}
-
-	var updatedProduct entity.Product
-	if err := ctx.ShouldBindJSON(&updatedProduct); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
-		return
-	}
-
-	// Validate the updated product
-	if err := c.validate.Struct(updatedProduct); err != nil {
-		validationErrors := err.(validator.ValidationErrors)
-		errorMessages := make(map[string]string)
-		for _, fieldError := range validationErrors {
-			errorMessages[fieldError.Field()] = fieldError.Error()
-		}
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Validation failed",
-			"details": errorMessages,
-		})
-		return
-	}
-
-	// Update the product
-	err = c.service.UpdateProductById(ctx.Request.Context(), id, updatedProduct)
-	if err != nil {
-		if err.Error() == fmt.Sprintf("product with name '%s' already exists", updatedProduct.Name) {
-			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-		if err.Error() == fmt.Sprintf("revision mismatch: expected %s, got %s", existingProduct.Rev, updatedProduct.Rev) {
-			ctx.JSON(http.StatusConflict, gin.H{"error": "Revision mismatch, please refresh and try again"})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product: " + err.Error()})
-		return
-	}
-
-	// Fetch the updated product to return the latest revision
-	updated, err := c.service.GetProductById(ctx.Request.Context(), id)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated product: " + err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "product": updated})
-}
-
-func (c *ProductController) DeleteProductById(ctx *gin.Context) {
-	id := ctx.Param("_id")
-	if id == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
-		return
-	}
-
-	// Fetch the existing product to get the current revision
-	existingProduct, err := c.service.GetProductById(ctx.Request.Context(), id)
-	if err != nil {
-		if err.Error() == fmt.Sprintf("product with ID %s not found", id) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product: " + err.Error()})
-		return
-	}
-
-	// Delete the product
-	err = c.service.DeleteProductById(ctx.Request.Context(), id, existingProduct.Rev)
-	if err != nil {
-		if err.Error() == fmt.Sprintf("product with ID %s not found", id) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product: " + err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
-}
-
-func (c *ProductController) BulkCreateProducts(ctx *gin.Context) {
-	var products []entity.Product
-	if err := ctx.ShouldBindJSON(&products); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
-		return
-	}
-
-	// Validate each product
-	for i, product := range products {
-		if err := c.validate.Struct(product); err != nil {
-			validationErrors := err.(validator.ValidationErrors)
-			errorMessages := make(map[string]string)
-			for _, fieldError := range validationErrors {
-				errorMessages[fieldError.Field()] = fieldError.Error()
-			}
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error":   fmt.Sprintf("Validation failed for product at index %d", i),
-				"details": errorMessages,
-			})
-			return
-		}
-	}
-
-	if err := c.service.BulkCreateProducts(ctx.Request.Context(), products); err != nil {
-		if err.Error() == "no valid products to create" {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "No valid products to create"})
-			return
-		}
-		// Check for duplicate name errors
-		for _, product := range products {
-			if err.Error() == fmt.Sprintf("product with name '%s' already exists", product.Name) {
-				ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-				return
-			}
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create products in bulk: " + err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusCreated, gin.H{"message": "Products created successfully", "products": products})
-}
-
-func (c *ProductController) BulkUpdateProducts(ctx *gin.Context) {
-	var products []entity.Product
-	if err := ctx.ShouldBindJSON(&products); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
-		return
-	}
-
-	// Validate each product and ensure ID and Rev are present
-	for i, product := range products {
-		if product.ID == "" || product.Rev == "" {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Product at index %d is missing ID or Rev", i),
-			})
-			return
-		}
-		if err := c.validate.Struct(product); err != nil {
-			validationErrors := err.(validator.ValidationErrors)
-			errorMessages := make(map[string]string)
-			for _, fieldError := range validationErrors {
-				errorMessages[fieldError.Field()] = fieldError.Error()
-			}
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error":   fmt.Sprintf("Validation failed for product at index %d", i),
-				"details": errorMessages,
-			})
-			return
-		}
-	}
-
-	if err := c.service.BulkUpdateProducts(ctx.Request.Context(), products); err != nil {
-		if err.Error() == "no valid products to update" {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "No valid products to update"})
-			return
-		}
-		// Check for duplicate name errors
-		for _, product := range products {
-			if err.Error() == fmt.Sprintf("product with name '%s' already exists", product.Name) {
-				ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-				return
-			}
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update products in bulk: " + err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{"message": "Products updated successfully"})
-}
\ No newline at end of file
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/middleware"
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type ProductController struct {
+	service  *usecase.ProductService
+	validate *validator.Validate
+}
+
+func NewProductController(s *usecase.ProductService) *ProductController {
+	return &ProductController{
+		service:  s,
+		validate: validator.New(),
+	}
+}
+
+func (c *ProductController) CreateProduct(ctx *gin.Context) {
+	var product entity.Product
+	if err := ctx.ShouldBindJSON(&product); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	if err := c.validate.Struct(product); err != nil {
+		ctx.Error(validationError("Validation failed", err))
+		return
+	}
+	if err := repository.CheckProductID(product.ID); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Pass the request context to the service
+	created, err := c.service.CreateProduct(ctx.Request.Context(), product)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Header("Location", path.Join(ctx.Request.URL.Path, url.PathEscape(created.ID)))
+	ctx.Header("ETag", etag(created.Rev))
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Product created successfully", "product": created})
+}
+
+// filterParams maps the query parameters accepted by GET /products to search conditions
+var filterParams = []struct {
+	param    string
+	field    string
+	operator string
+}{
+	{"name", "name", repository.OpEq},
+	{"name_prefix", "name", repository.OpPrefix},
+	{"price", "price", repository.OpEq},
+	{"price_gt", "price", repository.OpGt},
+	{"price_gte", "price", repository.OpGte},
+	{"price_lt", "price", repository.OpLt},
+	{"price_lte", "price", repository.OpLte},
+}
+
+// searchRequest is the body of POST /products/_search
+type searchRequest struct {
+	Selector map[string]interface{} `json:"selector" binding:"required"`
+	Sort     string                 `json:"sort"`
+	Limit    int                    `json:"limit"`
+	Cursor   string                 `json:"cursor"`
+}
+
+func (c *ProductController) GetAllProducts(ctx *gin.Context) {
+	opts := repository.ListOptions{
+		Cursor: ctx.Query("cursor"),
+		Sort:   ctx.Query("sort"),
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("limit must be an integer", nil))
+			return
+		}
+		opts.Limit = n
+	}
+
+	// Any filter parameter turns the listing into a Mango search
+	conditions, err := filterConditions(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if len(conditions) > 0 {
+		page, err := c.service.SearchProducts(ctx.Request.Context(), repository.ProductQuery{
+			Conditions: conditions,
+			Sort:       opts.Sort,
+			Limit:      opts.Limit,
+			Cursor:     opts.Cursor,
+		})
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		ctx.JSON(http.StatusOK, page)
+		return
+	}
+
+	page, err := c.service.GetAllProducts(ctx.Request.Context(), opts)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func (c *ProductController) SearchProducts(ctx *gin.Context) {
+	var req searchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	conditions, err := repository.ParseSelector(req.Selector)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	page, err := c.service.SearchProducts(ctx.Request.Context(), repository.ProductQuery{
+		Conditions: conditions,
+		Sort:       req.Sort,
+		Limit:      req.Limit,
+		Cursor:     req.Cursor,
+	})
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func (c *ProductController) GetProductById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+	if id == "" {
+		ctx.Error(repository.NewValidationError("Product ID is required", nil))
+		return
+	}
+
+	// ?conflicts=true also lists the revisions that conflict with the current one
+	withConflicts := ctx.Query("conflicts") == "true"
+	var product *entity.Product
+	var err error
+	if withConflicts {
+		product, err = c.service.GetProductWithConflicts(ctx.Request.Context(), id)
+	} else {
+		product, err = c.service.GetProductById(ctx.Request.Context(), id)
+	}
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// The revision doubles as the entity tag, so clients can revalidate cheaply
+	ctx.Header("ETag", etag(product.Rev))
+	if header := ctx.GetHeader("If-None-Match"); header != "" && matchesETag(header, product.Rev) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	if withConflicts {
+		ctx.JSON(http.StatusOK, gin.H{"product": product, "conflicted": len(product.Conflicts) > 0})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"product": product})
+}
+
+func (c *ProductController) UpdateProductById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+	if id == "" {
+		ctx.Error(repository.NewValidationError("Product ID is required", nil))
+		return
+	}
+
+	var updatedProduct entity.Product
+	if err := ctx.ShouldBindJSON(&updatedProduct); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	// Validate the updated product
+	if err := c.validate.Struct(updatedProduct); err != nil {
+		ctx.Error(validationError("Validation failed", err))
+		return
+	}
+
+	// Fetch the existing product to evaluate If-Match
+	existingProduct, err := c.service.GetProductById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	conditional, err := checkIfMatch(ctx, existingProduct.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if conditional {
+		// If-Match takes precedence over the _rev in the body
+		updatedProduct.Rev = existingProduct.Rev
+	}
+
+	// Update the product; the stored product carries the latest revision
+	updated, err := c.service.UpdateProductById(ctx.Request.Context(), id, updatedProduct)
+	if err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.Header("ETag", etag(updated.Rev))
+	ctx.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "product": updated})
+}
+
+// Media types accepted by PATCH /products/:_id
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+func (c *ProductController) PatchProductById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+	if id == "" {
+		ctx.Error(repository.NewValidationError("Product ID is required", nil))
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	var apply func(doc []byte) ([]byte, error)
+	switch ctx.ContentType() {
+	case mergePatchMediaType: // RFC 7396
+		if err := checkMergePatchFields(body); err != nil {
+			ctx.Error(err)
+			return
+		}
+		apply = func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, body)
+		}
+	case jsonPatchMediaType: // RFC 6902
+		operations, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("Invalid patch: "+err.Error(), nil))
+			return
+		}
+		if err := checkJSONPatchPaths(operations); err != nil {
+			ctx.Error(err)
+			return
+		}
+		apply = operations.Apply
+	default:
+		ctx.Error(fmt.Errorf("%w: use %s or %s", middleware.ErrUnsupportedMediaType, mergePatchMediaType, jsonPatchMediaType))
+		return
+	}
+
+	updated, err := c.service.PatchProductById(ctx.Request.Context(), id, usecase.ProductPatch{
+		Apply: apply,
+		Precondition: func(currentRev string) (bool, error) {
+			return checkIfMatch(ctx, currentRev)
+		},
+		Validate: func(product entity.Product) error {
+			if err := c.validate.Struct(product); err != nil {
+				return validationError("Validation failed", err)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Header("ETag", etag(updated.Rev))
+	ctx.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "product": updated})
+}
+
+// patchableFields are the product fields clients may patch; the others are managed by
+// the repository
+var patchableFields = map[string]bool{"name": true, "price": true}
+
+// checkMergePatchFields rejects merge patches setting fields other than the patchable ones.
+// Bodies that are not JSON objects are left to the patch itself to reject.
+func checkMergePatchFields(body []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	for field := range fields {
+		if !patchableFields[field] {
+			return repository.NewUnpatchableError("/" + field)
+		}
+	}
+	return nil
+}
+
+// checkJSONPatchPaths rejects JSON Patch operations on paths other than the patchable
+// fields. test operations only read, so they may check any path; move also removes
+// its from path.
+func checkJSONPatchPaths(operations jsonpatch.Patch) error {
+	for _, operation := range operations {
+		if operation.Kind() == "test" {
+			continue
+		}
+		paths := []func() (string, error){operation.Path}
+		if operation.Kind() == "move" {
+			paths = append(paths, operation.From)
+		}
+		for _, get := range paths {
+			p, err := get()
+			if err != nil {
+				return repository.NewValidationError("Invalid patch: "+err.Error(), nil)
+			}
+			if !patchableFields[strings.TrimPrefix(p, "/")] {
+				return repository.NewUnpatchableError(p)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ProductController) DeleteProductById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+	if id == "" {
+		ctx.Error(repository.NewValidationError("Product ID is required", nil))
+		return
+	}
+
+	// Fetch the existing product to get the current revision
+	existingProduct, err := c.service.GetProductById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Without If-Match the delete applies to whatever revision is current
+	conditional, err := checkIfMatch(ctx, existingProduct.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Delete the product
+	if err := c.service.DeleteProductById(ctx.Request.Context(), id, existingProduct.Rev); err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+}
+
+func (c *ProductController) BulkCreateProducts(ctx *gin.Context) {
+	var products []entity.Product
+	if err := ctx.ShouldBindJSON(&products); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	c.runBulk(ctx, products, http.StatusCreated, c.service.BulkCreateProducts)
+}
+
+func (c *ProductController) BulkUpdateProducts(ctx *gin.Context) {
+	var products []entity.Product
+	if err := ctx.ShouldBindJSON(&products); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	c.runBulk(ctx, products, http.StatusOK, c.service.BulkUpdateProducts)
+}
+
+// bulkWriter is the signature shared by the service's bulk operations
+type bulkWriter func(context.Context, []entity.Product, repository.BulkOptions) ([]repository.BulkItemResult, error)
+
+// runBulk validates every item, writes the valid ones and responds with one result per item.
+// The response is successStatus when every item succeeded and 207 Multi-Status otherwise.
+// With ?all_or_nothing=true a single failing item aborts the whole batch.
+func (c *ProductController) runBulk(ctx *gin.Context, products []entity.Product, successStatus int, write bulkWriter) {
+	if len(products) == 0 {
+		ctx.Error(repository.NewValidationError("at least one product is required", nil))
+		return
+	}
+	allOrNothing, err := strconv.ParseBool(ctx.DefaultQuery("all_or_nothing", "false"))
+	if err != nil {
+		ctx.Error(repository.NewValidationError("all_or_nothing must be a boolean", nil))
+		return
+	}
+
+	// Validate each product
+	results := make([]repository.BulkItemResult, len(products))
+	var valid []entity.Product
+	var indexes []int
+	for i, product := range products {
+		results[i] = repository.BulkItemResult{Index: i, ID: product.ID}
+		if err := c.validate.Struct(product); err != nil {
+			results[i].Err = validationError(fmt.Sprintf("Validation failed for product at index %d", i), err)
+			continue
+		}
+		if err := repository.CheckProductID(product.ID); err != nil {
+			results[i].Err = err
+			continue
+		}
+		valid = append(valid, product)
+		indexes = append(indexes, i)
+	}
+
+	if allOrNothing && len(valid) < len(products) {
+		repository.AbortPending(results)
+	} else if len(valid) > 0 {
+		written, err := write(ctx.Request.Context(), valid, repository.BulkOptions{AllOrNothing: allOrNothing})
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		for n, result := range written {
+			result.Index = indexes[n]
+			results[indexes[n]] = result
+		}
+	}
+
+	status := successStatus
+	failed := 0
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Status = successStatus
+			continue
+		}
+		itemStatus, body := middleware.ResolveError(results[i].Err)
+		results[i].Status = itemStatus
+		results[i].Code = body.Code
+		results[i].Error = body.Message
+		results[i].Details = body.Details
+		status = http.StatusMultiStatus
+		failed++
+	}
+
+	ctx.JSON(status, gin.H{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
+
+// validationError converts validator errors into a repository.ErrValidation error with per-field details
+func validationError(message string, err error) error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return repository.NewValidationError(message+": "+err.Error(), nil)
+	}
+
+	errorMessages := make(map[string]string)
+	for _, fieldError := range validationErrors {
+		errorMessages[fieldError.Field()] = fieldError.Error()
+	}
+	return repository.NewValidationError(message, errorMessages)
+}
+
+// filterConditions builds search conditions from the filter query parameters
+func filterConditions(ctx *gin.Context) ([]repository.Condition, error) {
+	var conditions []repository.Condition
+	for _, p := range filterParams {
+		raw, ok := ctx.GetQuery(p.param)
+		if !ok {
+			continue
+		}
+
+		var value interface{} = raw
+		if p.field == "price" {
+			price, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, repository.NewValidationError(fmt.Sprintf("%s must be a number", p.param), nil)
+			}
+			value = price
+		}
+		conditions = append(conditions, repository.Condition{Field: p.field, Operator: p.operator, Value: value})
+	}
+	return conditions, nil
+}
@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/middleware"
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newProductTestRouter serves the product routes from an in-memory repository
+func newProductTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	c := NewProductController(usecase.NewProductService(repository.NewMemoryProductRepo()))
+
+	r := gin.New()
+	r.Use(middleware.ErrorHandler())
+	products := r.Group("/api/v1/products")
+	products.POST("", c.CreateProduct)
+	products.GET("", c.GetAllProducts)
+	products.GET("/:_id", c.GetProductById)
+	products.PUT("/:_id", c.UpdateProductById)
+	products.PATCH("/:_id", c.PatchProductById)
+	products.DELETE("/:_id", c.DeleteProductById)
+	products.POST("/bulk-create", c.BulkCreateProducts)
+	return r
+}
+
+// serve sends a request to the router; headers are given as name, value pairs
+func serve(r http.Handler, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// productResponse is the body of responses carrying a single product
+type productResponse struct {
+	Product entity.Product `json:"product"`
+}
+
+func decodeProduct(t *testing.T, w *httptest.ResponseRecorder) entity.Product {
+	t.Helper()
+	var body productResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body, err)
+	}
+	return body.Product
+}
+
+// errorCode returns the code of an error response
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error response %s: %v", w.Body, err)
+	}
+	return body.Error.Code
+}
+
+func TestCreateProduct(t *testing.T) {
+	r := newProductTestRouter()
+
+	w := serve(r, http.MethodPost, "/api/v1/products", `{"_id":"laptop","name":"Laptop","price":999}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	created := decodeProduct(t, w)
+	if w.Header().Get("Location") != "/api/v1/products/laptop" {
+		t.Errorf("got Location %q", w.Header().Get("Location"))
+	}
+	if w.Header().Get("ETag") != `"`+created.Rev+`"` {
+		t.Errorf("got ETag %q for revision %s", w.Header().Get("ETag"), created.Rev)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"malformed JSON", `{"name":`, http.StatusBadRequest},
+		{"name too short", `{"name":"TV","price":10}`, http.StatusBadRequest},
+		{"price missing", `{"name":"Keyboard"}`, http.StatusBadRequest},
+		{"duplicate name", `{"name":"LAPTOP","price":10}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(r, http.MethodPost, "/api/v1/products", tt.body); w.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetProductById(t *testing.T) {
+	r := newProductTestRouter()
+	created := decodeProduct(t, serve(r, http.MethodPost, "/api/v1/products", `{"_id":"laptop","name":"Laptop","price":999}`))
+
+	w := serve(r, http.MethodGet, "/api/v1/products/laptop", ``)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if got := decodeProduct(t, w); got.Name != "Laptop" || got.Rev != created.Rev {
+		t.Errorf("got %+v", got)
+	}
+
+	if w := serve(r, http.MethodGet, "/api/v1/products/laptop", ``, "If-None-Match", `"`+created.Rev+`"`); w.Code != http.StatusNotModified {
+		t.Errorf("got status %d for a matching If-None-Match, want 304", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/api/v1/products/missing", ``); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a missing product, want 404", w.Code)
+	}
+}
+
+func TestUpdateProductById(t *testing.T) {
+	r := newProductTestRouter()
+	created := decodeProduct(t, serve(r, http.MethodPost, "/api/v1/products", `{"_id":"laptop","name":"Laptop","price":999}`))
+
+	w := serve(r, http.MethodPut, "/api/v1/products/laptop", `{"_rev":"`+created.Rev+`","name":"Laptop Pro","price":1299}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	updated := decodeProduct(t, w)
+	if updated.Name != "Laptop Pro" || updated.Rev == created.Rev {
+		t.Errorf("got %+v", updated)
+	}
+
+	// Writing the first revision again conflicts
+	w = serve(r, http.MethodPut, "/api/v1/products/laptop", `{"_rev":"`+created.Rev+`","name":"Laptop Air","price":899}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d for a stale _rev, want 409", w.Code)
+	}
+	w = serve(r, http.MethodPut, "/api/v1/products/laptop", `{"name":"Laptop Air","price":899}`, "If-Match", `"`+created.Rev+`"`)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d for a stale If-Match, want 412", w.Code)
+	}
+}
+
+func TestPatchProductById(t *testing.T) {
+	r := newProductTestRouter()
+	serve(r, http.MethodPost, "/api/v1/products", `{"_id":"laptop","name":"Laptop","price":999}`)
+
+	w := serve(r, http.MethodPatch, "/api/v1/products/laptop", `{"price":1099}`, "Content-Type", "application/merge-patch+json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if got := decodeProduct(t, w); got.Name != "Laptop" || got.Price != 1099 {
+		t.Errorf("got %+v after merge patch", got)
+	}
+
+	w = serve(r, http.MethodPatch, "/api/v1/products/laptop", `[{"op":"replace","path":"/name","value":"Laptop Pro"}]`, "Content-Type", "application/json-patch+json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if got := decodeProduct(t, w); got.Name != "Laptop Pro" || got.Price != 1099 {
+		t.Errorf("got %+v after JSON patch", got)
+	}
+
+	if w := serve(r, http.MethodPatch, "/api/v1/products/laptop", `{"price":1}`); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("got status %d for a plain JSON body, want 415", w.Code)
+	}
+	if w := serve(r, http.MethodPatch, "/api/v1/products/laptop", `{"price":-1}`, "Content-Type", "application/merge-patch+json"); w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid price, want 400", w.Code)
+	}
+}
+
+func TestDeleteProductById(t *testing.T) {
+	r := newProductTestRouter()
+	serve(r, http.MethodPost, "/api/v1/products", `{"_id":"laptop","name":"Laptop","price":999}`)
+
+	if w := serve(r, http.MethodDelete, "/api/v1/products/laptop", ``, "If-Match", `"1-stale"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d for a stale If-Match, want 412", w.Code)
+	}
+	if w := serve(r, http.MethodDelete, "/api/v1/products/laptop", ``); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/api/v1/products/laptop", ``); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d after delete, want 404", w.Code)
+	}
+	if w := serve(r, http.MethodDelete, "/api/v1/products/laptop", ``); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d deleting twice, want 404", w.Code)
+	}
+}
+
+func TestBulkCreateProducts(t *testing.T) {
+	r := newProductTestRouter()
+
+	w := serve(r, http.MethodPost, "/api/v1/products/bulk-create", `[{"name":"Laptop","price":999},{"name":"TV","price":10},{"name":"Mouse","price":25}]`)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d, want 207: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Results   []repository.BulkItemResult `json:"results"`
+		Succeeded int                         `json:"succeeded"`
+		Failed    int                         `json:"failed"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Succeeded != 2 || body.Failed != 1 || body.Results[1].Status != http.StatusBadRequest {
+		t.Errorf("got %+v, want the second item rejected", body)
+	}
+
+	// One invalid item aborts the whole batch
+	w = serve(r, http.MethodPost, "/api/v1/products/bulk-create?all_or_nothing=true", `[{"name":"Keyboard","price":50},{"name":"TV","price":10}]`)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d, want 207: %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/api/v1/products?name=Keyboard", ``); strings.Contains(w.Body.String(), "Keyboard") {
+		t.Errorf("aborted batch wrote a product: %s", w.Body)
+	}
+
+	if w := serve(r, http.MethodPost, "/api/v1/products/bulk-create", `[]`); errorCode(t, w) != "validation_failed" {
+		t.Errorf("got %d %s for an empty batch", w.Code, w.Body)
+	}
+}
+
+func TestCreateProductRejectsReservedIDs(t *testing.T) {
+	r := newProductTestRouter()
+
+	for _, id := range []string{"name:laptop", "history:laptop:1-abc", "event:1", "_design/products"} {
+		w := serve(r, http.MethodPost, "/api/v1/products", `{"_id":"`+id+`","name":"Laptop","price":999}`)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("create of %s got status %d, want 400: %s", id, w.Code, w.Body)
+		}
+	}
+
+	w := serve(r, http.MethodPost, "/api/v1/products/bulk-create", `[{"_id":"name:laptop","name":"Laptop","price":999},{"_id":"mouse","name":"Mouse","price":25}]`)
+	var body struct {
+		Results []repository.BulkItemResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusMultiStatus || body.Results[0].Code != "validation_failed" || body.Results[1].Status != http.StatusCreated {
+		t.Errorf("got %d %s, want only the reserved ID rejected", w.Code, w.Body)
+	}
+}
+
+func TestPatchProductByIdRejectsReadOnlyAndUnknownFields(t *testing.T) {
+	r := newProductTestRouter()
+	created := decodeProduct(t, serve(r, http.MethodPost, "/api/v1/products", `{"_id":"laptop","name":"Laptop","price":999}`))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"merge patch of updated_by", "application/merge-patch+json", `{"price":1099,"updated_by":"mallory"}`},
+		{"merge patch of an unknown field", "application/merge-patch+json", `{"colour":"black"}`},
+		{"merge patch of _rev", "application/merge-patch+json", `{"_rev":"1-abc"}`},
+		{"replace of deleted_at", "application/json-patch+json", `[{"op":"replace","path":"/deleted_at","value":"2020-01-01T00:00:00Z"}]`},
+		{"add of an unknown field", "application/json-patch+json", `[{"op":"add","path":"/colour","value":"black"}]`},
+		{"replace of the whole document", "application/json-patch+json", `[{"op":"replace","path":"","value":{}}]`},
+		{"move into a read-only field", "application/json-patch+json", `[{"op":"move","from":"/name","path":"/updated_by"}]`},
+		{"move out of a read-only field", "application/json-patch+json", `[{"op":"move","from":"/updated_by","path":"/name"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPatch, "/api/v1/products/laptop", tt.body, "Content-Type", tt.contentType)
+			if w.Code != http.StatusUnprocessableEntity || errorCode(t, w) != "unpatchable_field" {
+				t.Errorf("got %d %s, want 422 unpatchable_field", w.Code, w.Body)
+			}
+		})
+	}
+
+	// test operations may read any field
+	w := serve(r, http.MethodPatch, "/api/v1/products/laptop", `[{"op":"test","path":"/_rev","value":"`+created.Rev+`"},{"op":"replace","path":"/price","value":1099}]`, "Content-Type", "application/json-patch+json")
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d for a guarded price change: %s", w.Code, w.Body)
+	}
+}
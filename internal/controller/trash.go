@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListTrash returns one page of soft deleted products, most recently deleted first
+func (c *ProductController) ListTrash(ctx *gin.Context) {
+	opts := repository.ListOptions{Cursor: ctx.Query("cursor")}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("limit must be an integer", nil))
+			return
+		}
+		opts.Limit = n
+	}
+
+	page, err := c.service.ListTrash(ctx.Request.Context(), opts)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// RestoreProductById takes a product out of the trash. The restore fails with 409
+// duplicate_name if another product took the name in the meantime.
+func (c *ProductController) RestoreProductById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+
+	// Fetch the trashed product to get the current revision
+	trashed, err := c.service.GetTrashedProductById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Without If-Match the restore applies to whatever revision is current
+	conditional, err := checkIfMatch(ctx, trashed.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	restored, err := c.service.RestoreProductById(ctx.Request.Context(), id, trashed.Rev)
+	if err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.Header("ETag", etag(restored.Rev))
+	ctx.JSON(http.StatusOK, gin.H{"message": "Product restored successfully", "product": restored})
+}
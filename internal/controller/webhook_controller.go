@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type WebhookController struct {
+	service  *usecase.WebhookService
+	validate *validator.Validate
+}
+
+func NewWebhookController(s *usecase.WebhookService) *WebhookController {
+	return &WebhookController{
+		service:  s,
+		validate: validator.New(),
+	}
+}
+
+// withoutSecret hides the signing secret; it is only shown when a webhook is created
+func withoutSecret(webhook entity.Webhook) entity.Webhook {
+	webhook.Secret = ""
+	return webhook
+}
+
+func (c *WebhookController) CreateWebhook(ctx *gin.Context) {
+	var webhook entity.Webhook
+	if err := ctx.ShouldBindJSON(&webhook); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	if err := c.validate.Struct(webhook); err != nil {
+		ctx.Error(validationError("Validation failed", err))
+		return
+	}
+
+	created, err := c.service.CreateWebhook(ctx.Request.Context(), webhook)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Header("Location", path.Join(ctx.Request.URL.Path, url.PathEscape(created.ID)))
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Webhook created successfully", "webhook": created})
+}
+
+func (c *WebhookController) ListWebhooks(ctx *gin.Context) {
+	webhooks, err := c.service.ListWebhooks(ctx.Request.Context())
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	for i := range webhooks {
+		webhooks[i] = withoutSecret(webhooks[i])
+	}
+	ctx.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+func (c *WebhookController) GetWebhookById(ctx *gin.Context) {
+	webhook, err := c.service.GetWebhookById(ctx.Request.Context(), ctx.Param("_id"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"webhook": withoutSecret(*webhook)})
+}
+
+func (c *WebhookController) UpdateWebhookById(ctx *gin.Context) {
+	var webhook entity.Webhook
+	if err := ctx.ShouldBindJSON(&webhook); err != nil {
+		ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+		return
+	}
+
+	if err := c.validate.Struct(webhook); err != nil {
+		ctx.Error(validationError("Validation failed", err))
+		return
+	}
+
+	updated, err := c.service.UpdateWebhookById(ctx.Request.Context(), ctx.Param("_id"), webhook)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Webhook updated successfully", "webhook": withoutSecret(*updated)})
+}
+
+func (c *WebhookController) DeleteWebhookById(ctx *gin.Context) {
+	id := ctx.Param("_id")
+
+	// Fetch the existing webhook to get the current revision
+	existing, err := c.service.GetWebhookById(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Without If-Match the delete applies to whatever revision is current
+	conditional, err := checkIfMatch(ctx, existing.Rev)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if err := c.service.DeleteWebhookById(ctx.Request.Context(), id, existing.Rev); err != nil {
+		ctx.Error(conditionalWriteError(conditional, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// ListDeliveries returns the delivery log of a webhook, newest first. ?status=dead_letter
+// lists the deliveries that exhausted their retries.
+func (c *WebhookController) ListDeliveries(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "0"))
+	if err != nil {
+		ctx.Error(repository.NewValidationError("limit must be a number", nil))
+		return
+	}
+
+	deliveries, err := c.service.ListDeliveries(ctx.Request.Context(), ctx.Param("_id"), ctx.Query("status"), limit)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/middleware"
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection limits
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 4096
+)
+
+// upgrader keeps gorilla's default same-origin check
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsRequest is a message sent by a WebSocket client, e.g.
+// {"type": "subscribe", "subscription": "cheap", "max_price": 20}
+type wsRequest struct {
+	Type         string `json:"type"`
+	Subscription string `json:"subscription"`
+	usecase.ProductFilter
+}
+
+// wsMessage is a message sent to a WebSocket client
+type wsMessage struct {
+	Type          string                    `json:"type"`
+	Subscription  string                    `json:"subscription,omitempty"`
+	Subscriptions []string                  `json:"subscriptions,omitempty"`
+	Change        *repository.ProductChange `json:"change,omitempty"`
+	Error         *middleware.ErrorBody     `json:"error,omitempty"`
+}
+
+// SubscribeProductChanges upgrades the request to a WebSocket on which the client
+// subscribes to product changes by ID or price range. Every connection is served
+// from the service's shared change feed.
+func (c *ProductController) SubscribeProductChanges(ctx *gin.Context) {
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an HTTP error
+		log.Println("WebSocket upgrade failed:", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := c.service.SubscribeChanges()
+	defer c.service.UnsubscribeChanges(sub)
+
+	// Replies to client requests are handed to the writer, which owns the connection
+	replies := make(chan wsMessage, 8)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		readSubscriptions(conn, sub, replies)
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		var msg wsMessage
+		select {
+		case event := <-sub.Events():
+			change := event.Change
+			msg = wsMessage{Type: "change", Subscriptions: event.Filters, Change: &change}
+		case msg = <-replies:
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		case <-sub.Done():
+			// The hub only ends subscriptions that fell behind; the client should reconnect
+			body := middleware.ErrorBody{Code: "slow_consumer", Message: usecase.ErrSlowSubscriber.Error()}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			conn.WriteJSON(wsMessage{Type: "error", Error: &body})
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, body.Code))
+			return
+		case <-closed:
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// readSubscriptions applies subscribe and unsubscribe requests until the connection closes
+func readSubscriptions(conn *websocket.Conn, sub *usecase.Subscription, replies chan<- wsMessage) {
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("WebSocket read failed:", err)
+			}
+			return
+		}
+
+		reply := handleSubscriptionRequest(sub, data)
+		select {
+		case replies <- reply:
+		case <-sub.Done():
+			return
+		}
+	}
+}
+
+// handleSubscriptionRequest applies one client request and returns the reply
+func handleSubscriptionRequest(sub *usecase.Subscription, data []byte) wsMessage {
+	var req wsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return wsErrorMessage(req.Subscription, repository.NewValidationError("Invalid message: "+err.Error(), nil))
+	}
+
+	switch req.Type {
+	case "subscribe":
+		if err := sub.SetFilter(req.Subscription, req.ProductFilter); err != nil {
+			return wsErrorMessage(req.Subscription, err)
+		}
+		return wsMessage{Type: "subscribed", Subscription: req.Subscription}
+	case "unsubscribe":
+		if !sub.RemoveFilter(req.Subscription) {
+			return wsErrorMessage(req.Subscription, repository.NewValidationError("unknown subscription '"+req.Subscription+"'", nil))
+		}
+		return wsMessage{Type: "unsubscribed", Subscription: req.Subscription}
+	default:
+		return wsErrorMessage(req.Subscription, repository.NewValidationError("message type must be 'subscribe' or 'unsubscribe'", nil))
+	}
+}
+
+// wsErrorMessage describes a failed client request in the API's error format
+func wsErrorMessage(subscription string, err error) wsMessage {
+	_, body := middleware.ResolveError(err)
+	return wsMessage{Type: "error", Subscription: subscription, Error: &body}
+}
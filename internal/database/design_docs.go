@@ -0,0 +1,281 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// designMigrationsDocID is the _local document recording applied design document migrations.
+// _local documents are not replicated, so every database keeps its own record.
+const designMigrationsDocID = "_local/design_migrations"
+
+// View is a CouchDB map/reduce view
+type View struct {
+	Map    string `json:"map"`
+	Reduce string `json:"reduce,omitempty"`
+}
+
+// DesignDoc declares a design document. MigrateDesignDocs deploys it whenever its
+// content hash differs from the one stored in the database.
+type DesignDoc struct {
+	ID    string
+	Views map[string]View
+}
+
+// ProductDesignDocs are the design documents of the products database
+var ProductDesignDocs = []DesignDoc{
+	{
+		ID: "_design/products",
+		Views: map[string]View{
+			"by_name": {
+				Map: "function(doc) { if (doc.name && !doc.deleted_at) emit(doc.name, doc._id); }",
+			},
+			"by_price": {
+				Map: "function(doc) { if (typeof doc.price === 'number' && !doc.deleted_at) emit(doc.price, doc._id); }",
+			},
+			// Soft deleted products by deletion time, stored with second precision so they sort as strings
+			"trash": {
+				Map: "function(doc) { if (doc.name && doc.deleted_at) emit(doc.deleted_at, doc._id); }",
+			},
+		},
+	},
+	{
+		// Products with conflicting revisions, e.g. after replication between regions.
+		// Kept apart from _design/products so the product views are not rebuilt for it.
+		ID: "_design/conflicts",
+		Views: map[string]View{
+			"products": {
+				Map: "function(doc) { if (doc.name && doc._conflicts && !doc.deleted_at) emit(doc._id, doc._conflicts.length); }",
+			},
+		},
+	},
+	{
+		// Kept apart from _design/products so outbox changes do not rebuild the product views
+		ID: "_design/outbox",
+		Views: map[string]View{
+			"pending": {
+				Map: "function(doc) { if (doc.type === 'domain_event' && doc.status === 'pending') emit(doc._id, null); }",
+			},
+		},
+	},
+}
+
+// WebhookDesignDocs are the design documents of the webhooks database. Delivery
+// timestamps are stored with second precision, so they sort correctly as strings.
+var WebhookDesignDocs = []DesignDoc{
+	{
+		ID: "_design/webhooks",
+		Views: map[string]View{
+			"webhooks": {
+				Map: "function(doc) { if (doc.type === 'webhook') emit(doc._id, null); }",
+			},
+			"deliveries": {
+				Map: "function(doc) { if (doc.type === 'webhook_delivery') emit([doc.webhook_id, doc.created_at], null); }",
+			},
+			"deliveries_by_status": {
+				Map: "function(doc) { if (doc.type === 'webhook_delivery') emit([doc.webhook_id, doc.status, doc.created_at], null); }",
+			},
+			"pending_deliveries": {
+				Map: "function(doc) { if (doc.type === 'webhook_delivery' && doc.status === 'pending') emit(doc.next_attempt_at, null); }",
+			},
+		},
+	},
+}
+
+// AuditDesignDocs are the design documents of the audit database. Each view serves one
+// combination of filters and ends its key with the entry timestamp, which is stored with
+// second precision so that it sorts correctly as a string.
+var AuditDesignDocs = []DesignDoc{
+	{
+		ID: "_design/audit",
+		Views: map[string]View{
+			"by_time": {
+				Map: "function(doc) { if (doc.type === 'audit_entry') emit([doc.timestamp], null); }",
+			},
+			"by_actor": {
+				Map: "function(doc) { if (doc.type === 'audit_entry') emit([doc.actor, doc.timestamp], null); }",
+			},
+			"by_product": {
+				Map: "function(doc) { if (doc.type === 'audit_entry' && doc.product_id) emit([doc.product_id, doc.timestamp], null); }",
+			},
+			"by_actor_product": {
+				Map: "function(doc) { if (doc.type === 'audit_entry' && doc.product_id) emit([doc.actor, doc.product_id, doc.timestamp], null); }",
+			},
+		},
+	},
+}
+
+// APIKeyDesignDocs are the design documents of the API keys database
+var APIKeyDesignDocs = []DesignDoc{
+	{
+		ID: "_design/api_keys",
+		Views: map[string]View{
+			"api_keys": {
+				Map: "function(doc) { if (doc.type === 'api_key') emit(doc._id, null); }",
+			},
+		},
+	},
+}
+
+// IdempotencyDesignDocs are the design documents of the idempotency database. Expiry
+// times are stored with second precision, so they sort correctly as strings.
+var IdempotencyDesignDocs = []DesignDoc{
+	{
+		ID: "_design/idempotency",
+		Views: map[string]View{
+			"expires": {
+				Map: "function(doc) { if (doc.type === 'idempotency_record') emit(doc.expires_at, doc._rev); }",
+			},
+		},
+	},
+}
+
+// storedDesignDoc is a design document as written to CouchDB. Hash identifies the
+// declared content it was built from.
+type storedDesignDoc struct {
+	ID       string          `json:"_id"`
+	Rev      string          `json:"_rev,omitempty"`
+	Language string          `json:"language"`
+	Views    map[string]View `json:"views"`
+	Hash     string          `json:"migration_hash"`
+}
+
+// designMigrations is the content of the _local/design_migrations document
+type designMigrations struct {
+	ID      string                     `json:"_id"`
+	Rev     string                     `json:"_rev,omitempty"`
+	Applied map[string]designMigration `json:"applied"`
+	History []designMigration          `json:"history"`
+}
+
+// designMigration records one deployment of a design document
+type designMigration struct {
+	DesignDoc    string    `json:"design_doc"`
+	Hash         string    `json:"hash"`
+	PreviousHash string    `json:"previous_hash,omitempty"`
+	Rev          string    `json:"rev"`
+	AppliedAt    time.Time `json:"applied_at"`
+}
+
+// Hash returns a hash of the design document's content. encoding/json sorts map
+// keys, so the hash only changes when a view actually changes.
+func (d DesignDoc) Hash() string {
+	raw, _ := json.Marshal(d.Views)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrateDesignDocs creates or updates the given design documents and records every
+// deployment in _local/design_migrations. The stored views are hashed rather than
+// trusting the stored hash, so a design document edited by hand is redeployed too.
+func MigrateDesignDocs(ctx context.Context, db *kivik.DB, docs []DesignDoc) error {
+	record, err := loadDesignMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, doc := range docs {
+		hash := doc.Hash()
+
+		var existing storedDesignDoc
+		if err := db.Get(ctx, doc.ID).ScanDoc(&existing); err != nil && kivik.StatusCode(err) != 404 {
+			return fmt.Errorf("failed to read %s: %w", doc.ID, err)
+		}
+		if existing.Rev != "" && (DesignDoc{ID: doc.ID, Views: existing.Views}).Hash() == hash {
+			if _, ok := record.Applied[doc.ID]; !ok {
+				// Deployed before migrations were recorded
+				record.Applied[doc.ID] = designMigration{DesignDoc: doc.ID, Hash: hash, Rev: existing.Rev, AppliedAt: time.Now().UTC()}
+				changed = true
+			}
+			continue
+		}
+
+		rev, err := db.Put(ctx, doc.ID, storedDesignDoc{
+			ID:       doc.ID,
+			Rev:      existing.Rev,
+			Language: "javascript",
+			Views:    doc.Views,
+			Hash:     hash,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to deploy %s: %w", doc.ID, err)
+		}
+
+		migration := designMigration{
+			DesignDoc:    doc.ID,
+			Hash:         hash,
+			PreviousHash: existing.Hash,
+			Rev:          rev,
+			AppliedAt:    time.Now().UTC(),
+		}
+		record.Applied[doc.ID] = migration
+		record.History = append(record.History, migration)
+		changed = true
+		log.Printf("Deployed %s (hash %s)", doc.ID, hash[:12])
+	}
+
+	if !changed {
+		log.Println("Design documents are up to date")
+		return nil
+	}
+	if _, err := db.Put(ctx, designMigrationsDocID, record); err != nil {
+		return fmt.Errorf("failed to record design migrations: %w", err)
+	}
+	return nil
+}
+
+// loadDesignMigrations reads the migration record, returning an empty one if none exists yet
+func loadDesignMigrations(ctx context.Context, db *kivik.DB) (*designMigrations, error) {
+	record := &designMigrations{ID: designMigrationsDocID}
+	if err := db.Get(ctx, designMigrationsDocID).ScanDoc(record); err != nil && kivik.StatusCode(err) != 404 {
+		return nil, fmt.Errorf("failed to read design migrations: %w", err)
+	}
+	if record.Applied == nil {
+		record.Applied = make(map[string]designMigration)
+	}
+	return record, nil
+}
+
+// WarmViews builds the indexes of the given design documents by querying one view of
+// each; all views of a design document share one index build. The call blocks until
+// CouchDB has caught up, so the first requests after a deployment are not slowed down.
+func WarmViews(ctx context.Context, db *kivik.DB, docs []DesignDoc) error {
+	for _, doc := range docs {
+		for view := range doc.Views {
+			start := time.Now()
+			rows, err := db.Query(ctx, doc.ID, "_view/"+view, kivik.Options{"limit": 0})
+			if err != nil {
+				return fmt.Errorf("failed to warm %s: %w", doc.ID, err)
+			}
+			rows.Close()
+			log.Printf("Warmed %s in %s", doc.ID, time.Since(start).Round(time.Millisecond))
+			break
+		}
+	}
+	return nil
+}
+
+// warmIndexes builds the Mango indexes used by product searches by running a
+// minimal query against each of them
+func warmIndexes(ctx context.Context, db *kivik.DB) error {
+	for field, name := range ProductIndexes {
+		rows, err := db.Find(ctx, map[string]interface{}{
+			"selector":  map[string]interface{}{field: map[string]interface{}{"$gt": nil}},
+			"use_index": []string{ProductIndexDesignDoc, name},
+			"limit":     1,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to warm index %s: %w", name, err)
+		}
+		rows.Close()
+	}
+	return nil
+}
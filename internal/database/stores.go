@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// Logical stores. StoreProducts holds products, name reservations and their design docs.
+const (
+	StoreProducts    = "products"
+	StoreWebhooks    = "webhooks"
+	StoreAudit       = "audit"
+	StoreAPIKeys     = "api_keys"
+	StoreIdempotency = "idempotency"
+)
+
+// stores maps logical store names to CouchDB database names. It is filled by InitDB.
+var stores = map[string]string{}
+
+// parseStores parses COUCHDB_STORES, a comma separated list of store=database pairs,
+// e.g. "audit=ishop_audit_staging,webhooks=ishop_webhooks_staging"
+func parseStores(spec string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		store, dbName, ok := strings.Cut(pair, "=")
+		store, dbName = strings.TrimSpace(store), strings.TrimSpace(dbName)
+		if !ok || store == "" || dbName == "" {
+			return nil, fmt.Errorf("invalid COUCHDB_STORES entry %q, expected store=database", pair)
+		}
+		result[store] = dbName
+	}
+	return result, nil
+}
+
+// StoreName returns the database name of a logical store. Stores without explicit
+// configuration live next to the products database as "<products database>_<store>",
+// so every environment's products database gets its own set of companion databases.
+func StoreName(store string) string {
+	if dbName, ok := stores[store]; ok {
+		return dbName
+	}
+	return stores[StoreProducts] + "_" + store
+}
+
+// Store returns a handle to the database of a logical store
+func Store(store string) *kivik.DB {
+	return GetDB(StoreName(store))
+}
+
+// EnsureStore creates the database of a logical store if needed and returns a handle to it
+func EnsureStore(ctx context.Context, store string) (*kivik.DB, error) {
+	if err := createDB(ctx, StoreName(store)); err != nil {
+		return nil, err
+	}
+	return Store(store), nil
+}
+
+// InitStore ensures the database of a logical store exists, deploys its design
+// documents and warms their views
+func InitStore(ctx context.Context, store string, docs []DesignDoc) (*kivik.DB, error) {
+	db, err := EnsureStore(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	if err := MigrateDesignDocs(ctx, db, docs); err != nil {
+		return nil, fmt.Errorf("failed to migrate design documents of store %s: %w", store, err)
+	}
+	if err := WarmViews(ctx, db, docs); err != nil {
+		return nil, fmt.Errorf("failed to warm views of store %s: %w", store, err)
+	}
+	return db, nil
+}
+
+// createDB creates a database, treating an existing database as success
+func createDB(ctx context.Context, dbName string) error {
+	if Client == nil {
+		log.Fatal("Database client not initialized. Call InitDB first.")
+	}
+	err := Client.CreateDB(ctx, dbName)
+	if err != nil {
+		// Check if the error is due to the database already existing (HTTP 412)
+		if kivik.StatusCode(err) == 412 { // Precondition Failed (database exists)
+			log.Printf("Database %s already exists, proceeding...", dbName)
+			return nil
+		}
+		log.Printf("Failed to create database %s: %v", dbName, err)
+		return fmt.Errorf("failed to create database %s: %w", dbName, err)
+	}
+	return nil
+}
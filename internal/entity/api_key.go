@@ -0,0 +1,25 @@
+package entity
+
+import "time"
+
+// APIKey lets a machine client authenticate with the X-API-Key header. Only a hash of
+// the key is stored; the key itself is returned once, when it is created or rotated.
+// Scopes are the permissions the key grants, e.g. "products:read".
+type APIKey struct {
+	ID         string     `json:"_id,omitempty"`
+	Rev        string     `json:"_rev,omitempty"`
+	Name       string     `json:"name" validate:"required,max=200"`
+	Scopes     []string   `json:"scopes" validate:"required,min=1,dive,required,max=100"`
+	Hash       string     `json:"hash,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+}
+
+// Expired reports whether the key's expiry has passed at the given time
+func (k APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
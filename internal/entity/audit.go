@@ -0,0 +1,40 @@
+package entity
+
+import "time"
+
+// Audit actions recorded for product changes
+const (
+	AuditProductCreated  = "product.created"
+	AuditProductUpdated  = "product.updated"
+	AuditProductDeleted  = "product.deleted"
+	AuditProductRestored = "product.restored"
+	AuditProductReverted = "product.reverted"
+	AuditProductResolved = "product.conflict_resolved"
+)
+
+// AuditEntry records one change made through the API: who made it, from where, as part
+// of which request, and what it changed. Requests that change no product, e.g. failed
+// ones, are recorded with the route as action and without a product ID.
+type AuditEntry struct {
+	ID        string    `json:"_id,omitempty"`
+	Rev       string    `json:"_rev,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	// ClaimedActor is the unverified name the caller gave in X-Actor, if any
+	ClaimedActor string                 `json:"claimed_actor,omitempty"`
+	RequestID    string                 `json:"request_id"`
+	Action       string                 `json:"action"`
+	ProductID    string                 `json:"product_id,omitempty"`
+	Method       string                 `json:"method"`
+	Path         string                 `json:"path"`
+	Status       int                    `json:"status"`
+	ClientIP     string                 `json:"client_ip"`
+	Changes      map[string]FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange is the value of a product field before and after a change. A nil value
+// means the field was not set.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
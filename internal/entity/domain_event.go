@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Domain events recorded for product writes
+const (
+	EventNameProductCreated      = "ProductCreated"
+	EventNameProductRenamed      = "ProductRenamed"
+	EventNameProductPriceChanged = "ProductPriceChanged"
+	EventNameProductDeleted      = "ProductDeleted"
+	EventNameProductRestored     = "ProductRestored"
+)
+
+// Outbox states of a domain event
+const (
+	EventPending    = "pending"
+	EventDispatched = "dispatched"
+)
+
+// DomainEvent is a fact about a product, stored in the outbox next to the product
+// it describes and relayed to an event publisher afterwards
+type DomainEvent struct {
+	ID           string          `json:"_id,omitempty"`
+	Rev          string          `json:"_rev,omitempty"`
+	Event        string          `json:"event"`
+	AggregateID  string          `json:"aggregate_id"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	LastError    string          `json:"last_error,omitempty"`
+	OccurredAt   time.Time       `json:"occurred_at"`
+	DispatchedAt *time.Time      `json:"dispatched_at,omitempty"`
+}
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// Idempotency record states
+const (
+	IdempotencyPending   = "pending"
+	IdempotencyCompleted = "completed"
+)
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key
+// header, so that a retry gets the same response instead of repeating the request.
+// While the first request is pending the record locks the key until LockedUntil.
+type IdempotencyRecord struct {
+	ID          string            `json:"_id"`
+	Rev         string            `json:"_rev,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	State       string            `json:"state"`
+	StatusCode  int               `json:"status_code,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	LockedUntil time.Time         `json:"locked_until"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+// Completed reports whether the record holds a response
+func (r IdempotencyRecord) Completed() bool {
+	return r.State == IdempotencyCompleted
+}
@@ -1,9 +1,34 @@
-package entity
-
-// Struct a user-defined type to store a collection of different fields into a single field. 
-type Product struct {
-	ID		string `json:"_id,omitempty"`
-	Rev		string `json:"_rev,omitempty"`
-	Name 	string `json:"name" validate:"required,min=3,max=100"`
-	Price 	float64 `json:"price" validate:"required,gt=0"`   
-}
+package entity
+
+import "time"
+
+// Struct a user-defined type to store a collection of different fields into a single field. 
+type Product struct {
+	ID		string `json:"_id,omitempty"`
+	Rev		string `json:"_rev,omitempty"`
+	Name 	string `json:"name" validate:"required,min=3,max=100"`
+	Price 	float64 `json:"price" validate:"required,gt=0"`   
+	// SchemaVersion is the document schema version, maintained by the repository and its migrations
+	SchemaVersion	int `json:"schema_version,omitempty"`
+	// UpdatedAt and UpdatedBy record when and by whom this version was written
+	UpdatedAt	*time.Time `json:"updated_at,omitempty"`
+	UpdatedBy	string `json:"updated_by,omitempty"`
+	// DeletedAt and DeletedBy are set while the product is in the trash
+	DeletedAt	*time.Time `json:"deleted_at,omitempty"`
+	DeletedBy	string `json:"deleted_by,omitempty"`
+	// Conflicts lists the revisions that lost against Rev after replication. It is only
+	// read on request and never written.
+	Conflicts	[]string `json:"_conflicts,omitempty"`
+}
+
+// Trashed reports whether the product was soft deleted
+func (p Product) Trashed() bool {
+	return p.DeletedAt != nil
+}
+
+// ProductVersion is one version of a product in its history. Current marks the stored version.
+type ProductVersion struct {
+	Rev     string  `json:"rev"`
+	Current bool    `json:"current"`
+	Product Product `json:"product"`
+}
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Product lifecycle events a webhook can subscribe to
+const (
+	EventProductCreated = "product.created"
+	EventProductUpdated = "product.updated"
+	EventProductDeleted = "product.deleted"
+)
+
+// Webhook is a subscription of an external receiver to product lifecycle events.
+// Secret signs every delivery; it is only returned when the webhook is created.
+type Webhook struct {
+	ID        string    `json:"_id,omitempty"`
+	Rev       string    `json:"_rev,omitempty"`
+	URL       string    `json:"url" validate:"required,url,max=2048"`
+	Events    []string  `json:"events" validate:"required,min=1,dive,oneof=product.created product.updated product.deleted"`
+	Secret    string    `json:"secret,omitempty" validate:"omitempty,min=16,max=256"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the webhook is enabled and listens to the event
+func (w Webhook) Subscribes(event string) bool {
+	if w.Disabled {
+		return false
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery states
+const (
+	DeliveryPending    = "pending"
+	DeliverySucceeded  = "succeeded"
+	DeliveryDeadLetter = "dead_letter"
+)
+
+// WebhookDelivery is one event sent to one webhook, together with its attempt log.
+// Deliveries that exhaust their retries stay behind as dead letters.
+type WebhookDelivery struct {
+	ID            string           `json:"_id,omitempty"`
+	Rev           string           `json:"_rev,omitempty"`
+	WebhookID     string           `json:"webhook_id"`
+	Event         string           `json:"event"`
+	ProductID     string           `json:"product_id"`
+	Payload       json.RawMessage  `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      []WebhookAttempt `json:"attempts"`
+	NextAttemptAt time.Time        `json:"next_attempt_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// WebhookAttempt records the outcome of one HTTP request to a webhook receiver
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"e-learning/go-with-couchdb/internal/actor"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ActorHeader lets a caller name itself. The name is unverified, so it is only kept as
+// the claimed actor of audit entries; updated_by, deleted_by and the audit actor come
+// from authentication.
+const ActorHeader = "X-Actor"
+
+// Actor stores the name given in the X-Actor header as the claimed actor of the request
+func Actor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if name := ctx.GetHeader(ActorHeader); name != "" {
+			ctx.Request = ctx.Request.WithContext(actor.WithClaimed(ctx.Request.Context(), name))
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/actor"
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingAuditLog keeps the entries written to it
+type recordingAuditLog struct {
+	entries []entity.AuditEntry
+}
+
+func (l *recordingAuditLog) RecordAuditEntries(ctx context.Context, entries []entity.AuditEntry) error {
+	l.entries = append(l.entries, entries...)
+	return nil
+}
+
+func TestActorHeaderIsOnlyClaimed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auditLog := &recordingAuditLog{}
+	policy := AuthPolicy{APIKeys: fakeAPIKeys{key: "secret"}, Default: auth.ModeOptional}
+
+	var seen string
+	r := gin.New()
+	r.Use(Audit(auditLog), ErrorHandler(), Actor())
+	r.POST("/products", policy.Group("products"), func(ctx *gin.Context) {
+		seen = actor.FromContext(ctx.Request.Context())
+		ctx.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name    string
+		apiKey  string
+		actor   string
+		claimed string
+	}{
+		{"anonymous caller naming itself", "", actor.Anonymous, "mallory"},
+		{"authenticated caller naming another", "secret", "api-key:test", "mallory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditLog.entries = nil
+			req := httptest.NewRequest(http.MethodPost, "/products", nil)
+			req.Header.Set(ActorHeader, "mallory")
+			if tt.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("got status %d: %s", w.Code, w.Body)
+			}
+			if seen != tt.actor {
+				t.Errorf("handler saw actor %q, want %q", seen, tt.actor)
+			}
+			if len(auditLog.entries) != 1 || auditLog.entries[0].Actor != tt.actor || auditLog.entries[0].ClaimedActor != tt.claimed {
+				t.Errorf("got audit entries %+v, want actor %q claiming %q", auditLog.entries, tt.actor, tt.claimed)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/actor"
+	"e-learning/go-with-couchdb/internal/audit"
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditLog stores the entries written by the Audit middleware
+type AuditLog interface {
+	RecordAuditEntries(ctx context.Context, entries []entity.AuditEntry) error
+}
+
+// Audit writes an audit entry for every mutating request once it has been handled.
+// The product changes services record in the request's audit trail become one entry
+// each; a request that changed no product gets a single entry named after its route.
+// readOnlyRoutes lists routes, as registered, that use a mutating method to read,
+// e.g. searches. Requests rejected by a rate limit are not recorded, so that floods
+// the limits absorb do not turn into audit writes. Audit must run outside ErrorHandler
+// to see the final status.
+func Audit(auditLog AuditLog, readOnlyRoutes ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(readOnlyRoutes))
+	for _, route := range readOnlyRoutes {
+		skip[route] = true
+	}
+
+	return func(ctx *gin.Context) {
+		// Requests matching no route change nothing and are not API calls
+		if !mutating(ctx.Request.Method) || ctx.FullPath() == "" || skip[ctx.FullPath()] {
+			ctx.Next()
+			return
+		}
+
+		trail := &audit.Trail{}
+		ctx.Request = ctx.Request.WithContext(audit.WithTrail(ctx.Request.Context(), trail))
+		ctx.Next()
+		if ctx.GetBool(rateLimitedKey) {
+			return
+		}
+
+		base := entity.AuditEntry{
+			Timestamp:    time.Now(),
+			Actor:        actor.FromContext(ctx.Request.Context()),
+			ClaimedActor: actor.ClaimedFromContext(ctx.Request.Context()),
+			RequestID:    GetRequestID(ctx),
+			Method:       ctx.Request.Method,
+			Path:         ctx.Request.URL.Path,
+			Status:       ctx.Writer.Status(),
+			ClientIP:     ctx.ClientIP(),
+		}
+
+		var entries []entity.AuditEntry
+		for _, change := range trail.Changes() {
+			entry := base
+			entry.Action = change.Action
+			entry.ProductID = change.ProductID
+			entry.Changes = change.Changes
+			entries = append(entries, entry)
+		}
+		if len(entries) == 0 {
+			base.Action = ctx.Request.Method + " " + ctx.FullPath()
+			entries = append(entries, base)
+		}
+
+		// The entries are written even if the client went away in the meantime
+		if err := auditLog.RecordAuditEntries(context.WithoutCancel(ctx.Request.Context()), entries); err != nil {
+			log.Println("Failed to write audit entries:", base.RequestID, err)
+		}
+	}
+}
+
+// mutating reports whether a request method can change state
+func mutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
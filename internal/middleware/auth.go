@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"e-learning/go-with-couchdb/internal/actor"
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the API key of machine clients
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyVerifier checks API keys and returns the claims they stand for
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (auth.Claims, error)
+}
+
+// AuthPolicy decides how each route group authenticates its requests and which
+// permissions its routes require. Anonymous callers hold the permissions the access
+// policy grants to Anonymous, with or without a token authenticator; routes guarded by
+// Restrict always require an authenticated caller.
+type AuthPolicy struct {
+	// Authenticator verifies bearer tokens; without it bearer tokens are rejected
+	Authenticator *auth.Authenticator
+	// APIKeys verifies X-API-Key headers; without it API keys are rejected
+	APIKeys APIKeyVerifier
+	// Access grants permissions to roles; without it authenticated callers may do anything
+	Access *auth.Policy
+	// Modes maps route group names to their mode; other groups use Default
+	Modes   map[string]auth.Mode
+	Default auth.Mode
+}
+
+// Group returns the authentication middleware of a route group
+func (p AuthPolicy) Group(name string) gin.HandlerFunc {
+	mode, ok := p.Modes[name]
+	if !ok {
+		mode = p.Default
+	}
+	if (p.Authenticator == nil && p.APIKeys == nil) || mode == auth.ModeOff || mode == "" {
+		return func(ctx *gin.Context) { ctx.Next() }
+	}
+	return Authenticate(p.Authenticator, p.APIKeys, mode == auth.ModeRequired)
+}
+
+// Require returns middleware rejecting callers without the permission with 403. It
+// runs after the group's authentication, so callers are known if they sent credentials;
+// anonymous callers are checked against the access policy's anonymous permissions.
+func (p AuthPolicy) Require(permission string) gin.HandlerFunc {
+	if p.Access == nil {
+		return func(ctx *gin.Context) { ctx.Next() }
+	}
+	return func(ctx *gin.Context) {
+		claims, authenticated := auth.ClaimsFromContext(ctx.Request.Context())
+		p.authorize(ctx, claims, authenticated, permission)
+	}
+}
+
+// Restrict is Require for administrative routes: callers must be authenticated, with
+// a bearer token or an API key, even when no token authenticator is configured.
+// Anonymous callers are rejected with 401.
+func (p AuthPolicy) Restrict(permission string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, authenticated := auth.ClaimsFromContext(ctx.Request.Context())
+		if !authenticated {
+			unauthenticated(ctx, fmt.Errorf("%w: authentication is required", auth.ErrUnauthenticated))
+			return
+		}
+		if p.Access == nil {
+			ctx.Next()
+			return
+		}
+		p.authorize(ctx, claims, authenticated, permission)
+	}
+}
+
+// authorize lets the request through if the caller holds the permission and rejects it with 403 otherwise
+func (p AuthPolicy) authorize(ctx *gin.Context, claims auth.Claims, authenticated bool, permission string) {
+	if !p.Access.Allows(claims, authenticated, permission) {
+		ctx.Error(&repository.Error{
+			Kind:    auth.ErrForbidden,
+			Message: fmt.Sprintf("missing permission %s", permission),
+			Details: map[string]string{"missing_permission": permission},
+		})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+// Authenticate verifies the bearer token or API key of a request and stores its claims
+// in the request context. The subject becomes the actor. Requests
+// without credentials are rejected with 401 when required is set; invalid ones always are.
+func Authenticate(authenticator *auth.Authenticator, apiKeys APIKeyVerifier, required bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header, apiKey := ctx.GetHeader("Authorization"), ctx.GetHeader(APIKeyHeader)
+		if header == "" && apiKey == "" && !required {
+			ctx.Next()
+			return
+		}
+
+		var claims auth.Claims
+		var err error
+		switch {
+		case header != "" && apiKey != "":
+			err = fmt.Errorf("%w: send either a bearer token or an API key", auth.ErrUnauthenticated)
+		case apiKey != "" && apiKeys == nil:
+			err = fmt.Errorf("%w: API keys are not accepted", auth.ErrUnauthenticated)
+		case apiKey != "":
+			claims, err = apiKeys.VerifyAPIKey(ctx.Request.Context(), apiKey)
+		case authenticator == nil:
+			err = fmt.Errorf("%w: bearer tokens are not accepted", auth.ErrUnauthenticated)
+		default:
+			token, ok := auth.BearerToken(header)
+			if !ok {
+				err = fmt.Errorf("%w: a bearer token is required", auth.ErrUnauthenticated)
+				break
+			}
+			claims, err = authenticator.Verify(ctx.Request.Context(), token)
+		}
+		if err != nil {
+			unauthenticated(ctx, err)
+			return
+		}
+
+		reqCtx := auth.WithClaims(ctx.Request.Context(), claims)
+		reqCtx = actor.WithActor(reqCtx, claims.Subject())
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		ctx.Next()
+	}
+}
+
+// unauthenticated rejects a request, pointing the client at bearer authentication
+func unauthenticated(ctx *gin.Context, err error) {
+	ctx.Header("WWW-Authenticate", `Bearer realm="api"`)
+	ctx.Error(err)
+	ctx.Abort()
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeAPIKeys accepts a single key granting the given scopes
+type fakeAPIKeys struct {
+	key    string
+	scopes []interface{}
+}
+
+func (f fakeAPIKeys) VerifyAPIKey(ctx context.Context, key string) (auth.Claims, error) {
+	if key != f.key {
+		return nil, fmt.Errorf("%w: invalid API key", auth.ErrUnauthenticated)
+	}
+	return auth.Claims{"sub": "api-key:test", auth.ScopesClaim: f.scopes}, nil
+}
+
+func newAuthTestRouter(policy AuthPolicy) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler())
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusNoContent) }
+	group := r.Group("", policy.Group("products"))
+	group.GET("/read", policy.Require(auth.PermProductsRead), ok)
+	group.DELETE("/delete", policy.Require(auth.PermProductsDelete), ok)
+	admin := r.Group("/admin", policy.Group("api-keys"))
+	admin.GET("", policy.Restrict(auth.PermAPIKeysManage), ok)
+	return r
+}
+
+func TestAuthPolicyWithoutTokenAuthenticator(t *testing.T) {
+	policy := AuthPolicy{
+		APIKeys: fakeAPIKeys{key: "reader", scopes: []interface{}{auth.PermProductsRead}},
+		Access:  auth.DefaultPolicy(),
+		Default: auth.ModeOptional,
+	}
+	r := newAuthTestRouter(policy)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"anonymous read", http.MethodGet, "/read", nil, http.StatusNoContent},
+		{"anonymous delete", http.MethodDelete, "/delete", nil, http.StatusForbidden},
+		{"API key within its scopes", http.MethodGet, "/read", map[string]string{APIKeyHeader: "reader"}, http.StatusNoContent},
+		{"API key outside its scopes", http.MethodDelete, "/delete", map[string]string{APIKeyHeader: "reader"}, http.StatusForbidden},
+		{"invalid API key", http.MethodGet, "/read", map[string]string{APIKeyHeader: "wrong"}, http.StatusUnauthorized},
+		{"bearer token without authenticator", http.MethodGet, "/read", map[string]string{"Authorization": "Bearer abc"}, http.StatusUnauthorized},
+		{"anonymous admin call", http.MethodGet, "/admin", nil, http.StatusUnauthorized},
+		{"admin call without permission", http.MethodGet, "/admin", map[string]string{APIKeyHeader: "reader"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthPolicyAnonymousPermissionsWithoutTokenAuthenticator(t *testing.T) {
+	access := auth.DefaultPolicy()
+	access.Anonymous = []string{"products:*"}
+	r := newAuthTestRouter(AuthPolicy{
+		APIKeys: fakeAPIKeys{key: "reader", scopes: []interface{}{auth.PermProductsRead}},
+		Access:  access,
+		Default: auth.ModeOptional,
+	})
+
+	// Granted explicitly, anonymous callers may delete; admin routes stay closed
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodDelete, "/delete", http.StatusNoContent},
+		{http.MethodGet, "/admin", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAuthPolicyRestrictFailsClosedWithoutCredentials(t *testing.T) {
+	r := newAuthTestRouter(AuthPolicy{})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
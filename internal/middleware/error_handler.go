@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/ratelimit"
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrUnsupportedMediaType is reported when a request body has a content type the endpoint does not accept
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// errorMapping ties a domain error to its HTTP status and machine-readable code
+type errorMapping struct {
+	target error
+	status int
+	code   string
+}
+
+// errorMappings lists the known domain errors, checked in order with errors.Is
+var errorMappings = []errorMapping{
+	{repository.ErrNotFound, http.StatusNotFound, "not_found"},
+	{repository.ErrDuplicateName, http.StatusConflict, "duplicate_name"},
+	{repository.ErrRevisionConflict, http.StatusConflict, "revision_conflict"},
+	{repository.ErrValidation, http.StatusBadRequest, "validation_failed"},
+	{repository.ErrAborted, http.StatusFailedDependency, "aborted"},
+	{repository.ErrPrecondition, http.StatusPreconditionFailed, "precondition_failed"},
+	{repository.ErrKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{repository.ErrInProgress, http.StatusConflict, "request_in_progress"},
+	{repository.ErrUnpatchable, http.StatusUnprocessableEntity, "unpatchable_field"},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{auth.ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{auth.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ratelimit.ErrLimited, http.StatusTooManyRequests, "rate_limited"},
+}
+
+// ErrorResponse is the JSON envelope returned for every failed request
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes a single error inside the envelope
+type ErrorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ErrorHandler turns the last error attached with ctx.Error into a JSON error envelope.
+// Handlers report failures with ctx.Error(err) and return without writing a response.
+func ErrorHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		if len(ctx.Errors) == 0 || ctx.Writer.Written() {
+			return
+		}
+		writeError(ctx)
+	}
+}
+
+// writeError writes the last error attached with ctx.Error as a JSON error envelope
+func writeError(ctx *gin.Context) {
+	status, body := ResolveError(ctx.Errors.Last().Err)
+	ctx.AbortWithStatusJSON(status, ErrorResponse{Error: body})
+}
+
+// ResolveError maps an error to its HTTP status and envelope body. It is also used
+// to describe the failed items of multi-status (207) responses.
+func ResolveError(err error) (int, ErrorBody) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.target) {
+			body := ErrorBody{Code: m.code, Message: err.Error()}
+			var domainErr *repository.Error
+			if errors.As(err, &domainErr) {
+				body.Details = domainErr.Details
+			}
+			return m.status, body
+		}
+	}
+
+	// Unknown errors are logged but not leaked to the client
+	log.Println("Unhandled error:", err)
+	return http.StatusInternalServerError, ErrorBody{Code: "internal_error", Message: "Internal server error"}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader carries the client's key for safely retrying a request
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with a response and replayed with it
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// IdempotencyStore keeps the responses to requests sent with an idempotency key
+type IdempotencyStore interface {
+	BeginRequest(ctx context.Context, client string, key string, fingerprint string) (*entity.IdempotencyRecord, error)
+	CompleteRequest(ctx context.Context, record entity.IdempotencyRecord, status int, header map[string]string, body []byte) error
+	AbandonRequest(ctx context.Context, record entity.IdempotencyRecord) error
+}
+
+// capturingWriter keeps a copy of the response body it writes
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes a route safe to retry with an Idempotency-Key header. The first
+// response to a key is stored and replayed, marked Idempotent-Replayed, to retries with
+// the same method, URL and body; other requests with the key are rejected with 422.
+// Keys are scoped to the caller. Server errors are not stored, so the request can be
+// retried. Requests without the header run as usual.
+func Idempotency(store IdempotencyStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+		if store == nil || key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.Error(repository.NewValidationError("Idempotency-Key must be at most 255 characters", nil))
+			ctx.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.Error(repository.NewValidationError("Invalid input: "+err.Error(), nil))
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record, err := store.BeginRequest(ctx.Request.Context(), clientKey(ctx), key, requestFingerprint(ctx.Request, body))
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		if record.Completed() {
+			for name, value := range record.Header {
+				ctx.Header(name, value)
+			}
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.Data(record.StatusCode, record.Header["Content-Type"], []byte(record.Body))
+			ctx.Abort()
+			return
+		}
+
+		writer := &capturingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+		// Write errors here rather than in ErrorHandler, so that they are stored too
+		if len(ctx.Errors) > 0 && !writer.Written() {
+			writeError(ctx)
+		}
+		ctx.Writer = writer.ResponseWriter
+
+		storeCtx := context.WithoutCancel(ctx.Request.Context())
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := store.AbandonRequest(storeCtx, *record); err != nil {
+				log.Println("Failed to release idempotency key:", err)
+			}
+			return
+		}
+		header := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := writer.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		if err := store.CompleteRequest(storeCtx, *record, writer.Status(), header, writer.body.Bytes()); err != nil {
+			log.Println("Failed to store idempotent response:", err)
+		}
+	}
+}
+
+// requestFingerprint identifies a request by its method, URL and body
+func requestFingerprint(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, req.Method+" "+req.URL.RequestURI()+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/repository"
+	"e-learning/go-with-couchdb/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyTestRouter serves POST /products with the Idempotency middleware. The
+// handler counts its runs and answers with the given statuses in turn, repeating the
+// last one. If block is set, each run reports on started and waits for block to close.
+type idempotencyTestRouter struct {
+	*gin.Engine
+	runs     int32
+	statuses []int
+	started  chan struct{}
+	block    chan struct{}
+}
+
+func newIdempotencyTestRouter(statuses ...int) *idempotencyTestRouter {
+	gin.SetMode(gin.TestMode)
+	store := usecase.NewIdempotencyService(repository.NewMemoryIdempotencyRepo(), usecase.DefaultIdempotencyConfig())
+	tr := &idempotencyTestRouter{Engine: gin.New(), statuses: statuses}
+	tr.Use(ErrorHandler())
+	tr.POST("/products", Idempotency(store), func(ctx *gin.Context) {
+		run := int(atomic.AddInt32(&tr.runs, 1))
+		if tr.block != nil {
+			tr.started <- struct{}{}
+			<-tr.block
+		}
+		status := tr.statuses[len(tr.statuses)-1]
+		if run <= len(tr.statuses) {
+			status = tr.statuses[run-1]
+		}
+		ctx.Header("Location", "/products/laptop")
+		ctx.JSON(status, gin.H{"run": run})
+	})
+	return tr
+}
+
+// errorResponseCode returns the code of an error response
+func errorResponseCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error response %s: %v", w.Body, err)
+	}
+	return body.Error.Code
+}
+
+// post sends a create from a client address with an idempotency key
+func (tr *idempotencyTestRouter) post(ip string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set(IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplaysStoredResponses(t *testing.T) {
+	r := newIdempotencyTestRouter(http.StatusCreated)
+
+	first := r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", first.Code, first.Body)
+	}
+	replay := r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("got %d %s, want the first response %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("Location") != "/products/laptop" {
+		t.Errorf("got headers %v, want the stored Location marked as replayed", replay.Header())
+	}
+	if runs := atomic.LoadInt32(&r.runs); runs != 1 {
+		t.Errorf("handler ran %d times, want once", runs)
+	}
+}
+
+func TestIdempotencyRejectsKeyReusedWithAnotherBody(t *testing.T) {
+	r := newIdempotencyTestRouter(http.StatusCreated)
+
+	r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	w := r.post("203.0.113.7", "key-1", `{"name":"Phone"}`)
+	if w.Code != http.StatusUnprocessableEntity || errorResponseCode(t, w) != "idempotency_key_reused" {
+		t.Errorf("got %d %s, want 422 idempotency_key_reused", w.Code, w.Body)
+	}
+	if runs := atomic.LoadInt32(&r.runs); runs != 1 {
+		t.Errorf("handler ran %d times, want once", runs)
+	}
+}
+
+func TestIdempotencyRejectsRetriesWhileInProgress(t *testing.T) {
+	r := newIdempotencyTestRouter(http.StatusCreated)
+	r.started, r.block = make(chan struct{}), make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	}()
+	<-r.started
+
+	w := r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	close(r.block)
+	wg.Wait()
+	if w.Code != http.StatusConflict || errorResponseCode(t, w) != "request_in_progress" {
+		t.Errorf("got %d %s, want 409 request_in_progress", w.Code, w.Body)
+	}
+	if runs := atomic.LoadInt32(&r.runs); runs != 1 {
+		t.Errorf("handler ran %d times, want once", runs)
+	}
+}
+
+func TestIdempotencyAbandonsServerErrors(t *testing.T) {
+	r := newIdempotencyTestRouter(http.StatusServiceUnavailable, http.StatusCreated)
+
+	if w := r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want 503", w.Code)
+	}
+	w := r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d %v, want the retry to run again", w.Code, w.Header())
+	}
+	if runs := atomic.LoadInt32(&r.runs); runs != 2 {
+		t.Errorf("handler ran %d times, want twice", runs)
+	}
+}
+
+func TestIdempotencyScopesKeysPerClient(t *testing.T) {
+	r := newIdempotencyTestRouter(http.StatusCreated)
+
+	r.post("203.0.113.7", "key-1", `{"name":"Laptop"}`)
+	w := r.post("198.51.100.1", "key-1", `{"name":"Phone"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d %s, want another client's key to be independent", w.Code, w.Body)
+	}
+	if runs := atomic.LoadInt32(&r.runs); runs != 2 {
+		t.Errorf("handler ran %d times, want twice", runs)
+	}
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/ratelimit"
+	"e-learning/go-with-couchdb/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate limit classes. Bulk routes have buckets of their own. IP buckets are taken from
+// before authentication, whoever the caller turns out to be.
+const (
+	RateLimitDefault = "default"
+	RateLimitBulk    = "bulk"
+	RateLimitIP      = "ip"
+)
+
+// rateLimitedKey marks requests rejected by a rate limit, which are not audited
+const rateLimitedKey = "rate_limited"
+
+// RateLimitPolicy limits the requests of each client per route class. Without a store
+// nothing is limited.
+type RateLimitPolicy struct {
+	Store ratelimit.Store
+	// Limits maps classes to their limits; classes not listed are not limited
+	Limits map[string]ratelimit.Limit
+}
+
+// Limit returns middleware taking a token from the client's bucket of a class. It
+// runs after the group's authentication, so known callers are limited by subject.
+// Responses carry RateLimit-* headers; requests over the limit are rejected with 429
+// and Retry-After. When the store fails requests are let through.
+func (p RateLimitPolicy) Limit(class string) gin.HandlerFunc {
+	return p.limit(class, clientKey)
+}
+
+// LimitIP returns middleware taking a token from the bucket of the client's IP address.
+// It runs before authentication, so that floods of guessed tokens or API keys are
+// throttled before they are verified.
+func (p RateLimitPolicy) LimitIP() gin.HandlerFunc {
+	return p.limit(RateLimitIP, func(ctx *gin.Context) string {
+		return "ip:" + ctx.ClientIP()
+	})
+}
+
+// limit takes a token from the bucket of a class that key picks for the request
+func (p RateLimitPolicy) limit(class string, key func(ctx *gin.Context) string) gin.HandlerFunc {
+	limit := p.Limits[class]
+	if p.Store == nil || !limit.Enabled() {
+		return func(ctx *gin.Context) { ctx.Next() }
+	}
+	return func(ctx *gin.Context) {
+		result, err := p.Store.Take(ctx.Request.Context(), class+"|"+key(ctx), limit)
+		if err != nil {
+			log.Println("Failed to apply rate limit:", err)
+			ctx.Next()
+			return
+		}
+
+		ctx.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Header("RateLimit-Reset", wholeSeconds(result.ResetAfter))
+		if !result.Allowed {
+			retryAfter := wholeSeconds(result.RetryAfter)
+			ctx.Header("Retry-After", retryAfter)
+			ctx.Error(&repository.Error{
+				Kind:    ratelimit.ErrLimited,
+				Message: fmt.Sprintf("rate limit of %s requests exceeded, retry in %s seconds", limit, retryAfter),
+				Details: map[string]string{"retry_after": retryAfter},
+			})
+			ctx.Set(rateLimitedKey, true)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// clientKey identifies the caller by the subject of its token or API key, or else by its
+// IP address. ClientIP only honours forwarding headers set by trusted proxies.
+func clientKey(ctx *gin.Context) string {
+	if claims, ok := auth.ClaimsFromContext(ctx.Request.Context()); ok && claims.Subject() != "" {
+		return "sub:" + claims.Subject()
+	}
+	return "ip:" + ctx.ClientIP()
+}
+
+// wholeSeconds rounds a duration up to whole seconds, as the rate limit headers expect
+func wholeSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
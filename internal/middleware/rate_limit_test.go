@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingAPIKeys rejects every key and counts the verifications
+type countingAPIKeys struct {
+	calls int32
+}
+
+func (c *countingAPIKeys) VerifyAPIKey(ctx context.Context, key string) (auth.Claims, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return nil, fmt.Errorf("%w: invalid API key", auth.ErrUnauthenticated)
+}
+
+func TestLimitIPThrottlesBeforeAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	verifier := &countingAPIKeys{}
+	policy := AuthPolicy{APIKeys: verifier, Default: auth.ModeRequired}
+	limits := RateLimitPolicy{
+		Store: ratelimit.NewMemoryStore(),
+		Limits: map[string]ratelimit.Limit{
+			RateLimitIP:      {Requests: 3, Per: time.Minute},
+			RateLimitDefault: {Requests: 100, Per: time.Minute},
+		},
+	}
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.GET("/products", limits.LimitIP(), policy.Group("products"), limits.Limit(RateLimitDefault), func(ctx *gin.Context) {
+		ctx.Status(http.StatusNoContent)
+	})
+
+	send := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set(APIKeyHeader, "guess")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := send("203.0.113.7"); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d got status %d, want 401", i, code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if code := send("203.0.113.7"); code != http.StatusTooManyRequests {
+			t.Fatalf("guess over the limit got status %d, want 429", code)
+		}
+	}
+	if calls := atomic.LoadInt32(&verifier.calls); calls != 3 {
+		t.Errorf("%d keys were verified, want only the 3 within the limit", calls)
+	}
+	if code := send("198.51.100.1"); code != http.StatusUnauthorized {
+		t.Errorf("another address got status %d, want 401", code)
+	}
+}
+
+// countingAuditLog counts the audit entries written
+type countingAuditLog struct {
+	entries int32
+}
+
+func (c *countingAuditLog) RecordAuditEntries(ctx context.Context, entries []entity.AuditEntry) error {
+	atomic.AddInt32(&c.entries, int32(len(entries)))
+	return nil
+}
+
+func TestThrottledRequestsAreNotAudited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auditLog := &countingAuditLog{}
+	limits := RateLimitPolicy{
+		Store:  ratelimit.NewMemoryStore(),
+		Limits: map[string]ratelimit.Limit{RateLimitIP: {Requests: 2, Per: time.Minute}},
+	}
+
+	r := gin.New()
+	r.Use(Audit(auditLog), ErrorHandler())
+	r.DELETE("/products/:_id", limits.LimitIP(), func(ctx *gin.Context) {
+		ctx.Status(http.StatusNoContent)
+	})
+
+	statuses := make(map[int]int)
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/products/laptop", nil))
+		statuses[w.Code]++
+	}
+	if statuses[http.StatusNoContent] != 2 || statuses[http.StatusTooManyRequests] != 8 {
+		t.Fatalf("got statuses %v, want 2 handled and 8 throttled", statuses)
+	}
+	if entries := atomic.LoadInt32(&auditLog.entries); entries != 2 {
+		t.Errorf("%d audit entries written, want only the 2 handled requests", entries)
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID of a request. A client supplied ID is kept so that
+// calls can be traced across services; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds client supplied request IDs
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an ID and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+		ctx.Set(requestIDKey, id)
+		ctx.Header(RequestIDHeader, id)
+		ctx.Next()
+	}
+}
+
+// GetRequestID returns the ID assigned to the request by RequestID
+func GetRequestID(ctx *gin.Context) string {
+	return ctx.GetString(requestIDKey)
+}
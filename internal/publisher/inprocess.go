@@ -0,0 +1,44 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// Handler consumes a domain event inside the process
+type Handler func(ctx context.Context, event entity.DomainEvent) error
+
+// InProcess calls the handlers registered for an event synchronously. The first
+// failing handler fails the publish, so the event is relayed again later and handlers
+// must tolerate duplicates.
+type InProcess struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewInProcess() *InProcess {
+	return &InProcess{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe registers a handler for the named event, or for all events if name is empty
+func (p *InProcess) Subscribe(name string, handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[name] = append(p.handlers[name], handler)
+}
+
+func (p *InProcess) Publish(ctx context.Context, event entity.DomainEvent) error {
+	p.mu.RLock()
+	handlers := append(append([]Handler{}, p.handlers[event.Event]...), p.handlers[""]...)
+	p.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			return fmt.Errorf("handler for %s failed: %w", event.Event, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+func TestInProcessCallsSubscribedHandlers(t *testing.T) {
+	p := NewInProcess()
+	var named, all []string
+	p.Subscribe(entity.EventNameProductCreated, func(ctx context.Context, event entity.DomainEvent) error {
+		named = append(named, event.ID)
+		return nil
+	})
+	p.Subscribe("", func(ctx context.Context, event entity.DomainEvent) error {
+		all = append(all, event.ID)
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := p.Publish(ctx, entity.DomainEvent{ID: "1", Event: entity.EventNameProductCreated}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(ctx, entity.DomainEvent{ID: "2", Event: entity.EventNameProductDeleted}); err != nil {
+		t.Fatal(err)
+	}
+	if len(named) != 1 || named[0] != "1" {
+		t.Errorf("ProductCreated handler got %v, want [1]", named)
+	}
+	if len(all) != 2 {
+		t.Errorf("catch-all handler got %v, want [1 2]", all)
+	}
+}
+
+func TestInProcessFailsOnFailingHandler(t *testing.T) {
+	p := NewInProcess()
+	down := errors.New("projection unavailable")
+	p.Subscribe("", func(ctx context.Context, event entity.DomainEvent) error { return down })
+
+	if err := p.Publish(context.Background(), entity.DomainEvent{ID: "1", Event: entity.EventNameProductCreated}); !errors.Is(err, down) {
+		t.Fatalf("got error %v, want the handler's error", err)
+	}
+}
+
+func TestMemoryRecordsPublishedEvents(t *testing.T) {
+	p := NewMemory()
+	ctx := context.Background()
+	p.Publish(ctx, entity.DomainEvent{ID: "1"})
+
+	p.SetErr(errors.New("broker unavailable"))
+	if err := p.Publish(ctx, entity.DomainEvent{ID: "2"}); err == nil {
+		t.Error("publish succeeded while failing")
+	}
+	p.SetErr(nil)
+	p.Publish(ctx, entity.DomainEvent{ID: "3"})
+
+	events := p.Events()
+	if len(events) != 2 || events[0].ID != "1" || events[1].ID != "3" {
+		t.Errorf("got events %+v, want 1 and 3", events)
+	}
+}
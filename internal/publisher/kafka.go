@@ -0,0 +1,52 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka publishes every event to one topic, keyed by product ID so that the events of a
+// product stay ordered within their partition
+type Kafka struct {
+	writer *kafka.Writer
+}
+
+func NewKafka(brokers []string, topic string) *Kafka {
+	return &Kafka{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+// Publish writes the event and waits until all in-sync replicas have acknowledged it
+func (p *Kafka) Publish(ctx context.Context, event entity.DomainEvent) error {
+	body, err := encode(event)
+	if err != nil {
+		return err
+	}
+
+	err = p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.AggregateID),
+		Value: body,
+		Headers: []kafka.Header{
+			{Key: HeaderEventID, Value: []byte(event.ID)},
+			{Key: HeaderEventName, Value: []byte(event.Event)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish %s to Kafka: %w", event.ID, err)
+	}
+	return nil
+}
+
+func (p *Kafka) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// Memory records published events, for tests and local development
+type Memory struct {
+	mu     sync.Mutex
+	events []entity.DomainEvent
+	err    error
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (p *Memory) Publish(ctx context.Context, event entity.DomainEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns the published events in publish order
+func (p *Memory) Events() []entity.DomainEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]entity.DomainEvent{}, p.events...)
+}
+
+// SetErr makes subsequent publishes fail with err, or succeed again if err is nil
+func (p *Memory) SetErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
@@ -0,0 +1,52 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes every event on "<prefix>.<event name>", e.g. products.events.ProductCreated.
+// The event ID is sent as Nats-Msg-Id, so JetStream streams drop redelivered events.
+type NATS struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+// NewNATS connects to the NATS server at url
+func NewNATS(url string, prefix string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("go-with-couchdb outbox relay"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &NATS{conn: conn, prefix: prefix}, nil
+}
+
+// Publish sends the event and waits until the server has received it
+func (p *NATS) Publish(ctx context.Context, event entity.DomainEvent) error {
+	body, err := encode(event)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(p.prefix + "." + event.Event)
+	msg.Data = body
+	msg.Header.Set(nats.MsgIdHdr, event.ID)
+	msg.Header.Set(HeaderEventID, event.ID)
+	msg.Header.Set(HeaderEventName, event.Event)
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish %s to NATS: %w", event.ID, err)
+	}
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("failed to flush %s to NATS: %w", event.ID, err)
+	}
+	return nil
+}
+
+// Close drains pending messages and closes the connection
+func (p *NATS) Close() error {
+	return p.conn.Drain()
+}
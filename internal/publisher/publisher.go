@@ -0,0 +1,42 @@
+// Package publisher contains the EventPublisher adapters the outbox relay can deliver
+// domain events to
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// Metadata carried next to every published event, as NATS or Kafka headers
+const (
+	HeaderEventID   = "Event-Id"
+	HeaderEventName = "Event-Name"
+)
+
+// message is the wire format of a published event. The outbox bookkeeping (revision,
+// status, attempts) stays behind.
+type message struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	AggregateID string          `json:"aggregate_id"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// encode marshals an event to its wire format
+func encode(event entity.DomainEvent) ([]byte, error) {
+	body, err := json.Marshal(message{
+		ID:          event.ID,
+		Event:       event.Event,
+		AggregateID: event.AggregateID,
+		OccurredAt:  event.OccurredAt,
+		Payload:     event.Payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode domain event %s: %w", event.ID, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLimited is reported when a client has used up its requests
+var ErrLimited = errors.New("rate limited")
+
+// Limit is a token bucket: it holds up to Requests tokens, refilled evenly over Per.
+// A client may burst Requests requests and then sustain Requests per Per.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit written as "<requests>/<duration>", e.g. "300/1m". "off"
+// parses to the zero limit, which is not enforced.
+func ParseLimit(spec string) (Limit, error) {
+	if spec == "off" {
+		return Limit{}, nil
+	}
+	requests, per, ok := strings.Cut(spec, "/")
+	n, err := strconv.Atoi(requests)
+	if !ok || err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit '%s', expected <requests>/<duration> such as 300/1m", spec)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit '%s', expected <requests>/<duration> such as 300/1m", spec)
+	}
+	return Limit{Requests: n, Per: d}, nil
+}
+
+// Enabled reports whether the limit is enforced
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// rate returns the tokens added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+	// Remaining counts the whole tokens left
+	Remaining int
+	// RetryAfter is the time until the next token, when the request was not allowed
+	RetryAfter time.Duration
+	// ResetAfter is the time until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Store keeps token buckets by key. MemoryStore limits a single instance; a store shared
+// by all instances, e.g. on Redis, enforces one limit across them.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Bucket is the state of a token bucket, for stores that persist it
+type Bucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// Take refills a bucket for the time passed since its last update and takes a token if
+// one is left. A new bucket is the zero Bucket and starts full.
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	capacity, rate := float64(limit.Requests), limit.rate()
+	if b.Updated.IsZero() {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*rate)
+	}
+	b.Updated = now
+
+	var result Result
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.Tokens) / rate)
+	}
+	result.Remaining = int(b.Tokens)
+	result.ResetAfter = seconds((capacity - b.Tokens) / rate)
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// apiKeyDocType tags API key documents
+const apiKeyDocType = "api_key"
+
+// apiKeyDoc is an API key as stored in CouchDB, tagged with its document type
+type apiKeyDoc struct {
+	entity.APIKey
+	Type string `json:"type"`
+}
+
+// APIKeyRepo is the CouchDB backed APIKeyRepository
+type APIKeyRepo struct {
+	db *kivik.DB
+}
+
+// NewAPIKeyRepo creates an API key repository on top of the given API keys database
+func NewAPIKeyRepo(db *kivik.DB) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+// CreateAPIKey stores a new API key and returns it with its revision. key.ID must be set.
+func (r *APIKeyRepo) CreateAPIKey(ctx context.Context, key entity.APIKey) (*entity.APIKey, error) {
+	rev, err := r.db.Put(ctx, key.ID, apiKeyDoc{APIKey: key, Type: apiKeyDocType})
+	if err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return nil, conflictError(fmt.Sprintf("API key with ID %s already exists", key.ID), err)
+		}
+		log.Println("Failed to create API key:", err)
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	key.Rev = rev
+	return &key, nil
+}
+
+// ListAPIKeys returns all API keys, revoked ones included, ordered by ID
+func (r *APIKeyRepo) ListAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	rows, err := r.db.Query(ctx, "_design/api_keys", "_view/api_keys", kivik.Options{"include_docs": true})
+	if err != nil {
+		log.Println("Failed to query API keys:", err)
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []entity.APIKey{}
+	for rows.Next() {
+		var key entity.APIKey
+		if err := rows.ScanDoc(&key); err != nil {
+			log.Println("Failed to scan API key:", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyById retrieves an API key by its ID
+func (r *APIKeyRepo) GetAPIKeyById(ctx context.Context, id string) (*entity.APIKey, error) {
+	var doc apiKeyDoc
+	if err := r.db.Get(ctx, id).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return nil, resourceNotFoundError("API key", id, err)
+		}
+		log.Println("Failed to retrieve API key:", err)
+		return nil, fmt.Errorf("failed to retrieve API key: %w", err)
+	}
+	if doc.Type != apiKeyDocType {
+		return nil, resourceNotFoundError("API key", id, nil)
+	}
+	return &doc.APIKey, nil
+}
+
+// UpdateAPIKeyById replaces an API key. key.Rev must be the current revision.
+func (r *APIKeyRepo) UpdateAPIKeyById(ctx context.Context, id string, key entity.APIKey) (*entity.APIKey, error) {
+	key.ID = id
+	rev, err := r.db.Put(ctx, id, apiKeyDoc{APIKey: key, Type: apiKeyDocType})
+	if err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return nil, conflictError(fmt.Sprintf("revision %s is not the current revision of API key %s", key.Rev, id), err)
+		}
+		log.Println("Failed to update API key:", err)
+		return nil, fmt.Errorf("failed to update API key: %w", err)
+	}
+
+	key.Rev = rev
+	return &key, nil
+}
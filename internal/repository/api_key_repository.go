@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"context"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// APIKeyRepository stores API keys. Keys are never deleted; revoked keys stay listed.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key entity.APIKey) (*entity.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]entity.APIKey, error)
+	GetAPIKeyById(ctx context.Context, id string) (*entity.APIKey, error)
+	UpdateAPIKeyById(ctx context.Context, id string, key entity.APIKey) (*entity.APIKey, error)
+}
+
+// Ensure both backends satisfy the interface
+var (
+	_ APIKeyRepository = (*APIKeyRepo)(nil)
+	_ APIKeyRepository = (*MemoryAPIKeyRepo)(nil)
+)
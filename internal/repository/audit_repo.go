@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// auditDocType tags audit entries in the audit database
+const auditDocType = "audit_entry"
+
+// auditDoc is an audit entry as stored in CouchDB, tagged with its document type
+type auditDoc struct {
+	entity.AuditEntry
+	Type string `json:"type"`
+}
+
+// AuditRepo is the CouchDB backed AuditRepository
+type AuditRepo struct {
+	db *kivik.DB
+}
+
+// NewAuditRepo creates an audit repository on top of the given audit database
+func NewAuditRepo(db *kivik.DB) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+// SaveAuditEntries appends entries to the audit log
+func (r *AuditRepo) SaveAuditEntries(ctx context.Context, entries []entity.AuditEntry) error {
+	docs := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		docs[i] = auditDoc{AuditEntry: prepareAuditEntry(entry), Type: auditDocType}
+	}
+
+	bulk, err := r.db.BulkDocs(ctx, docs)
+	if err != nil {
+		log.Println("Failed to save audit entries:", err)
+		return fmt.Errorf("failed to save audit entries: %w", err)
+	}
+	defer bulk.Close()
+
+	for bulk.Next() {
+		if err := bulk.UpdateErr(); err != nil {
+			return fmt.Errorf("failed to save audit entry %s: %w", bulk.ID(), err)
+		}
+	}
+	return bulk.Err()
+}
+
+// ListAuditEntries returns one page of audit entries matching the query, newest first.
+// Each combination of the actor and product filters has its own view, keyed by the
+// filtered fields followed by the timestamp.
+func (r *AuditRepo) ListAuditEntries(ctx context.Context, q AuditQuery) (*AuditPage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	view := "by_time"
+	prefix := []interface{}{}
+	switch {
+	case q.Actor != "" && q.ProductID != "":
+		view = "by_actor_product"
+		prefix = append(prefix, q.Actor, q.ProductID)
+	case q.Actor != "":
+		view = "by_actor"
+		prefix = append(prefix, q.Actor)
+	case q.ProductID != "":
+		view = "by_product"
+		prefix = append(prefix, q.ProductID)
+	}
+
+	cursor, err := decodeCursor(q.Cursor, "audit:"+view)
+	if err != nil {
+		return nil, err
+	}
+
+	// Descending order swaps the start and end keys; {} sorts after every string
+	var upper, lower interface{} = map[string]interface{}{}, nil
+	if !q.To.IsZero() {
+		upper = auditKey(q.To)
+	}
+	if !q.From.IsZero() {
+		lower = auditKey(q.From)
+	}
+	endKey := prefix
+	if lower != nil {
+		endKey = append(append([]interface{}{}, prefix...), lower)
+	}
+
+	// Fetch one extra row to find where the next page starts
+	queryOpts := kivik.Options{
+		"include_docs": true,
+		"descending":   true,
+		"startkey":     append(append([]interface{}{}, prefix...), upper),
+		"endkey":       endKey,
+		"limit":        q.Limit + 1,
+	}
+	if cursor != nil {
+		queryOpts["startkey"] = cursor.Key
+		queryOpts["startkey_docid"] = cursor.ID
+	}
+
+	rows, err := r.db.Query(ctx, "_design/audit", "_view/"+view, queryOpts)
+	if err != nil {
+		log.Println("Failed to query audit entries:", err)
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	page := &AuditPage{Entries: []entity.AuditEntry{}, Limit: q.Limit}
+	for rows.Next() {
+		if len(page.Entries) == q.Limit {
+			page.NextCursor = encodeCursor("audit:"+view, json.RawMessage(rows.Key()), rows.ID())
+			break
+		}
+
+		var entry entity.AuditEntry
+		if err := rows.ScanDoc(&entry); err != nil {
+			log.Println("Failed to scan audit entry:", err)
+			continue
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/google/uuid"
+)
+
+// Audit log page size limits
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 500
+)
+
+// AuditQuery filters the audit log. Empty fields match every entry; From and To bound
+// the entry timestamps, both inclusive.
+type AuditQuery struct {
+	Actor     string
+	ProductID string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Cursor    string
+}
+
+// AuditPage is one page of the audit log, newest entry first
+type AuditPage struct {
+	Entries    []entity.AuditEntry `json:"entries"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	Limit      int                 `json:"limit"`
+}
+
+// AuditRepository stores the audit log
+type AuditRepository interface {
+	SaveAuditEntries(ctx context.Context, entries []entity.AuditEntry) error
+	ListAuditEntries(ctx context.Context, q AuditQuery) (*AuditPage, error)
+}
+
+// Ensure both backends satisfy the interface
+var (
+	_ AuditRepository = (*AuditRepo)(nil)
+	_ AuditRepository = (*MemoryAuditRepo)(nil)
+)
+
+// normalize applies defaults and validates the query
+func (q *AuditQuery) normalize() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultAuditLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxAuditLimit {
+		return NewValidationError(fmt.Sprintf("limit must be between 1 and %d", MaxAuditLimit), nil)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return NewValidationError("to must not be before from", nil)
+	}
+	return nil
+}
+
+// auditKey formats a time as it is stored in audit entries and their view keys
+func auditKey(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// prepareAuditEntry assigns an ID to a new audit entry and stores its timestamp with
+// second precision, so timestamps sort correctly as strings. The ID starts with the
+// exact time, which keeps entries of the same second in order.
+func prepareAuditEntry(entry entity.AuditEntry) entity.AuditEntry {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if entry.ID == "" {
+		entry.ID = fmt.Sprintf("%020d-%s", entry.Timestamp.UnixNano(), uuid.New().String()[:8])
+	}
+	entry.Timestamp = entry.Timestamp.UTC().Truncate(time.Second)
+	return entry
+}
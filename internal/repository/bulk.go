@@ -0,0 +1,46 @@
+package repository
+
+import "e-learning/go-with-couchdb/internal/entity"
+
+// BulkOptions controls how bulk writes handle failing items
+type BulkOptions struct {
+	// AllOrNothing validates every item first and writes nothing if any item fails
+	AllOrNothing bool
+}
+
+// BulkItemResult is the outcome of one item of a bulk write. Index refers to the
+// position of the item in the request. Err is nil for items that were written;
+// bulk creates also return the stored product for those.
+// Status, Code, Error and Details are filled in by the HTTP layer.
+type BulkItemResult struct {
+	Index   int               `json:"index"`
+	ID      string            `json:"id,omitempty"`
+	Rev     string            `json:"rev,omitempty"`
+	Status  int               `json:"status"`
+	Code    string            `json:"code,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Product *entity.Product   `json:"product,omitempty"`
+	Err     error             `json:"-"`
+}
+
+// AbortPending marks every item that has not failed yet as aborted
+func AbortPending(results []BulkItemResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = abortedError()
+			results[i].Rev = ""
+			results[i].Product = nil
+		}
+	}
+}
+
+// hasFailures reports whether any item failed
+func hasFailures(results []BulkItemResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// Change types reported by the product change feed
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// SinceNow starts a change feed at the current end of the feed, skipping past changes
+const SinceNow = "now"
+
+// changesHeartbeat is how often, in milliseconds, CouchDB sends a newline on an idle
+// continuous feed; without it the feed is closed after CouchDB's default timeout
+const changesHeartbeat = 10000
+
+// ProductChange is one entry of the product change feed. Seq is an opaque position
+// from which the feed can be resumed. Product is nil for deletions; moving a product
+// to the trash is reported as its deletion.
+type ProductChange struct {
+	Seq     string          `json:"seq"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Rev     string          `json:"rev"`
+	Product *entity.Product `json:"product,omitempty"`
+}
+
+// ProductChangeFeed is an open product change feed. Next blocks until the next change
+// is available and returns false once the feed's context is cancelled or it fails.
+type ProductChangeFeed interface {
+	Next() bool
+	Change() ProductChange
+	Err() error
+	Close() error
+}
+
+// changeType derives the kind of change from the new revision
+func changeType(rev string, deleted bool) string {
+	switch {
+	case deleted:
+		return ChangeDeleted
+	case strings.HasPrefix(rev, "1-"):
+		return ChangeCreated
+	default:
+		return ChangeUpdated
+	}
+}
+
+// couchChangeFeed follows the continuous _changes feed of the products database
+type couchChangeFeed struct {
+	feed   *kivik.Changes
+	change ProductChange
+}
+
+// WatchProducts opens a continuous feed of product changes starting after the
+// sequence since, or at the current end of the feed for SinceNow. The feed ends
+// when ctx is cancelled.
+func (r *ProductRepo) WatchProducts(ctx context.Context, since string) (ProductChangeFeed, error) {
+	db := r.db
+
+	feed, err := db.Changes(ctx, kivik.Options{
+		"feed":         "continuous",
+		"since":        since,
+		"include_docs": true,
+		"heartbeat":    changesHeartbeat,
+	})
+	if err != nil {
+		if kivik.StatusCode(err) == 400 {
+			return nil, NewValidationError(fmt.Sprintf("invalid change sequence '%s'", since), nil)
+		}
+		log.Println("Failed to open changes feed:", err)
+		return nil, fmt.Errorf("failed to open changes feed: %w", err)
+	}
+	return &couchChangeFeed{feed: feed}, nil
+}
+
+// Next advances to the next product change, skipping design documents, name
+// reservations and other non-product documents, and the final deletion of products
+// purged from the trash
+func (f *couchChangeFeed) Next() bool {
+	for f.feed.Next() {
+		var doc map[string]interface{}
+		if err := f.feed.ScanDoc(&doc); err != nil {
+			log.Println("Failed to scan change:", f.feed.ID(), err)
+			continue
+		}
+		if doc == nil {
+			doc = map[string]interface{}{"_id": f.feed.ID()}
+		}
+		if !isProductDoc(doc) || doc["purged"] == true {
+			continue
+		}
+
+		rev := ""
+		if revs := f.feed.Changes(); len(revs) > 0 {
+			rev = revs[0]
+		}
+		deleted := f.feed.Deleted() || doc["deleted_at"] != nil
+		f.change = ProductChange{
+			Seq:  f.feed.Seq(),
+			Type: changeType(rev, deleted),
+			ID:   f.feed.ID(),
+			Rev:  rev,
+		}
+		if !deleted {
+			var product entity.Product
+			if err := f.feed.ScanDoc(&product); err != nil {
+				log.Println("Failed to scan changed product:", f.feed.ID(), err)
+				continue
+			}
+			f.change.Product = &product
+		}
+		return true
+	}
+	return false
+}
+
+// Change returns the current change
+func (f *couchChangeFeed) Change() ProductChange {
+	return f.change
+}
+
+// Err returns the error that ended the feed. A cancelled context is not an error.
+func (f *couchChangeFeed) Err() error {
+	err := f.feed.Err()
+	if err == nil || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return fmt.Errorf("changes feed failed: %w", err)
+}
+
+// Close closes the feed
+func (f *couchChangeFeed) Close() error {
+	return f.feed.Close()
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"log"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// companionDocs returns the documents written together with a product write: its
+// domain events and the history snapshot of the version it replaces. before is nil for
+// creates and after is nil for moves to the trash.
+func companionDocs(before *entity.Product, after *entity.Product) []interface{} {
+	return append(eventDocs(productEvents(before, after)), historyDocs(before)...)
+}
+
+// writeWithCompanions writes a product document and its companion documents in a single
+// _bulk_docs call. CouchDB does not make the call atomic, so when the product is
+// rejected the companions that were written are removed again. It returns the product's
+// new revision or its per-document error.
+func writeWithCompanions(ctx context.Context, db *kivik.DB, doc interface{}, companions []interface{}) (string, error) {
+	docs := append([]interface{}{doc}, companions...)
+	bulk, err := db.BulkDocs(ctx, docs)
+	if err != nil {
+		return "", err
+	}
+	defer bulk.Close()
+
+	var rev string
+	var productErr error
+	written := make(map[string]string)
+	for n := 0; bulk.Next(); n++ {
+		if n == 0 {
+			rev, productErr = bulk.Rev(), bulk.UpdateErr()
+			continue
+		}
+		if err := bulk.UpdateErr(); err != nil {
+			log.Println("Failed to write companion document:", bulk.ID(), err)
+			continue
+		}
+		written[bulk.ID()] = bulk.Rev()
+	}
+	if err := bulk.Err(); err != nil {
+		return "", err
+	}
+
+	if productErr != nil {
+		discardDocs(ctx, db, written)
+		return "", productErr
+	}
+	return rev, nil
+}
+
+// discardDocs deletes companion documents, given as ID to revision, whose product write did not happen
+func discardDocs(ctx context.Context, db *kivik.DB, docs map[string]string) {
+	for id, rev := range docs {
+		if _, err := db.Delete(ctx, id, rev); err != nil {
+			log.Println("Failed to discard companion document:", id, err)
+		}
+	}
+}
+
+// writtenCompanion is a companion document written by a bulk call, with the index of its product
+type writtenCompanion struct {
+	owner int
+	id    string
+	rev   string
+}
+
+// recordCompanion notes the outcome of writing a bulk companion document
+func recordCompanion(written []writtenCompanion, owner int, bulk *kivik.BulkResults) []writtenCompanion {
+	if err := bulk.UpdateErr(); err != nil {
+		log.Println("Failed to write companion document:", bulk.ID(), err)
+		return written
+	}
+	return append(written, writtenCompanion{owner: owner, id: bulk.ID(), rev: bulk.Rev()})
+}
+
+// discardFailedCompanions removes the companions of bulk items that were not written or were rolled back
+func discardFailedCompanions(ctx context.Context, db *kivik.DB, written []writtenCompanion, results []BulkItemResult) {
+	discard := make(map[string]string)
+	for _, doc := range written {
+		if results[doc.owner].Err != nil {
+			discard[doc.id] = doc.rev
+		}
+	}
+	discardDocs(ctx, db, discard)
+}
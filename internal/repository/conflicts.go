@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// conflictSort identifies conflict listing cursors
+const conflictSort = "conflicts"
+
+// ConflictSet is a product together with the revisions that conflict with it, e.g.
+// after the same product was changed in two replicated databases
+type ConflictSet struct {
+	// Current is the revision CouchDB picked as the winner
+	Current entity.Product `json:"current"`
+	// Conflicts are the losing leaf revisions
+	Conflicts []entity.Product `json:"conflicts"`
+	// Base is the latest version all revisions descend from, if the history still has it
+	Base *entity.Product `json:"base,omitempty"`
+}
+
+// revisionsDoc is a product read together with its revision path
+type revisionsDoc struct {
+	entity.Product
+	Revisions struct {
+		Start int      `json:"start"`
+		IDs   []string `json:"ids"`
+	} `json:"_revisions"`
+}
+
+// path returns the revisions leading to the document, newest first
+func (d revisionsDoc) path() []string {
+	path := make([]string, len(d.Revisions.IDs))
+	for i, hash := range d.Revisions.IDs {
+		path[i] = fmt.Sprintf("%d-%s", d.Revisions.Start-i, hash)
+	}
+	return path
+}
+
+// commonAncestor returns the newest revision found on every path, or "" if there is none
+func commonAncestor(paths [][]string) string {
+	for _, rev := range paths[0] {
+		shared := true
+		for _, path := range paths[1:] {
+			if !containsString(path, rev) {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			return rev
+		}
+	}
+	return ""
+}
+
+// ListConflicts retrieves one page of products that have conflicting revisions,
+// ordered by ID. Each product lists its losing revisions in _conflicts. opts.Sort is ignored.
+func (r *ProductRepo) ListConflicts(ctx context.Context, opts ListOptions) (*ProductPage, error) {
+	db := r.db
+
+	opts.Sort = SortByName
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, conflictSort)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to find where the next page starts
+	queryOpts := kivik.Options{
+		"include_docs": true,
+		"conflicts":    true,
+		"limit":        opts.Limit + 1,
+	}
+	if cursor != nil {
+		queryOpts["startkey"] = cursor.Key
+		queryOpts["startkey_docid"] = cursor.ID
+	}
+
+	rows, err := db.Query(ctx, "_design/conflicts", "_view/products", queryOpts)
+	if err != nil {
+		log.Println("Failed to retrieve conflicted products:", err)
+		return nil, fmt.Errorf("failed to retrieve conflicted products: %w", err)
+	}
+	defer rows.Close()
+
+	page := &ProductPage{Products: []entity.Product{}, Limit: opts.Limit}
+	for rows.Next() {
+		if len(page.Products) == opts.Limit {
+			page.NextCursor = encodeCursor(conflictSort, json.RawMessage(rows.Key()), rows.ID())
+			break
+		}
+
+		var product entity.Product
+		if err := rows.ScanDoc(&product); err != nil {
+			log.Println("Failed to scan product:", err)
+			continue
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Failed to iterate conflicted products:", err)
+		return nil, fmt.Errorf("failed to retrieve conflicted products: %w", err)
+	}
+	page.TotalRows = rows.TotalRows()
+
+	return page, nil
+}
+
+// GetProductWithConflicts retrieves a product by its ID like GetProductById, listing
+// the revisions that conflict with it in _conflicts
+func (r *ProductRepo) GetProductWithConflicts(ctx context.Context, id string) (*entity.Product, error) {
+	doc, err := r.readRevision(ctx, id, kivik.Options{"conflicts": true})
+	if err != nil {
+		return nil, err
+	}
+	if doc.Trashed() {
+		return nil, notFoundError(id, nil)
+	}
+	return &doc.Product, nil
+}
+
+// GetProductConflicts retrieves a product together with the bodies of its conflicting
+// revisions and, if the history still has it, the version they diverged from
+func (r *ProductRepo) GetProductConflicts(ctx context.Context, id string) (*ConflictSet, error) {
+	current, err := r.readRevision(ctx, id, kivik.Options{"conflicts": true, "revs": true})
+	if err != nil {
+		return nil, err
+	}
+	if current.Trashed() {
+		return nil, notFoundError(id, nil)
+	}
+
+	set := &ConflictSet{Current: current.Product, Conflicts: []entity.Product{}}
+	set.Current.Conflicts = nil
+	paths := [][]string{current.path()}
+	for _, rev := range current.Conflicts {
+		doc, err := r.readRevision(ctx, id, kivik.Options{"rev": rev, "revs": true})
+		if err != nil {
+			return nil, err
+		}
+		set.Conflicts = append(set.Conflicts, doc.Product)
+		paths = append(paths, doc.path())
+	}
+	if len(set.Conflicts) == 0 {
+		return set, nil
+	}
+
+	if base := commonAncestor(paths); base != "" {
+		version, err := r.GetProductVersion(ctx, id, base)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		set.Base = version
+	}
+	return set, nil
+}
+
+// readRevision reads a product document with the given options, e.g. a specific revision
+func (r *ProductRepo) readRevision(ctx context.Context, id string, opts kivik.Options) (*revisionsDoc, error) {
+	var doc revisionsDoc
+	if err := r.db.Get(ctx, id, opts).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return nil, notFoundError(id, err)
+		}
+		log.Println("Failed to retrieve product revision:", err)
+		return nil, fmt.Errorf("failed to retrieve product revision: %w", err)
+	}
+	return &doc, nil
+}
+
+// DiscardConflicts deletes the losing revisions of a product, so the current revision
+// is left as its only version. Each losing revision is kept in the product history.
+func (r *ProductRepo) DiscardConflicts(ctx context.Context, id string, losing []entity.Product) error {
+	db := r.db
+
+	var docs []interface{}
+	for i := range losing {
+		docs = append(docs, map[string]interface{}{"_id": id, "_rev": losing[i].Rev, "_deleted": true})
+		docs = append(docs, historyDocs(&losing[i])...)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	bulk, err := db.BulkDocs(ctx, docs)
+	if err != nil {
+		log.Println("Failed to discard conflicting revisions:", err)
+		return fmt.Errorf("failed to discard conflicting revisions: %w", err)
+	}
+	defer bulk.Close()
+
+	var discardErr error
+	for bulk.Next() {
+		err := bulk.UpdateErr()
+		if err == nil {
+			continue
+		}
+		if bulk.ID() != id {
+			// A history document left behind by an earlier attempt is fine
+			if kivik.StatusCode(err) != 409 {
+				log.Println("Failed to keep conflicting revision in the history:", bulk.ID(), err)
+			}
+			continue
+		}
+		if kivik.StatusCode(err) == 409 { // The losing branch was written to in the meantime
+			discardErr = conflictError(fmt.Sprintf("a conflicting revision of product %s changed while it was resolved", id), err)
+			continue
+		}
+		discardErr = fmt.Errorf("failed to discard conflicting revision: %w", err)
+	}
+	if err := bulk.Err(); err != nil {
+		return fmt.Errorf("failed to discard conflicting revisions: %w", err)
+	}
+	return discardErr
+}
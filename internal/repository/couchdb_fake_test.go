@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-kivik/kivik/v3"
+	"github.com/go-kivik/kivik/v3/driver"
+	"github.com/google/uuid"
+)
+
+// fakeCouchDriver is a kivik driver keeping documents in memory. It implements the
+// document calls with CouchDB's revision checks, enough to run the CouchDB backed
+// repositories' write paths; views, Mango queries and the change feed are not supported.
+// Every client has databases of its own.
+type fakeCouchDriver struct{}
+
+func init() {
+	kivik.Register("fakecouch", fakeCouchDriver{})
+}
+
+// newFakeCouchDB returns an empty database of a new client of the fake driver
+func newFakeCouchDB(t *testing.T) *kivik.DB {
+	t.Helper()
+	client, err := kivik.New("fakecouch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.DB(context.Background(), t.Name())
+}
+
+func (fakeCouchDriver) NewClient(string) (driver.Client, error) {
+	return &fakeCouchClient{dbs: make(map[string]*fakeCouchDB)}, nil
+}
+
+type fakeCouchClient struct {
+	mu  sync.Mutex
+	dbs map[string]*fakeCouchDB
+}
+
+var errFakeCouchUnsupported = &kivik.Error{HTTPStatus: http.StatusNotImplemented, Message: "not supported by the fake driver"}
+
+func (c *fakeCouchClient) Version(context.Context) (*driver.Version, error) {
+	return &driver.Version{Version: "fake"}, nil
+}
+
+func (c *fakeCouchClient) AllDBs(context.Context, map[string]interface{}) ([]string, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func (c *fakeCouchClient) DBExists(context.Context, string, map[string]interface{}) (bool, error) {
+	return true, nil
+}
+
+func (c *fakeCouchClient) CreateDB(context.Context, string, map[string]interface{}) error {
+	return nil
+}
+
+func (c *fakeCouchClient) DestroyDB(context.Context, string, map[string]interface{}) error {
+	return errFakeCouchUnsupported
+}
+
+func (c *fakeCouchClient) DB(_ context.Context, name string, _ map[string]interface{}) (driver.DB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	db, ok := c.dbs[name]
+	if !ok {
+		db = &fakeCouchDB{docs: make(map[string]fakeCouchDoc)}
+		c.dbs[name] = db
+	}
+	return db, nil
+}
+
+// fakeCouchDoc is a stored document; deleted documents keep their revision as a tombstone
+type fakeCouchDoc struct {
+	generation int
+	rev        string
+	body       map[string]interface{}
+	deleted    bool
+}
+
+type fakeCouchDB struct {
+	mu   sync.Mutex
+	docs map[string]fakeCouchDoc
+}
+
+func fakeCouchStatus(status int, message string) error {
+	return &kivik.Error{HTTPStatus: status, Message: message}
+}
+
+func (db *fakeCouchDB) Get(_ context.Context, id string, _ map[string]interface{}) (*driver.Document, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	doc, ok := db.docs[id]
+	if !ok || doc.deleted {
+		return nil, fakeCouchStatus(http.StatusNotFound, "missing")
+	}
+	body, err := json.Marshal(doc.body)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Document{ContentLength: int64(len(body)), Rev: doc.rev, Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (db *fakeCouchDB) CreateDoc(ctx context.Context, doc interface{}, opts map[string]interface{}) (string, string, error) {
+	id := uuid.New().String()
+	rev, err := db.Put(ctx, id, doc, opts)
+	return id, rev, err
+}
+
+func (db *fakeCouchDB) Put(_ context.Context, id string, doc interface{}, _ map[string]interface{}) (string, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", fakeCouchStatus(http.StatusBadRequest, err.Error())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return "", fakeCouchStatus(http.StatusBadRequest, err.Error())
+	}
+	rev, _ := body["_rev"].(string)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	current, exists := db.docs[id]
+	live := exists && !current.deleted
+	if live && rev != current.rev || !live && rev != "" && rev != current.rev {
+		return "", fakeCouchStatus(http.StatusConflict, "Document update conflict.")
+	}
+	return db.write(id, current, body, false), nil
+}
+
+func (db *fakeCouchDB) Delete(_ context.Context, id string, rev string, _ map[string]interface{}) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	current, exists := db.docs[id]
+	if !exists || current.deleted {
+		return "", fakeCouchStatus(http.StatusNotFound, "missing")
+	}
+	if rev != current.rev {
+		return "", fakeCouchStatus(http.StatusConflict, "Document update conflict.")
+	}
+	return db.write(id, current, map[string]interface{}{}, true), nil
+}
+
+// write stores the next revision of a document; db.mu must be held
+func (db *fakeCouchDB) write(id string, current fakeCouchDoc, body map[string]interface{}, deleted bool) string {
+	generation := current.generation + 1
+	rev := fmt.Sprintf("%d-%s", generation, uuid.New().String()[:8])
+	body["_id"], body["_rev"] = id, rev
+	db.docs[id] = fakeCouchDoc{generation: generation, rev: rev, body: body, deleted: deleted}
+	return rev
+}
+
+func (db *fakeCouchDB) AllDocs(context.Context, map[string]interface{}) (driver.Rows, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) Stats(context.Context) (*driver.DBStats, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) Compact(context.Context) error {
+	return errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) CompactView(context.Context, string) error {
+	return errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) ViewCleanup(context.Context) error {
+	return errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) Security(context.Context) (*driver.Security, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) SetSecurity(context.Context, *driver.Security) error {
+	return errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) Changes(context.Context, map[string]interface{}) (driver.Changes, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) PutAttachment(context.Context, string, string, *driver.Attachment, map[string]interface{}) (string, error) {
+	return "", errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) GetAttachment(context.Context, string, string, map[string]interface{}) (*driver.Attachment, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) DeleteAttachment(context.Context, string, string, string, map[string]interface{}) (string, error) {
+	return "", errFakeCouchUnsupported
+}
+
+func (db *fakeCouchDB) Query(context.Context, string, string, map[string]interface{}) (driver.Rows, error) {
+	return nil, errFakeCouchUnsupported
+}
+
+func TestFakeCouchDBChecksRevisions(t *testing.T) {
+	db := newFakeCouchDB(t)
+	ctx := context.Background()
+
+	rev, err := db.Put(ctx, "doc", map[string]interface{}{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Put(ctx, "doc", map[string]interface{}{"n": 2}); kivik.StatusCode(err) != http.StatusConflict {
+		t.Errorf("got error %v writing without a revision, want 409", err)
+	}
+	if _, err := db.Delete(ctx, "doc", rev); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(ctx, "doc").ScanDoc(&struct{}{}); kivik.StatusCode(err) != http.StatusNotFound {
+		t.Errorf("got error %v reading a deleted document, want 404", err)
+	}
+	if _, err := db.Put(ctx, "doc", map[string]interface{}{"n": 3}); err != nil {
+		t.Errorf("got error %v recreating a deleted document", err)
+	}
+}
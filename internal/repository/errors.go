@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// Sentinel errors returned by the repositories. Use errors.Is to test for them.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrDuplicateName    = errors.New("duplicate name")
+	ErrRevisionConflict = errors.New("revision conflict")
+	ErrValidation       = errors.New("validation failed")
+	ErrAborted          = errors.New("aborted")
+	ErrPrecondition     = errors.New("precondition failed")
+	ErrKeyReused        = errors.New("idempotency key reused")
+	ErrInProgress       = errors.New("request in progress")
+	ErrUnpatchable      = errors.New("field cannot be patched")
+)
+
+// Error is a domain error of one of the sentinel kinds above. When it originates
+// from CouchDB it wraps the kivik error, which stays reachable through errors.As.
+type Error struct {
+	Kind    error
+	Message string
+	Details map[string]string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether the error is of the given sentinel kind
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying (kivik) error, if any
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status implied by the kind. The wrapped error's status
+// is only used for errors without a known kind, as CouchDB's status often differs
+// (a stale revision is a 409 from CouchDB but a 412 for an If-Match request).
+func (e *Error) StatusCode() int {
+	switch e.Kind {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrDuplicateName, ErrRevisionConflict, ErrInProgress:
+		return http.StatusConflict
+	case ErrValidation:
+		return http.StatusBadRequest
+	case ErrAborted:
+		return http.StatusFailedDependency
+	case ErrPrecondition:
+		return http.StatusPreconditionFailed
+	case ErrKeyReused, ErrUnpatchable:
+		return http.StatusUnprocessableEntity
+	}
+	if e.Err != nil {
+		return kivik.StatusCode(e.Err)
+	}
+	return http.StatusInternalServerError
+}
+
+// NewValidationError creates an ErrValidation error with optional per-field details
+func NewValidationError(message string, details map[string]string) error {
+	return &Error{Kind: ErrValidation, Message: message, Details: details}
+}
+
+// NewPreconditionFailedError creates an ErrPrecondition error, used when a conditional
+// request (If-Match) no longer matches the stored revision
+func NewPreconditionFailedError(message string, err error) error {
+	return &Error{Kind: ErrPrecondition, Message: message, Err: err}
+}
+
+// NewKeyReusedError creates an ErrKeyReused error, used when an idempotency key comes
+// back with a different request
+func NewKeyReusedError(key string) error {
+	return &Error{Kind: ErrKeyReused, Message: fmt.Sprintf("idempotency key '%s' was used for a different request", key)}
+}
+
+// NewInProgressError creates an ErrInProgress error, used when a request with the same
+// idempotency key is still being processed
+func NewInProgressError(key string) error {
+	return &Error{Kind: ErrInProgress, Message: fmt.Sprintf("a request with idempotency key '%s' is still in progress", key)}
+}
+
+// NewUnpatchableError creates an ErrUnpatchable error, used when a patch touches a field
+// that is read-only or unknown
+func NewUnpatchableError(path string) error {
+	return &Error{Kind: ErrUnpatchable, Message: fmt.Sprintf("'%s' cannot be patched; only name and price can", path), Details: map[string]string{"path": path}}
+}
+
+func notFoundError(id string, err error) error {
+	return resourceNotFoundError("product", id, err)
+}
+
+func resourceNotFoundError(resource string, id string, err error) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("%s with ID %s not found", resource, id), Err: err}
+}
+
+func duplicateNameError(name string) error {
+	return &Error{Kind: ErrDuplicateName, Message: fmt.Sprintf("product with name '%s' already exists", name)}
+}
+
+func revisionConflictError(expected, got string, err error) error {
+	return &Error{
+		Kind:    ErrRevisionConflict,
+		Message: fmt.Sprintf("revision mismatch: expected %s, got %s", expected, got),
+		Err:     err,
+	}
+}
+
+func conflictError(message string, err error) error {
+	return &Error{Kind: ErrRevisionConflict, Message: message, Err: err}
+}
+
+func abortedError() error {
+	return &Error{Kind: ErrAborted, Message: "not written because another item in the batch failed"}
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+func TestErrorStatusCode(t *testing.T) {
+	conflict := &kivik.Error{HTTPStatus: http.StatusConflict, Message: "Document update conflict."}
+	unavailable := &kivik.Error{HTTPStatus: http.StatusServiceUnavailable, Message: "Service unavailable"}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"stale If-Match revision", NewPreconditionFailedError("revision mismatch", conflict), http.StatusPreconditionFailed},
+		{"not found wrapping a conflict", resourceNotFoundError("product", "laptop", conflict), http.StatusNotFound},
+		{"validation", NewValidationError("invalid cursor", nil), http.StatusBadRequest},
+		{"revision conflict", conflictError("changed concurrently", conflict), http.StatusConflict},
+		{"aborted", abortedError(), http.StatusFailedDependency},
+		{"key reused", NewKeyReusedError("k1"), http.StatusUnprocessableEntity},
+		{"no kind, from CouchDB", &Error{Message: "unavailable", Err: unavailable}, http.StatusServiceUnavailable},
+		{"no kind, no cause", &Error{Message: "unknown"}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.(*Error).StatusCode(); got != tt.want {
+				t.Errorf("StatusCode() = %d, want %d", got, tt.want)
+			}
+			if got := kivik.StatusCode(tt.err); got != tt.want {
+				t.Errorf("kivik.StatusCode = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
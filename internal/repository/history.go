@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/actor"
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// historyIDPrefix is the ID prefix of product history documents. The product ID and
+// the zero padded revision that follow make a product's versions sort oldest first.
+const historyIDPrefix = "history:"
+
+// historyDocType tags product history documents
+const historyDocType = "product_version"
+
+// historySort identifies history listing cursors
+const historySort = "history"
+
+// historyDoc keeps a superseded version of a product. CouchDB compaction discards old
+// revision bodies, so every write stores the version it replaces as a document of its
+// own. The snapshot is nested, which keeps it out of the product views and indexes.
+type historyDoc struct {
+	ID        string         `json:"_id"`
+	Rev       string         `json:"_rev,omitempty"`
+	Type      string         `json:"type"`
+	ProductID string         `json:"product_id"`
+	Product   entity.Product `json:"product"`
+}
+
+// HistoryPage is one page of a product's history, newest version first
+type HistoryPage struct {
+	Versions   []entity.ProductVersion `json:"versions"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+	Limit      int                     `json:"limit"`
+}
+
+// stamp records the current time and the actor in ctx as the author of a product
+// version about to be written. Conflicts are never stored, so they are dropped.
+func stamp(ctx context.Context, product *entity.Product) {
+	now := time.Now().UTC().Truncate(time.Second)
+	product.UpdatedAt = &now
+	product.UpdatedBy = actor.FromContext(ctx)
+	product.Conflicts = nil
+}
+
+// historyPrefix returns the ID prefix of the history documents of a product
+func historyPrefix(productID string) string {
+	return historyIDPrefix + productID + ":"
+}
+
+// historyID returns the ID of the history document of a product revision. The
+// generation is zero padded so that "10-..." sorts after "9-...".
+func historyID(productID string, rev string) string {
+	generation, hash := rev, ""
+	if i := strings.IndexByte(rev, '-'); i > 0 {
+		generation, hash = rev[:i], rev[i:]
+	}
+	n, _ := strconv.Atoi(generation)
+	return fmt.Sprintf("%s%010d%s", historyPrefix(productID), n, hash)
+}
+
+// historyDocs returns the history document keeping the version a write replaces, if any
+func historyDocs(previous *entity.Product) []interface{} {
+	if previous == nil || previous.Rev == "" {
+		return nil
+	}
+	return []interface{}{historyDoc{
+		ID:        historyID(previous.ID, previous.Rev),
+		Type:      historyDocType,
+		ProductID: previous.ID,
+		Product:   *previous,
+	}}
+}
+
+// GetProductHistory returns the versions of a product, newest first, starting with
+// the stored one. Trashed products keep their history until they are purged.
+func (r *ProductRepo) GetProductHistory(ctx context.Context, id string, opts ListOptions) (*HistoryPage, error) {
+	db := r.db
+
+	opts.Sort = SortByName
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, historySort)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := r.getProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &HistoryPage{Versions: []entity.ProductVersion{}, Limit: opts.Limit}
+	startKey := historyPrefix(id) + "\ufff0"
+	if cursor != nil {
+		startKey = cursor.ID
+	} else {
+		page.Versions = append(page.Versions, entity.ProductVersion{Rev: current.Rev, Current: true, Product: *current})
+	}
+
+	// Fetch one extra row to find where the next page starts
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"descending":   true,
+		"startkey":     startKey,
+		"endkey":       historyPrefix(id),
+		"limit":        opts.Limit - len(page.Versions) + 1,
+	})
+	if err != nil {
+		log.Println("Failed to retrieve product history:", err)
+		return nil, fmt.Errorf("failed to retrieve product history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if len(page.Versions) == opts.Limit {
+			key, _ := json.Marshal(rows.ID())
+			page.NextCursor = encodeCursor(historySort, key, rows.ID())
+			break
+		}
+
+		var doc historyDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			log.Println("Failed to scan product version:", err)
+			continue
+		}
+		if doc.ProductID != id { // Another product whose ID starts with "<id>:"
+			continue
+		}
+		page.Versions = append(page.Versions, entity.ProductVersion{Rev: doc.Product.Rev, Product: doc.Product})
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Failed to iterate product history:", err)
+		return nil, fmt.Errorf("failed to retrieve product history: %w", err)
+	}
+
+	return page, nil
+}
+
+// GetProductVersion returns a product as it was at the given revision
+func (r *ProductRepo) GetProductVersion(ctx context.Context, id string, rev string) (*entity.Product, error) {
+	db := r.db
+
+	current, err := r.getProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Rev == rev {
+		return current, nil
+	}
+
+	var doc historyDoc
+	if err := db.Get(ctx, historyID(id, rev)).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return nil, resourceNotFoundError("version of product "+id, rev, err)
+		}
+		log.Println("Failed to retrieve product version:", err)
+		return nil, fmt.Errorf("failed to retrieve product version: %w", err)
+	}
+	return &doc.Product, nil
+}
+
+// removeHistory removes the history documents of a product that was purged from the trash
+func (r *ProductRepo) removeHistory(ctx context.Context, productID string, hard bool) {
+	db := r.db
+
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"startkey":     historyPrefix(productID),
+		"endkey":       historyPrefix(productID) + "\ufff0",
+	})
+	if err != nil {
+		log.Println("Failed to list product history for removal:", productID, err)
+		return
+	}
+	defer rows.Close()
+
+	revs := make(map[string]string)
+	for rows.Next() {
+		var doc historyDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			log.Println("Failed to scan product version:", err)
+			continue
+		}
+		if doc.ProductID == productID {
+			revs[doc.ID] = doc.Rev
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Failed to list product history for removal:", productID, err)
+		return
+	}
+
+	_, failed := removeDocs(ctx, db, revs, hard)
+	for id, reason := range failed {
+		log.Println("Failed to remove product version:", id, reason)
+	}
+}
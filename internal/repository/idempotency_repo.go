@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// idempotencyDocType tags idempotency records
+const idempotencyDocType = "idempotency_record"
+
+// idempotencyPurgeBatchSize is the number of expired records PurgeIdempotencyRecords removes per query
+const idempotencyPurgeBatchSize = 500
+
+// idempotencyDoc is an idempotency record as stored in CouchDB, tagged with its document type
+type idempotencyDoc struct {
+	entity.IdempotencyRecord
+	Type string `json:"type"`
+}
+
+// IdempotencyRepo is the CouchDB backed IdempotencyRepository
+type IdempotencyRepo struct {
+	db *kivik.DB
+}
+
+// NewIdempotencyRepo creates an idempotency repository on top of the given database
+func NewIdempotencyRepo(db *kivik.DB) *IdempotencyRepo {
+	return &IdempotencyRepo{db: db}
+}
+
+// CreateIdempotencyRecord stores a new record. It fails with ErrRevisionConflict if a
+// record with the same ID exists, which makes claiming a key atomic.
+func (r *IdempotencyRepo) CreateIdempotencyRecord(ctx context.Context, record entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	rev, err := r.db.Put(ctx, record.ID, idempotencyDoc{IdempotencyRecord: record, Type: idempotencyDocType})
+	if err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return nil, conflictError(fmt.Sprintf("idempotency record %s already exists", record.ID), err)
+		}
+		log.Println("Failed to create idempotency record:", err)
+		return nil, fmt.Errorf("failed to create idempotency record: %w", err)
+	}
+
+	record.Rev = rev
+	return &record, nil
+}
+
+// GetIdempotencyRecord retrieves a record by its ID
+func (r *IdempotencyRepo) GetIdempotencyRecord(ctx context.Context, id string) (*entity.IdempotencyRecord, error) {
+	var doc idempotencyDoc
+	if err := r.db.Get(ctx, id).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return nil, resourceNotFoundError("idempotency record", id, err)
+		}
+		log.Println("Failed to retrieve idempotency record:", err)
+		return nil, fmt.Errorf("failed to retrieve idempotency record: %w", err)
+	}
+	return &doc.IdempotencyRecord, nil
+}
+
+// UpdateIdempotencyRecord replaces a record. record.Rev must be the current revision.
+func (r *IdempotencyRepo) UpdateIdempotencyRecord(ctx context.Context, record entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	rev, err := r.db.Put(ctx, record.ID, idempotencyDoc{IdempotencyRecord: record, Type: idempotencyDocType})
+	if err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return nil, conflictError(fmt.Sprintf("revision %s is not the current revision of idempotency record %s", record.Rev, record.ID), err)
+		}
+		log.Println("Failed to update idempotency record:", err)
+		return nil, fmt.Errorf("failed to update idempotency record: %w", err)
+	}
+
+	record.Rev = rev
+	return &record, nil
+}
+
+// DeleteIdempotencyRecord deletes a record, freeing its key
+func (r *IdempotencyRepo) DeleteIdempotencyRecord(ctx context.Context, id string, rev string) error {
+	if _, err := r.db.Delete(ctx, id, rev); err != nil {
+		switch kivik.StatusCode(err) {
+		case 404:
+			return resourceNotFoundError("idempotency record", id, err)
+		case 409:
+			return conflictError(fmt.Sprintf("revision %s is not the current revision of idempotency record %s", rev, id), err)
+		}
+		log.Println("Failed to delete idempotency record:", err)
+		return fmt.Errorf("failed to delete idempotency record: %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyRecords deletes the records that expired before the given time and
+// returns how many were deleted
+func (r *IdempotencyRepo) PurgeIdempotencyRecords(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	for {
+		rows, err := r.db.Query(ctx, "_design/idempotency", "_view/expires", kivik.Options{
+			"endkey":        before.UTC().Truncate(time.Second).Format(time.RFC3339),
+			"inclusive_end": false,
+			"limit":         idempotencyPurgeBatchSize,
+		})
+		if err != nil {
+			log.Println("Failed to query expired idempotency records:", err)
+			return purged, fmt.Errorf("failed to query expired idempotency records: %w", err)
+		}
+		revs := make(map[string]string)
+		for rows.Next() {
+			var rev string
+			if err := rows.ScanValue(&rev); err != nil {
+				log.Println("Failed to scan idempotency record:", err)
+				continue
+			}
+			revs[rows.ID()] = rev
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return purged, fmt.Errorf("failed to query expired idempotency records: %w", err)
+		}
+
+		removed, failed := removeDocs(ctx, r.db, revs, false)
+		for id, reason := range failed {
+			log.Println("Failed to delete idempotency record:", id, reason)
+		}
+		purged += len(removed)
+		// Stop when the batch was the last one or made no progress
+		if len(revs) < idempotencyPurgeBatchSize || len(removed) == 0 {
+			return purged, nil
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// IdempotencyRepository stores the responses to requests sent with an idempotency key
+type IdempotencyRepository interface {
+	CreateIdempotencyRecord(ctx context.Context, record entity.IdempotencyRecord) (*entity.IdempotencyRecord, error)
+	GetIdempotencyRecord(ctx context.Context, id string) (*entity.IdempotencyRecord, error)
+	UpdateIdempotencyRecord(ctx context.Context, record entity.IdempotencyRecord) (*entity.IdempotencyRecord, error)
+	DeleteIdempotencyRecord(ctx context.Context, id string, rev string) error
+	PurgeIdempotencyRecords(ctx context.Context, before time.Time) (int, error)
+}
+
+// Ensure both backends satisfy the interface
+var (
+	_ IdempotencyRepository = (*IdempotencyRepo)(nil)
+	_ IdempotencyRepository = (*MemoryIdempotencyRepo)(nil)
+)
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// MemoryAPIKeyRepo is an in-memory APIKeyRepository with CouchDB-like revision checks.
+// It is intended for tests and local development without a CouchDB container.
+type MemoryAPIKeyRepo struct {
+	mu   sync.RWMutex
+	keys map[string]entity.APIKey
+}
+
+// NewMemoryAPIKeyRepo creates an empty in-memory API key repository
+func NewMemoryAPIKeyRepo() *MemoryAPIKeyRepo {
+	return &MemoryAPIKeyRepo{keys: make(map[string]entity.APIKey)}
+}
+
+// CreateAPIKey stores a new API key and returns it with its revision. key.ID must be set.
+func (r *MemoryAPIKeyRepo) CreateAPIKey(ctx context.Context, key entity.APIKey) (*entity.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[key.ID]; ok {
+		return nil, conflictError(fmt.Sprintf("API key with ID %s already exists", key.ID), errConflict())
+	}
+
+	key.Rev = nextDocRev("", key)
+	r.keys[key.ID] = key
+	return &key, nil
+}
+
+// ListAPIKeys returns all API keys, revoked ones included, ordered by ID
+func (r *MemoryAPIKeyRepo) ListAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]entity.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// GetAPIKeyById retrieves an API key by its ID
+func (r *MemoryAPIKeyRepo) GetAPIKeyById(ctx context.Context, id string) (*entity.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, resourceNotFoundError("API key", id, errNotFound())
+	}
+	return &key, nil
+}
+
+// UpdateAPIKeyById replaces an API key. key.Rev must be the current revision.
+func (r *MemoryAPIKeyRepo) UpdateAPIKeyById(ctx context.Context, id string, key entity.APIKey) (*entity.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.keys[id]
+	if !ok {
+		return nil, resourceNotFoundError("API key", id, errNotFound())
+	}
+	if existing.Rev != key.Rev {
+		return nil, conflictError(fmt.Sprintf("revision %s is not the current revision of API key %s", key.Rev, id), errConflict())
+	}
+
+	key.ID = id
+	key.Rev = nextDocRev(existing.Rev, key)
+	r.keys[id] = key
+	return &key, nil
+}
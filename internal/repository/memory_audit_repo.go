@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// MemoryAuditRepo is an in-memory AuditRepository.
+// It is intended for tests and local development without a CouchDB container.
+type MemoryAuditRepo struct {
+	mu      sync.RWMutex
+	entries []entity.AuditEntry
+}
+
+// NewMemoryAuditRepo creates an empty in-memory audit repository
+func NewMemoryAuditRepo() *MemoryAuditRepo {
+	return &MemoryAuditRepo{}
+}
+
+// SaveAuditEntries appends entries to the audit log
+func (r *MemoryAuditRepo) SaveAuditEntries(ctx context.Context, entries []entity.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range entries {
+		r.entries = append(r.entries, prepareAuditEntry(entry))
+	}
+	return nil
+}
+
+// ListAuditEntries returns one page of audit entries matching the query, newest first
+func (r *MemoryAuditRepo) ListAuditEntries(ctx context.Context, q AuditQuery) (*AuditPage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(q.Cursor, "audit")
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	matches := []entity.AuditEntry{}
+	for _, entry := range r.entries {
+		key := auditKey(entry.Timestamp)
+		switch {
+		case q.Actor != "" && entry.Actor != q.Actor,
+			q.ProductID != "" && entry.ProductID != q.ProductID,
+			!q.From.IsZero() && key < auditKey(q.From),
+			!q.To.IsZero() && key > auditKey(q.To):
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	r.mu.RUnlock()
+
+	// Entry IDs start with their creation time, so they order entries within a second too
+	sort.Slice(matches, func(i, j int) bool {
+		ki, kj := auditKey(matches[i].Timestamp), auditKey(matches[j].Timestamp)
+		if ki != kj {
+			return ki > kj
+		}
+		return matches[i].ID > matches[j].ID
+	})
+
+	page := &AuditPage{Entries: []entity.AuditEntry{}, Limit: q.Limit}
+	for _, entry := range matches {
+		key := auditKey(entry.Timestamp)
+		if cursor != nil {
+			var cursorKey string
+			_ = json.Unmarshal(cursor.Key, &cursorKey)
+			if key > cursorKey || (key == cursorKey && entry.ID > cursor.ID) {
+				continue
+			}
+		}
+		if len(page.Entries) == q.Limit {
+			raw, _ := json.Marshal(key)
+			page.NextCursor = encodeCursor("audit", raw, entry.ID)
+			break
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// MemoryIdempotencyRepo is an in-memory IdempotencyRepository with CouchDB-like revision
+// checks. It is intended for tests and local development without a CouchDB container.
+type MemoryIdempotencyRepo struct {
+	mu      sync.Mutex
+	records map[string]entity.IdempotencyRecord
+}
+
+// NewMemoryIdempotencyRepo creates an empty in-memory idempotency repository
+func NewMemoryIdempotencyRepo() *MemoryIdempotencyRepo {
+	return &MemoryIdempotencyRepo{records: make(map[string]entity.IdempotencyRecord)}
+}
+
+// CreateIdempotencyRecord stores a new record. It fails with ErrRevisionConflict if a
+// record with the same ID exists.
+func (r *MemoryIdempotencyRepo) CreateIdempotencyRecord(ctx context.Context, record entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.records[record.ID]; ok {
+		return nil, conflictError(fmt.Sprintf("idempotency record %s already exists", record.ID), errConflict())
+	}
+
+	record.Rev = nextDocRev("", record)
+	r.records[record.ID] = record
+	return &record, nil
+}
+
+// GetIdempotencyRecord retrieves a record by its ID
+func (r *MemoryIdempotencyRepo) GetIdempotencyRecord(ctx context.Context, id string) (*entity.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[id]
+	if !ok {
+		return nil, resourceNotFoundError("idempotency record", id, errNotFound())
+	}
+	return &record, nil
+}
+
+// UpdateIdempotencyRecord replaces a record. record.Rev must be the current revision.
+func (r *MemoryIdempotencyRepo) UpdateIdempotencyRecord(ctx context.Context, record entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.records[record.ID]
+	if !ok || existing.Rev != record.Rev {
+		return nil, conflictError(fmt.Sprintf("revision %s is not the current revision of idempotency record %s", record.Rev, record.ID), errConflict())
+	}
+
+	record.Rev = nextDocRev(existing.Rev, record)
+	r.records[record.ID] = record
+	return &record, nil
+}
+
+// DeleteIdempotencyRecord deletes a record, freeing its key
+func (r *MemoryIdempotencyRepo) DeleteIdempotencyRecord(ctx context.Context, id string, rev string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.records[id]
+	if !ok {
+		return resourceNotFoundError("idempotency record", id, errNotFound())
+	}
+	if existing.Rev != rev {
+		return conflictError(fmt.Sprintf("revision %s is not the current revision of idempotency record %s", rev, id), errConflict())
+	}
+	delete(r.records, id)
+	return nil
+}
+
+// PurgeIdempotencyRecords deletes the records that expired before the given time and
+// returns how many were deleted
+func (r *MemoryIdempotencyRepo) PurgeIdempotencyRecords(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for id, record := range r.records {
+		if record.ExpiresAt.Before(before) {
+			delete(r.records, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
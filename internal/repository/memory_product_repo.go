@@ -0,0 +1,947 @@
+package repository
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+	"github.com/google/uuid"
+)
+
+// MemoryProductRepo is an in-memory ProductRepository that mimics CouchDB semantics.
+// It is intended for tests and local development without a CouchDB container.
+type MemoryProductRepo struct {
+	mu   sync.RWMutex
+	docs map[string]entity.Product
+	// tombstones keeps the last revision of deleted documents so that
+	// re-creating a deleted ID continues its revision history, as CouchDB does
+	tombstones map[string]string
+	// changes emulates the _changes feed; changed is closed and replaced whenever it grows
+	changes []memoryChange
+	changed chan struct{}
+	// events is the outbox of domain events, keyed by ID
+	events map[string]entity.DomainEvent
+	// history keeps the superseded versions of each product, oldest first
+	history map[string][]entity.Product
+	// conflicts keeps the losing leaf revisions of products, see AddConflict
+	conflicts map[string][]memoryConflict
+}
+
+// memoryConflict is a conflicting leaf revision of a product and the revision it branched off
+type memoryConflict struct {
+	product entity.Product
+	base    string
+}
+
+// memoryChange is an entry of the emulated _changes feed
+type memoryChange struct {
+	id      string
+	rev     string
+	deleted bool
+	product entity.Product
+}
+
+// NewMemoryProductRepo creates an empty in-memory product repository
+func NewMemoryProductRepo() *MemoryProductRepo {
+	return &MemoryProductRepo{
+		docs:       make(map[string]entity.Product),
+		tombstones: make(map[string]string),
+		changed:    make(chan struct{}),
+		events:     make(map[string]entity.DomainEvent),
+		history:    make(map[string][]entity.Product),
+		conflicts:  make(map[string][]memoryConflict),
+	}
+}
+
+// CreateProduct creates a new product, ensuring the name is unique, and returns the stored product
+func (r *MemoryProductRepo) CreateProduct(ctx context.Context, product entity.Product) (*entity.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if product.ID == "" {
+		product.ID = uuid.New().String()
+	}
+	product.SchemaVersion = CurrentSchemaVersion
+	product.DeletedAt, product.DeletedBy = nil, ""
+	stamp(ctx, &product)
+
+	if r.nameExists(product.Name, "") {
+		return nil, duplicateNameError(product.Name)
+	}
+
+	rev, err := r.put(product)
+	if err != nil {
+		return nil, conflictError(fmt.Sprintf("product with ID %s already exists", product.ID), err)
+	}
+
+	product.Rev = rev
+	r.recordWrite(nil, &product)
+	return &product, nil
+}
+
+// GetAllProducts retrieves one page of products in the same order as the CouchDB views
+func (r *MemoryProductRepo) GetAllProducts(ctx context.Context, opts ListOptions) (*ProductPage, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	view := listViews[opts.Sort]
+	rows := r.viewRows(view)
+
+	start := 0
+	if cursor != nil {
+		var startKey interface{}
+		if err := json.Unmarshal(cursor.Key, &startKey); err != nil {
+			return nil, NewValidationError("invalid cursor", nil)
+		}
+		start = sort.Search(len(rows), func(i int) bool {
+			return compareRows(rows[i].key, rows[i].product.ID, startKey, cursor.ID, view.descending) >= 0
+		})
+	}
+
+	page := &ProductPage{Products: []entity.Product{}, Limit: opts.Limit, TotalRows: int64(len(rows))}
+	for i := start; i < len(rows); i++ {
+		if len(page.Products) == opts.Limit {
+			key, _ := json.Marshal(rows[i].key)
+			page.NextCursor = encodeCursor(opts.Sort, key, rows[i].product.ID)
+			break
+		}
+		page.Products = append(page.Products, rows[i].product)
+	}
+	return page, nil
+}
+
+// SearchProducts evaluates a product search against the stored documents
+func (r *MemoryProductRepo) SearchProducts(ctx context.Context, q ProductQuery) (*SearchPage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(q.Cursor, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	view := listViews[q.Sort]
+	var startKey interface{}
+	if cursor != nil {
+		if err := json.Unmarshal(cursor.Key, &startKey); err != nil {
+			return nil, NewValidationError("invalid cursor", nil)
+		}
+	}
+
+	page := &SearchPage{Products: []entity.Product{}, Limit: q.Limit}
+	for _, row := range r.viewRows(view) {
+		if cursor != nil && compareRows(row.key, row.product.ID, startKey, cursor.ID, view.descending) < 0 {
+			continue
+		}
+		if !q.matches(row.product) {
+			continue
+		}
+		if len(page.Products) == q.Limit {
+			key, _ := json.Marshal(row.key)
+			page.NextCursor = encodeCursor(q.Sort, key, row.product.ID)
+			break
+		}
+		page.Products = append(page.Products, row.product)
+	}
+	return page, nil
+}
+
+// GetProductById retrieves a product by its ID. Products in the trash are not found.
+func (r *MemoryProductRepo) GetProductById(ctx context.Context, id string) (*entity.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	product, ok := r.docs[id]
+	if !ok || product.Trashed() {
+		return nil, notFoundError(id, errNotFound())
+	}
+	return &product, nil
+}
+
+// UpdateProductById updates an existing product by ID
+func (r *MemoryProductRepo) UpdateProductById(ctx context.Context, id string, updatedProduct entity.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existingProduct, ok := r.docs[id]
+	if !ok || existingProduct.Trashed() {
+		return notFoundError(id, errNotFound())
+	}
+
+	if updatedProduct.Rev != existingProduct.Rev {
+		return revisionConflictError(existingProduct.Rev, updatedProduct.Rev, nil)
+	}
+
+	if r.nameExists(updatedProduct.Name, id) {
+		return duplicateNameError(updatedProduct.Name)
+	}
+
+	before := existingProduct
+	existingProduct.Name = updatedProduct.Name
+	existingProduct.Price = updatedProduct.Price
+	stamp(ctx, &existingProduct)
+
+	if _, err := r.put(existingProduct); err != nil {
+		return revisionConflictError(existingProduct.Rev, updatedProduct.Rev, err)
+	}
+	r.recordWrite(&before, &existingProduct)
+	return nil
+}
+
+// DeleteProductById moves a product to the trash by its ID and revision
+func (r *MemoryProductRepo) DeleteProductById(ctx context.Context, id string, rev string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.docs[id]
+	if !ok || existing.Trashed() {
+		return notFoundError(id, errNotFound())
+	}
+	if existing.Rev != rev {
+		return conflictError(fmt.Sprintf("revision %s is not the current revision of product %s", rev, id), errConflict())
+	}
+
+	if _, err := r.put(trash(ctx, existing)); err != nil {
+		return conflictError(fmt.Sprintf("revision %s is not the current revision of product %s", rev, id), err)
+	}
+	r.recordWrite(&existing, nil)
+	return nil
+}
+
+// WatchProducts opens a feed of product changes starting after the sequence since,
+// or at the current end of the feed for SinceNow. Sequences are change counts.
+func (r *MemoryProductRepo) WatchProducts(ctx context.Context, since string) (ProductChangeFeed, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seq := len(r.changes)
+	if since != SinceNow {
+		n, err := strconv.Atoi(since)
+		if err != nil || n < 0 {
+			return nil, NewValidationError(fmt.Sprintf("invalid change sequence '%s'", since), nil)
+		}
+		if n < seq {
+			seq = n
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &memoryChangeFeed{ctx: ctx, cancel: cancel, repo: r, seq: seq}, nil
+}
+
+// BulkCreateProducts creates multiple products and reports the outcome of every item.
+// As with CouchDB's _bulk_docs, each document is written independently unless
+// opts.AllOrNothing is set.
+func (r *MemoryProductRepo) BulkCreateProducts(ctx context.Context, products []entity.Product, opts BulkOptions) ([]BulkItemResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]BulkItemResult, len(products))
+	claimed := make(map[string]bool)
+	for i := range products {
+		if products[i].ID == "" {
+			products[i].ID = uuid.New().String()
+		}
+		products[i].SchemaVersion = CurrentSchemaVersion
+		products[i].DeletedAt, products[i].DeletedBy = nil, ""
+		stamp(ctx, &products[i])
+		results[i] = BulkItemResult{Index: i, ID: products[i].ID}
+
+		normalized := normalizeName(products[i].Name)
+		switch {
+		case claimed[normalized] || r.nameExists(products[i].Name, ""):
+			results[i].Err = duplicateNameError(products[i].Name)
+		case r.docs[products[i].ID].ID != "":
+			results[i].Err = conflictError(fmt.Sprintf("document update conflict for product %s", products[i].ID), errConflict())
+		default:
+			claimed[normalized] = true
+		}
+	}
+
+	if opts.AllOrNothing && hasFailures(results) {
+		AbortPending(results)
+		return results, nil
+	}
+
+	for i, product := range products {
+		if results[i].Err != nil {
+			continue
+		}
+		rev, err := r.put(product)
+		if err != nil {
+			results[i].Err = conflictError(fmt.Sprintf("document update conflict for product %s", product.ID), err)
+			continue
+		}
+		results[i].Rev = rev
+		product.Rev = rev
+		results[i].Product = &product
+		r.recordWrite(nil, &product)
+	}
+	return results, nil
+}
+
+// BulkUpdateProducts updates multiple products and reports the outcome of every item
+func (r *MemoryProductRepo) BulkUpdateProducts(ctx context.Context, products []entity.Product, opts BulkOptions) ([]BulkItemResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]BulkItemResult, len(products))
+	claimed := make(map[string]bool)
+	for i, product := range products {
+		results[i] = BulkItemResult{Index: i, ID: product.ID}
+		if product.ID == "" || product.Rev == "" {
+			results[i].Err = NewValidationError("product ID and _rev are required", nil)
+			continue
+		}
+
+		existing, ok := r.docs[product.ID]
+		if !ok || existing.Trashed() {
+			results[i].Err = notFoundError(product.ID, errNotFound())
+			continue
+		}
+		if existing.Rev != product.Rev {
+			results[i].Err = revisionConflictError(existing.Rev, product.Rev, nil)
+			continue
+		}
+		products[i].SchemaVersion = existing.SchemaVersion
+		products[i].DeletedAt, products[i].DeletedBy = nil, ""
+		stamp(ctx, &products[i])
+
+		normalized := normalizeName(product.Name)
+		if normalized != normalizeName(existing.Name) && (claimed[normalized] || r.nameExists(product.Name, product.ID)) {
+			results[i].Err = duplicateNameError(product.Name)
+			continue
+		}
+		claimed[normalized] = true
+	}
+
+	if opts.AllOrNothing && hasFailures(results) {
+		AbortPending(results)
+		return results, nil
+	}
+
+	for i, product := range products {
+		if results[i].Err != nil {
+			continue
+		}
+		before := r.docs[product.ID]
+		rev, err := r.put(product)
+		if err != nil {
+			results[i].Err = conflictError(fmt.Sprintf("document update conflict for product %s", product.ID), err)
+			continue
+		}
+		results[i].Rev = rev
+		r.recordWrite(&before, &product)
+	}
+	return results, nil
+}
+
+// CheckProductNameExists checks if a product with the given name already exists
+func (r *MemoryProductRepo) CheckProductNameExists(ctx context.Context, name string, excludeID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.nameExists(name, excludeID), nil
+}
+
+// nameExists reports whether another live product uses the given name after normalization,
+// mirroring the CouchDB name reservations. Callers must hold the lock.
+func (r *MemoryProductRepo) nameExists(name string, excludeID string) bool {
+	normalized := normalizeName(name)
+	for id, doc := range r.docs {
+		if normalizeName(doc.Name) == normalized && id != excludeID && !doc.Trashed() {
+			return true
+		}
+	}
+	return false
+}
+
+// put stores a document the way CouchDB's PUT does: a new document must not carry
+// a revision, an existing one must carry the current revision. Callers must hold the lock.
+func (r *MemoryProductRepo) put(product entity.Product) (string, error) {
+	previous := r.tombstones[product.ID]
+	if existing, ok := r.docs[product.ID]; ok {
+		if product.Rev != existing.Rev {
+			return "", errConflict()
+		}
+		previous = existing.Rev
+	} else if product.Rev != "" {
+		return "", errConflict()
+	}
+
+	product.Rev = nextRev(previous, product)
+	r.docs[product.ID] = product
+	delete(r.tombstones, product.ID)
+	r.recordChange(memoryChange{id: product.ID, rev: product.Rev, deleted: product.Trashed(), product: product})
+	return product.Rev, nil
+}
+
+// recordChange appends to the change feed and wakes up its followers. Callers must hold the lock.
+func (r *MemoryProductRepo) recordChange(change memoryChange) {
+	r.changes = append(r.changes, change)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// memoryViewRow is a row of an emulated view
+type memoryViewRow struct {
+	key     interface{}
+	product entity.Product
+}
+
+// viewRows emulates the by_name and by_price views, sorted by key then ID. Callers must hold the lock.
+func (r *MemoryProductRepo) viewRows(view listView) []memoryViewRow {
+	rows := make([]memoryViewRow, 0, len(r.docs))
+	for _, product := range r.docs {
+		if product.Trashed() {
+			continue
+		}
+		var key interface{} = product.Name
+		if view.name == "by_price" {
+			key = product.Price
+		}
+		rows = append(rows, memoryViewRow{key: key, product: product})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i].key, rows[i].product.ID, rows[j].key, rows[j].product.ID, view.descending) < 0
+	})
+	return rows
+}
+
+// compareRows orders view rows by key, then by document ID, optionally descending
+func compareRows(keyA interface{}, idA string, keyB interface{}, idB string, descending bool) int {
+	c := collate(keyA, keyB)
+	if c == 0 {
+		c = strings.Compare(idA, idB)
+	}
+	if descending {
+		return -c
+	}
+	return c
+}
+
+// collate compares two view keys following CouchDB's rule that numbers sort before strings
+func collate(a, b interface{}) int {
+	switch av := a.(type) {
+	case float64:
+		bv, ok := b.(float64)
+		if !ok {
+			return -1
+		}
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 1
+		}
+		return strings.Compare(av, bv)
+	}
+	return 0
+}
+
+// nextRev derives a CouchDB style "<generation>-<md5>" revision from the previous one
+func nextRev(previous string, product entity.Product) string {
+	product.Rev = previous
+	return nextDocRev(previous, product)
+}
+
+// nextDocRev builds a CouchDB style "N-hash" revision following previous from the document content
+func nextDocRev(previous string, doc interface{}) string {
+	generation := 0
+	if i := strings.IndexByte(previous, '-'); i > 0 {
+		generation, _ = strconv.Atoi(previous[:i])
+	}
+
+	body, _ := json.Marshal(doc)
+	sum := md5.Sum(body)
+	return fmt.Sprintf("%d-%s", generation+1, hex.EncodeToString(sum[:]))
+}
+
+// errNotFound returns the error CouchDB reports for a missing document
+func errNotFound() error {
+	return &kivik.Error{HTTPStatus: http.StatusNotFound, Message: "missing"}
+}
+
+// errConflict returns the error CouchDB reports for a stale or missing revision
+func errConflict() error {
+	return &kivik.Error{HTTPStatus: http.StatusConflict, Message: "Document update conflict."}
+}
+
+// memoryChangeFeed follows the change log of a MemoryProductRepo
+type memoryChangeFeed struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	repo   *MemoryProductRepo
+	seq    int
+	change ProductChange
+}
+
+// Next blocks until a change past the feed's position is recorded or the feed is closed
+func (f *memoryChangeFeed) Next() bool {
+	for {
+		f.repo.mu.RLock()
+		if f.seq < len(f.repo.changes) {
+			c := f.repo.changes[f.seq]
+			f.repo.mu.RUnlock()
+
+			f.seq++
+			f.change = ProductChange{
+				Seq:  strconv.Itoa(f.seq),
+				Type: changeType(c.rev, c.deleted),
+				ID:   c.id,
+				Rev:  c.rev,
+			}
+			if !c.deleted {
+				product := c.product
+				f.change.Product = &product
+			}
+			return true
+		}
+		changed := f.repo.changed
+		f.repo.mu.RUnlock()
+
+		select {
+		case <-changed:
+		case <-f.ctx.Done():
+			return false
+		}
+	}
+}
+
+// Change returns the current change
+func (f *memoryChangeFeed) Change() ProductChange {
+	return f.change
+}
+
+// Err always returns nil; the feed only ends when it is closed
+func (f *memoryChangeFeed) Err() error {
+	return nil
+}
+
+// Close ends the feed
+func (f *memoryChangeFeed) Close() error {
+	f.cancel()
+	return nil
+}
+
+// recordWrite keeps what CouchDB writes next to a product: its domain events and the
+// version it replaced. Callers must hold the lock.
+func (r *MemoryProductRepo) recordWrite(before *entity.Product, after *entity.Product) {
+	r.addEvents(productEvents(before, after))
+	if before != nil {
+		r.history[before.ID] = append(r.history[before.ID], *before)
+	}
+}
+
+// addEvents stores domain events in the outbox. Callers must hold the lock.
+func (r *MemoryProductRepo) addEvents(events []entity.DomainEvent) {
+	for _, event := range events {
+		event.Rev = nextDocRev("", event)
+		r.events[event.ID] = event
+	}
+}
+
+// PendingEvents returns up to limit undispatched events, oldest first
+func (r *MemoryProductRepo) PendingEvents(ctx context.Context, limit int) ([]entity.DomainEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []entity.DomainEvent{}
+	for _, event := range r.events {
+		if event.Status == entity.EventPending {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// MarkDispatched records that an event was handed to the publisher
+func (r *MemoryProductRepo) MarkDispatched(ctx context.Context, event entity.DomainEvent) error {
+	now := time.Now().UTC()
+	event.Status = entity.EventDispatched
+	event.Attempts++
+	event.LastError = ""
+	event.DispatchedAt = &now
+	return r.saveEvent(event)
+}
+
+// MarkFailed records a failed publish attempt; the event stays pending
+func (r *MemoryProductRepo) MarkFailed(ctx context.Context, event entity.DomainEvent, cause error) error {
+	event.Attempts++
+	event.LastError = cause.Error()
+	return r.saveEvent(event)
+}
+
+// saveEvent writes back an event read from the outbox, checking its revision
+func (r *MemoryProductRepo) saveEvent(event entity.DomainEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.events[event.ID]
+	if !ok || existing.Rev != event.Rev {
+		return conflictError(fmt.Sprintf("domain event %s was modified concurrently", event.ID), errConflict())
+	}
+	event.Rev = nextDocRev(existing.Rev, event)
+	r.events[event.ID] = event
+	return nil
+}
+
+// ListTrash retrieves one page of soft deleted products, most recently deleted first
+func (r *MemoryProductRepo) ListTrash(ctx context.Context, opts ListOptions) (*ProductPage, error) {
+	opts.Sort = SortByName
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, trashSort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows := r.trashRows()
+	start := 0
+	if cursor != nil {
+		var startKey interface{}
+		if err := json.Unmarshal(cursor.Key, &startKey); err != nil {
+			return nil, NewValidationError("invalid cursor", nil)
+		}
+		start = sort.Search(len(rows), func(i int) bool {
+			return compareRows(rows[i].key, rows[i].product.ID, startKey, cursor.ID, true) >= 0
+		})
+	}
+
+	page := &ProductPage{Products: []entity.Product{}, Limit: opts.Limit, TotalRows: int64(len(rows))}
+	for i := start; i < len(rows); i++ {
+		if len(page.Products) == opts.Limit {
+			key, _ := json.Marshal(rows[i].key)
+			page.NextCursor = encodeCursor(trashSort, key, rows[i].product.ID)
+			break
+		}
+		page.Products = append(page.Products, rows[i].product)
+	}
+	return page, nil
+}
+
+// GetTrashedProductById retrieves a product in the trash by its ID
+func (r *MemoryProductRepo) GetTrashedProductById(ctx context.Context, id string) (*entity.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	product, ok := r.docs[id]
+	if !ok || !product.Trashed() {
+		return nil, resourceNotFoundError("trashed product", id, errNotFound())
+	}
+	return &product, nil
+}
+
+// RestoreProductById takes a product out of the trash by its ID and revision
+func (r *MemoryProductRepo) RestoreProductById(ctx context.Context, id string, rev string) (*entity.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.docs[id]
+	if !ok || !existing.Trashed() {
+		return nil, resourceNotFoundError("trashed product", id, errNotFound())
+	}
+	if existing.Rev != rev {
+		return nil, revisionConflictError(existing.Rev, rev, nil)
+	}
+	if r.nameExists(existing.Name, id) {
+		return nil, duplicateNameError(existing.Name)
+	}
+
+	restored := existing
+	restored.DeletedAt, restored.DeletedBy = nil, ""
+	stamp(ctx, &restored)
+	newRev, err := r.put(restored)
+	if err != nil {
+		return nil, revisionConflictError(existing.Rev, rev, err)
+	}
+	restored.Rev = newRev
+	r.recordWrite(&existing, &restored)
+	return &restored, nil
+}
+
+// PurgeTrash removes the products that were moved to the trash before opts.Before.
+// As with CouchDB, plain deletes keep the revision history and purges drop it; neither
+// shows up in the change feed.
+func (r *MemoryProductRepo) PurgeTrash(ctx context.Context, opts PurgeOptions) (*PurgeReport, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &PurgeReport{Purged: []string{}, DryRun: opts.DryRun}
+	cutoff := trashKey(opts.Before)
+	for _, row := range r.trashRows() {
+		if row.key.(string) >= cutoff {
+			continue
+		}
+		report.Purged = append(report.Purged, row.product.ID)
+		if opts.DryRun {
+			continue
+		}
+		delete(r.docs, row.product.ID)
+		delete(r.history, row.product.ID)
+		delete(r.conflicts, row.product.ID)
+		if !opts.Hard {
+			r.tombstones[row.product.ID] = nextRev(row.product.Rev, row.product)
+		}
+	}
+	sort.Strings(report.Purged)
+	return report, nil
+}
+
+// trashRows emulates the trash view, newest deletion first. Callers must hold the lock.
+func (r *MemoryProductRepo) trashRows() []memoryViewRow {
+	var rows []memoryViewRow
+	for _, product := range r.docs {
+		if product.Trashed() {
+			rows = append(rows, memoryViewRow{key: trashKey(*product.DeletedAt), product: product})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i].key, rows[i].product.ID, rows[j].key, rows[j].product.ID, true) < 0
+	})
+	return rows
+}
+
+// GetProductHistory returns the versions of a product, newest first, starting with the stored one
+func (r *MemoryProductRepo) GetProductHistory(ctx context.Context, id string, opts ListOptions) (*HistoryPage, error) {
+	opts.Sort = SortByName
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, historySort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	current, ok := r.docs[id]
+	if !ok {
+		return nil, notFoundError(id, errNotFound())
+	}
+
+	page := &HistoryPage{Versions: []entity.ProductVersion{}, Limit: opts.Limit}
+	if cursor == nil {
+		page.Versions = append(page.Versions, entity.ProductVersion{Rev: current.Rev, Current: true, Product: current})
+	}
+	versions := r.history[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		key := historyID(id, versions[i].Rev)
+		if cursor != nil && key > cursor.ID {
+			continue
+		}
+		if len(page.Versions) == opts.Limit {
+			raw, _ := json.Marshal(key)
+			page.NextCursor = encodeCursor(historySort, raw, key)
+			break
+		}
+		page.Versions = append(page.Versions, entity.ProductVersion{Rev: versions[i].Rev, Product: versions[i]})
+	}
+	return page, nil
+}
+
+// GetProductVersion returns a product as it was at the given revision
+func (r *MemoryProductRepo) GetProductVersion(ctx context.Context, id string, rev string) (*entity.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	current, ok := r.docs[id]
+	if !ok {
+		return nil, notFoundError(id, errNotFound())
+	}
+	if current.Rev == rev {
+		return &current, nil
+	}
+	for _, version := range r.history[id] {
+		if version.Rev == rev {
+			return &version, nil
+		}
+	}
+	return nil, resourceNotFoundError("version of product "+id, rev, errNotFound())
+}
+
+// AddConflict simulates replication bringing in a revision of a product that branched
+// off revision base, as a concurrent write to another database would. Unlike CouchDB,
+// which picks the winner by revision, the stored revision always stays the winner.
+// It returns the conflicting revision.
+func (r *MemoryProductRepo) AddConflict(id string, base string, product entity.Product) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.docs[id]
+	if !ok {
+		return "", notFoundError(id, errNotFound())
+	}
+
+	product.ID = id
+	product.Conflicts = nil
+	product.Rev = nextRev(base, product)
+	if product.Rev == current.Rev {
+		return "", conflictError(fmt.Sprintf("revision %s is the current revision of product %s", product.Rev, id), errConflict())
+	}
+	r.conflicts[id] = append(r.conflicts[id], memoryConflict{product: product, base: base})
+	r.recordChange(memoryChange{id: id, rev: current.Rev, deleted: current.Trashed(), product: current})
+	return product.Rev, nil
+}
+
+// ListConflicts retrieves one page of products that have conflicting revisions,
+// ordered by ID. Each product lists its losing revisions in _conflicts. opts.Sort is ignored.
+func (r *MemoryProductRepo) ListConflicts(ctx context.Context, opts ListOptions) (*ProductPage, error) {
+	opts.Sort = SortByName
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, conflictSort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ids []string
+	for id := range r.conflicts {
+		if product, ok := r.docs[id]; ok && !product.Trashed() && len(r.conflicts[id]) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	page := &ProductPage{Products: []entity.Product{}, Limit: opts.Limit, TotalRows: int64(len(ids))}
+	for _, id := range ids {
+		if cursor != nil && id < cursor.ID {
+			continue
+		}
+		if len(page.Products) == opts.Limit {
+			key, _ := json.Marshal(id)
+			page.NextCursor = encodeCursor(conflictSort, key, id)
+			break
+		}
+		page.Products = append(page.Products, r.withConflicts(r.docs[id]))
+	}
+	return page, nil
+}
+
+// GetProductWithConflicts retrieves a product by its ID like GetProductById, listing
+// the revisions that conflict with it in _conflicts
+func (r *MemoryProductRepo) GetProductWithConflicts(ctx context.Context, id string) (*entity.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	product, ok := r.docs[id]
+	if !ok || product.Trashed() {
+		return nil, notFoundError(id, errNotFound())
+	}
+	product = r.withConflicts(product)
+	return &product, nil
+}
+
+// GetProductConflicts retrieves a product together with its conflicting revisions and,
+// if they all branched off the same known version, that version
+func (r *MemoryProductRepo) GetProductConflicts(ctx context.Context, id string) (*ConflictSet, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	current, ok := r.docs[id]
+	if !ok || current.Trashed() {
+		return nil, notFoundError(id, errNotFound())
+	}
+
+	set := &ConflictSet{Current: current, Conflicts: []entity.Product{}}
+	base := ""
+	for i, conflict := range r.conflicts[id] {
+		set.Conflicts = append(set.Conflicts, conflict.product)
+		if i > 0 && conflict.base != base {
+			base = ""
+			break
+		}
+		base = conflict.base
+	}
+	for _, version := range r.history[id] {
+		if base != "" && version.Rev == base {
+			version := version
+			set.Base = &version
+		}
+	}
+	return set, nil
+}
+
+// DiscardConflicts deletes the losing revisions of a product, so the current revision
+// is left as its only version. Each losing revision is kept in the product history.
+func (r *MemoryProductRepo) DiscardConflicts(ctx context.Context, id string, losing []entity.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, loser := range losing {
+		conflicts := r.conflicts[id]
+		found := false
+		for i := range conflicts {
+			if conflicts[i].product.Rev == loser.Rev {
+				r.history[id] = append(r.history[id], conflicts[i].product)
+				r.conflicts[id] = append(conflicts[:i:i], conflicts[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return conflictError(fmt.Sprintf("a conflicting revision of product %s changed while it was resolved", id), errConflict())
+		}
+	}
+	if len(r.conflicts[id]) == 0 {
+		delete(r.conflicts, id)
+	}
+	if current, ok := r.docs[id]; ok {
+		r.recordChange(memoryChange{id: id, rev: current.Rev, deleted: current.Trashed(), product: current})
+	}
+	return nil
+}
+
+// withConflicts returns a copy of a product listing its conflicting revisions. Callers must hold the lock.
+func (r *MemoryProductRepo) withConflicts(product entity.Product) entity.Product {
+	product.Conflicts = nil
+	for _, conflict := range r.conflicts[product.ID] {
+		product.Conflicts = append(product.Conflicts, conflict.product.Rev)
+	}
+	return product
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+func TestMemoryProductRepoConcurrentCreatesWithSameName(t *testing.T) {
+	repo := NewMemoryProductRepo()
+	ctx := context.Background()
+
+	const creators = 20
+	var wg sync.WaitGroup
+	errs := make([]error, creators)
+	for i := 0; i < creators; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Names differing only in case and spacing collide
+			name := "Laptop Pro"
+			if i%2 == 1 {
+				name = "laptop  pro"
+			}
+			_, errs[i] = repo.CreateProduct(ctx, entity.Product{ID: fmt.Sprintf("p%d", i), Name: name, Price: 1})
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrDuplicateName):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d concurrent creates of the same name succeeded, want 1", created)
+	}
+}
+
+func TestMemoryProductRepoBulkCreateRejectsDuplicateNames(t *testing.T) {
+	repo := NewMemoryProductRepo()
+	ctx := context.Background()
+
+	results, err := repo.BulkCreateProducts(ctx, []entity.Product{
+		{Name: "Desk", Price: 1},
+		{Name: "desk", Price: 2},
+	}, BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || !errors.Is(results[1].Err, ErrDuplicateName) {
+		t.Fatalf("got errors %v and %v, want the second create rejected as a duplicate", results[0].Err, results[1].Err)
+	}
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/google/uuid"
+)
+
+// MemoryWebhookRepo is an in-memory WebhookRepository with CouchDB-like revision checks.
+// It is intended for tests and local development without a CouchDB container.
+type MemoryWebhookRepo struct {
+	mu          sync.RWMutex
+	webhooks    map[string]entity.Webhook
+	deliveries  map[string]entity.WebhookDelivery
+	checkpoints map[string]string
+}
+
+// NewMemoryWebhookRepo creates an empty in-memory webhook repository
+func NewMemoryWebhookRepo() *MemoryWebhookRepo {
+	return &MemoryWebhookRepo{
+		webhooks:    make(map[string]entity.Webhook),
+		deliveries:  make(map[string]entity.WebhookDelivery),
+		checkpoints: make(map[string]string),
+	}
+}
+
+// CreateWebhook stores a new webhook and returns it with its ID and revision
+func (r *MemoryWebhookRepo) CreateWebhook(ctx context.Context, webhook entity.Webhook) (*entity.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if webhook.ID == "" {
+		webhook.ID = uuid.New().String()
+	}
+	if _, ok := r.webhooks[webhook.ID]; ok {
+		return nil, conflictError(fmt.Sprintf("webhook with ID %s already exists", webhook.ID), errConflict())
+	}
+
+	webhook.Rev = nextDocRev("", webhook)
+	r.webhooks[webhook.ID] = webhook
+	return &webhook, nil
+}
+
+// ListWebhooks returns all webhooks ordered by ID
+func (r *MemoryWebhookRepo) ListWebhooks(ctx context.Context) ([]entity.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhooks := make([]entity.Webhook, 0, len(r.webhooks))
+	for _, webhook := range r.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+// GetWebhookById retrieves a webhook by its ID
+func (r *MemoryWebhookRepo) GetWebhookById(ctx context.Context, id string) (*entity.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhook, ok := r.webhooks[id]
+	if !ok {
+		return nil, resourceNotFoundError("webhook", id, errNotFound())
+	}
+	return &webhook, nil
+}
+
+// UpdateWebhookById replaces a webhook. webhook.Rev must be the current revision.
+func (r *MemoryWebhookRepo) UpdateWebhookById(ctx context.Context, id string, webhook entity.Webhook) (*entity.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.webhooks[id]
+	if !ok {
+		return nil, resourceNotFoundError("webhook", id, errNotFound())
+	}
+	if existing.Rev != webhook.Rev {
+		return nil, conflictError(fmt.Sprintf("revision %s is not the current revision of webhook %s", webhook.Rev, id), errConflict())
+	}
+
+	webhook.ID = id
+	webhook.Rev = nextDocRev(existing.Rev, webhook)
+	r.webhooks[id] = webhook
+	return &webhook, nil
+}
+
+// DeleteWebhookById deletes a webhook. Its deliveries are kept for the delivery log.
+func (r *MemoryWebhookRepo) DeleteWebhookById(ctx context.Context, id string, rev string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.webhooks[id]
+	if !ok {
+		return resourceNotFoundError("webhook", id, errNotFound())
+	}
+	if existing.Rev != rev {
+		return conflictError(fmt.Sprintf("revision %s is not the current revision of webhook %s", rev, id), errConflict())
+	}
+	delete(r.webhooks, id)
+	return nil
+}
+
+// SaveDelivery creates or updates a delivery and returns it with its new revision
+func (r *MemoryWebhookRepo) SaveDelivery(ctx context.Context, delivery entity.WebhookDelivery) (*entity.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+	existing, ok := r.deliveries[delivery.ID]
+	if (ok && existing.Rev != delivery.Rev) || (!ok && delivery.Rev != "") {
+		return nil, fmt.Errorf("failed to save webhook delivery: %w", errConflict())
+	}
+
+	delivery.Rev = nextDocRev(existing.Rev, delivery)
+	r.deliveries[delivery.ID] = delivery
+	return &delivery, nil
+}
+
+// ListDeliveries returns the most recent deliveries of a webhook, newest first,
+// optionally restricted to one status
+func (r *MemoryWebhookRepo) ListDeliveries(ctx context.Context, webhookID string, status string, limit int) ([]entity.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := []entity.WebhookDelivery{}
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID == webhookID && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// ListPendingDeliveries returns all pending deliveries, the ones due first
+func (r *MemoryWebhookRepo) ListPendingDeliveries(ctx context.Context) ([]entity.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := []entity.WebhookDelivery{}
+	for _, delivery := range r.deliveries {
+		if delivery.Status == entity.DeliveryPending {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt) })
+	return deliveries, nil
+}
+
+// GetCheckpoint returns the change feed position saved under name, or an empty string if there is none
+func (r *MemoryWebhookRepo) GetCheckpoint(ctx context.Context, name string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.checkpoints[name], nil
+}
+
+// SaveCheckpoint saves a change feed position under name
+func (r *MemoryWebhookRepo) SaveCheckpoint(ctx context.Context, name string, seq string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpoints[name] = seq
+	return nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// nameReservationPrefix is the ID prefix of name reservation documents
+const nameReservationPrefix = "name:"
+
+// reservationGracePeriod is how long a reservation whose product does not exist is
+// honoured. The product is written right after its name is reserved, so until then a
+// missing holder is a create in flight rather than a failed one.
+const reservationGracePeriod = time.Minute
+
+// nameReservation claims a product name. Its ID is derived from the normalized
+// name, so CouchDB's own conflict detection rejects a second claim on the same name.
+// It deliberately has no "name" or "price" field, keeping it out of the product views and indexes.
+type nameReservation struct {
+	ID        string     `json:"_id"`
+	Rev       string     `json:"_rev,omitempty"`
+	Type      string     `json:"type"`
+	ProductID string     `json:"product_id"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// holderState describes the product holding a name reservation
+type holderState int
+
+const (
+	holderLive holderState = iota
+	holderTrashed
+	holderMissing
+)
+
+// NameRepairReport describes the outcome of RepairNameReservations
+type NameRepairReport struct {
+	Duplicates          map[string][]string `json:"duplicates"`
+	CreatedReservations []string            `json:"created_reservations"`
+	RemovedReservations []string            `json:"removed_reservations"`
+}
+
+// normalizeName folds case and whitespace so that "Laptop  Pro" and "laptop pro" collide
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// reservationID returns the document ID of the reservation for a product name
+func reservationID(name string) string {
+	return nameReservationPrefix + normalizeName(name)
+}
+
+// newReservation returns a reservation of a name for a product, made now
+func newReservation(normalized string, productID string) nameReservation {
+	now := time.Now().UTC()
+	return nameReservation{ID: nameReservationPrefix + normalized, Type: "name_reservation", ProductID: productID, CreatedAt: &now}
+}
+
+// reserveName claims a name for a product. It succeeds if the name is free, already
+// held by the same product, or held by a stale reservation, see reservationStale.
+// acquired reports whether this call wrote the reservation; only then may a failed
+// write release it again, as a reservation the product already held belongs to a
+// product that exists.
+func reserveName(ctx context.Context, db *kivik.DB, name string, productID string) (acquired bool, err error) {
+	id := reservationID(name)
+	reservation := newReservation(normalizeName(name), productID)
+
+	_, err = db.Put(ctx, id, reservation)
+	if err == nil {
+		return true, nil
+	}
+	if kivik.StatusCode(err) != 409 {
+		log.Println("Failed to reserve product name:", err)
+		return false, fmt.Errorf("failed to reserve product name: %w", err)
+	}
+
+	// The name is taken; find out whether the holder is still alive
+	var existing nameReservation
+	if err := db.Get(ctx, id).ScanDoc(&existing); err != nil {
+		if kivik.StatusCode(err) == 404 { // Released in the meantime
+			return reserveName(ctx, db, name, productID)
+		}
+		return false, fmt.Errorf("failed to read name reservation: %w", err)
+	}
+	if existing.ProductID == productID {
+		return false, nil
+	}
+
+	holder, err := reservationHolder(ctx, db, existing.ProductID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check name reservation holder: %w", err)
+	}
+	if !reservationStale(existing, holder, time.Now()) {
+		return false, duplicateNameError(name)
+	}
+
+	// Stale reservation left behind by a failed write or a product moved to the trash
+	// without releasing it; take it over. The revision
+	// check makes this atomic against a concurrent takeover.
+	reservation.Rev = existing.Rev
+	if _, err := db.Put(ctx, id, reservation); err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return false, duplicateNameError(name)
+		}
+		return false, fmt.Errorf("failed to reserve product name: %w", err)
+	}
+	return true, nil
+}
+
+// releaseName removes a product's claim on a name. It never removes a reservation
+// held by another product. Failures are logged only: a leftover reservation is
+// reclaimed by reserveName once its product is gone.
+func releaseName(ctx context.Context, db *kivik.DB, name string, productID string) {
+	id := reservationID(name)
+
+	var existing nameReservation
+	if err := db.Get(ctx, id).ScanDoc(&existing); err != nil {
+		if kivik.StatusCode(err) != 404 {
+			log.Println("Failed to read name reservation:", err)
+		}
+		return
+	}
+	if existing.ProductID != productID {
+		return
+	}
+	if _, err := db.Delete(ctx, id, existing.Rev); err != nil && kivik.StatusCode(err) != 404 {
+		log.Println("Failed to release name reservation:", err)
+	}
+}
+
+// RepairNameReservations scans all products for names that collide after normalization,
+// creates reservations for names that lack one and removes reservations whose product
+// no longer holds the name. Duplicates are only reported; resolving them requires
+// renaming or deleting products. With dryRun set nothing is written.
+func (r *ProductRepo) RepairNameReservations(ctx context.Context, dryRun bool) (*NameRepairReport, error) {
+	db := r.db
+
+	// Group product IDs by normalized name
+	rows, err := db.Query(ctx, "_design/products", "_view/by_name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query products by name: %w", err)
+	}
+	defer rows.Close()
+
+	owners := make(map[string][]string)
+	for rows.Next() {
+		var name string
+		if err := rows.ScanKey(&name); err != nil {
+			log.Println("Failed to scan row:", err)
+			continue
+		}
+		normalized := normalizeName(name)
+		owners[normalized] = append(owners[normalized], rows.ID())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query products by name: %w", err)
+	}
+
+	// Load the existing reservations
+	resRows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"startkey":     nameReservationPrefix,
+		"endkey":       nameReservationPrefix + "\ufff0",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list name reservations: %w", err)
+	}
+	defer resRows.Close()
+
+	reservations := make(map[string]nameReservation)
+	for resRows.Next() {
+		var reservation nameReservation
+		if err := resRows.ScanDoc(&reservation); err != nil {
+			log.Println("Failed to scan name reservation:", err)
+			continue
+		}
+		reservations[strings.TrimPrefix(reservation.ID, nameReservationPrefix)] = reservation
+	}
+	if err := resRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list name reservations: %w", err)
+	}
+
+	report := &NameRepairReport{
+		Duplicates:          make(map[string][]string),
+		CreatedReservations: []string{},
+		RemovedReservations: []string{},
+	}
+	for normalized, ids := range owners {
+		sort.Strings(ids)
+		if len(ids) > 1 {
+			report.Duplicates[normalized] = ids
+		}
+		// Duplicated names are reserved for their first product, so at least no new copies appear
+		if _, ok := reservations[normalized]; ok {
+			continue
+		}
+		report.CreatedReservations = append(report.CreatedReservations, normalized)
+		if dryRun {
+			continue
+		}
+		reservation := newReservation(normalized, ids[0])
+		if _, err := db.Put(ctx, reservation.ID, reservation); err != nil {
+			return report, fmt.Errorf("failed to create reservation for '%s': %w", normalized, err)
+		}
+	}
+
+	for normalized, reservation := range reservations {
+		if containsString(owners[normalized], reservation.ProductID) {
+			continue
+		}
+		report.RemovedReservations = append(report.RemovedReservations, normalized)
+		if dryRun {
+			continue
+		}
+		if _, err := db.Delete(ctx, reservation.ID, reservation.Rev); err != nil && kivik.StatusCode(err) != 404 {
+			return report, fmt.Errorf("failed to remove reservation for '%s': %w", normalized, err)
+		}
+	}
+
+	sort.Strings(report.CreatedReservations)
+	sort.Strings(report.RemovedReservations)
+	return report, nil
+}
+
+// reservationHolder reports whether the product holding a reservation is live, in the
+// trash, or missing: deleted, or not written yet
+func reservationHolder(ctx context.Context, db *kivik.DB, id string) (holderState, error) {
+	var doc struct {
+		DeletedAt interface{} `json:"deleted_at"`
+	}
+	if err := db.Get(ctx, id).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return holderMissing, nil
+		}
+		return holderLive, err
+	}
+	if doc.DeletedAt != nil {
+		return holderTrashed, nil
+	}
+	return holderLive, nil
+}
+
+// reservationStale reports whether a reservation of another product may be taken over:
+// its product is in the trash, or is missing and the reservation is older than
+// reservationGracePeriod. A missing product with a recent reservation is a concurrent
+// create that has not written its product yet. Reservations made before creation times
+// were recorded count as old.
+func reservationStale(reservation nameReservation, holder holderState, now time.Time) bool {
+	switch holder {
+	case holderTrashed:
+		return true
+	case holderMissing:
+		return reservation.CreatedAt == nil || now.Sub(*reservation.CreatedAt) >= reservationGracePeriod
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
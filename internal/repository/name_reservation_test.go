@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+func TestReservationStale(t *testing.T) {
+	now := time.Now().UTC()
+	fresh := now.Add(-time.Second)
+	old := now.Add(-2 * reservationGracePeriod)
+
+	tests := []struct {
+		name      string
+		createdAt *time.Time
+		holder    holderState
+		want      bool
+	}{
+		{"live holder", &old, holderLive, false},
+		{"trashed holder", &fresh, holderTrashed, true},
+		{"create in flight", &fresh, holderMissing, false},
+		{"failed create", &old, holderMissing, true},
+		{"reservation without creation time", nil, holderMissing, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reservation := nameReservation{ProductID: "p1", CreatedAt: tt.createdAt}
+			if got := reservationStale(reservation, tt.holder, now); got != tt.want {
+				t.Errorf("reservationStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedCreateKeepsReservationOfExistingProduct(t *testing.T) {
+	repo := NewProductRepo(newFakeCouchDB(t))
+	ctx := context.Background()
+
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999}); err != nil {
+		t.Fatal(err)
+	}
+	// Creating the same ID and name again finds the reservation already held by the ID
+	// and fails on the product write
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 1}); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("got error %v creating an existing ID, want ErrRevisionConflict", err)
+	}
+	results, err := repo.BulkCreateProducts(ctx, []entity.Product{{ID: "laptop", Name: "Laptop", Price: 1}}, BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, ErrRevisionConflict) {
+		t.Fatalf("got error %v bulk creating an existing ID, want ErrRevisionConflict", results[0].Err)
+	}
+
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "other", Name: "laptop", Price: 1}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("got error %v taking the name of the existing product, want ErrDuplicateName", err)
+	}
+}
+
+func TestFailedCreateReleasesItsOwnReservation(t *testing.T) {
+	db := newFakeCouchDB(t)
+	repo := NewProductRepo(db)
+	ctx := context.Background()
+
+	// A document in the way of the product makes the write fail after the name is reserved
+	if _, err := db.Put(ctx, "laptop", map[string]interface{}{"type": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999}); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("got error %v, want ErrRevisionConflict", err)
+	}
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "other", Name: "Laptop", Price: 999}); err != nil {
+		t.Errorf("name still reserved after the failed create: %v", err)
+	}
+}
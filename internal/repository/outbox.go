@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+	"github.com/google/uuid"
+)
+
+// eventIDPrefix is the ID prefix of domain event documents. The timestamp that follows
+// makes IDs sort in the order the events occurred.
+const eventIDPrefix = "event:"
+
+// eventDocType tags domain event documents. Events have no top-level "name" or "price"
+// field, which keeps them out of the product views and indexes.
+const eventDocType = "domain_event"
+
+// OutboxRepository reads and settles the domain events written together with products
+type OutboxRepository interface {
+	PendingEvents(ctx context.Context, limit int) ([]entity.DomainEvent, error)
+	MarkDispatched(ctx context.Context, event entity.DomainEvent) error
+	MarkFailed(ctx context.Context, event entity.DomainEvent, cause error) error
+}
+
+// Ensure both backends satisfy the interface
+var (
+	_ OutboxRepository = (*ProductRepo)(nil)
+	_ OutboxRepository = (*MemoryProductRepo)(nil)
+)
+
+// eventDoc is a domain event as stored in CouchDB, tagged with its document type
+type eventDoc struct {
+	entity.DomainEvent
+	Type string `json:"type"`
+}
+
+// productEvents derives the domain events of a product write. before is nil for
+// creates and after is nil for deletes, including moves to the trash.
+func productEvents(before *entity.Product, after *entity.Product) []entity.DomainEvent {
+	var events []entity.DomainEvent
+	switch {
+	case before == nil:
+		events = append(events, newEvent(entity.EventNameProductCreated, after.ID, map[string]interface{}{
+			"product": after,
+		}))
+	case after == nil:
+		events = append(events, newEvent(entity.EventNameProductDeleted, before.ID, map[string]interface{}{
+			"product": before,
+		}))
+	case before.Trashed() && !after.Trashed():
+		events = append(events, newEvent(entity.EventNameProductRestored, after.ID, map[string]interface{}{
+			"product": after,
+		}))
+	default:
+		if after.Name != before.Name {
+			events = append(events, newEvent(entity.EventNameProductRenamed, after.ID, map[string]interface{}{
+				"old_name": before.Name,
+				"new_name": after.Name,
+			}))
+		}
+		if after.Price != before.Price {
+			events = append(events, newEvent(entity.EventNameProductPriceChanged, after.ID, map[string]interface{}{
+				"old_price": before.Price,
+				"new_price": after.Price,
+			}))
+		}
+	}
+	return events
+}
+
+// newEvent creates a pending domain event with a time ordered ID
+func newEvent(name string, aggregateID string, payload map[string]interface{}) entity.DomainEvent {
+	now := time.Now().UTC()
+	raw, _ := json.Marshal(payload)
+	return entity.DomainEvent{
+		ID:          fmt.Sprintf("%s%020d-%s", eventIDPrefix, now.UnixNano(), uuid.New().String()[:8]),
+		Event:       name,
+		AggregateID: aggregateID,
+		Payload:     raw,
+		Status:      entity.EventPending,
+		OccurredAt:  now,
+	}
+}
+
+// eventDocs tags events for storage
+func eventDocs(events []entity.DomainEvent) []interface{} {
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		docs[i] = eventDoc{DomainEvent: event, Type: eventDocType}
+	}
+	return docs
+}
+
+// PendingEvents returns up to limit undispatched events, oldest first
+func (r *ProductRepo) PendingEvents(ctx context.Context, limit int) ([]entity.DomainEvent, error) {
+	rows, err := r.db.Query(ctx, "_design/outbox", "_view/pending", kivik.Options{
+		"include_docs": true,
+		"limit":        limit,
+	})
+	if err != nil {
+		log.Println("Failed to query pending domain events:", err)
+		return nil, fmt.Errorf("failed to query pending domain events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []entity.DomainEvent{}
+	for rows.Next() {
+		var event entity.DomainEvent
+		if err := rows.ScanDoc(&event); err != nil {
+			log.Println("Failed to scan domain event:", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query pending domain events: %w", err)
+	}
+	return events, nil
+}
+
+// MarkDispatched records that an event was handed to the publisher
+func (r *ProductRepo) MarkDispatched(ctx context.Context, event entity.DomainEvent) error {
+	now := time.Now().UTC()
+	event.Status = entity.EventDispatched
+	event.Attempts++
+	event.LastError = ""
+	event.DispatchedAt = &now
+	return r.saveEvent(ctx, event)
+}
+
+// MarkFailed records a failed publish attempt; the event stays pending
+func (r *ProductRepo) MarkFailed(ctx context.Context, event entity.DomainEvent, cause error) error {
+	event.Attempts++
+	event.LastError = cause.Error()
+	return r.saveEvent(ctx, event)
+}
+
+// saveEvent writes back an event read from the outbox
+func (r *ProductRepo) saveEvent(ctx context.Context, event entity.DomainEvent) error {
+	if _, err := r.db.Put(ctx, event.ID, eventDoc{DomainEvent: event, Type: eventDocType}); err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return conflictError(fmt.Sprintf("domain event %s was modified concurrently", event.ID), err)
+		}
+		return fmt.Errorf("failed to update domain event %s: %w", event.ID, err)
+	}
+	return nil
+}
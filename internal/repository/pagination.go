@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// Sort orders supported when listing products
+const (
+	SortByName      = "name"
+	SortByPrice     = "price"
+	SortByPriceDesc = "-price"
+)
+
+// Page size limits for product listings
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// ListOptions controls pagination and ordering of product listings
+type ListOptions struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+// ProductPage is one page of a product listing
+type ProductPage struct {
+	Products   []entity.Product `json:"products"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	TotalRows  int64            `json:"total_rows"`
+	Limit      int              `json:"limit"`
+}
+
+// listView describes the CouchDB view backing a sort order
+type listView struct {
+	name       string
+	descending bool
+}
+
+// listViews maps each supported sort order to its view in _design/products
+var listViews = map[string]listView{
+	SortByName:      {name: "by_name"},
+	SortByPrice:     {name: "by_price"},
+	SortByPriceDesc: {name: "by_price", descending: true},
+}
+
+// pageCursor is the decoded form of the opaque cursor handed to clients.
+// It holds the view key and document ID of the first row of the next page.
+type pageCursor struct {
+	Sort string          `json:"s"`
+	Key  json.RawMessage `json:"k"`
+	ID   string          `json:"id"`
+}
+
+// normalize applies defaults and validates the options
+func (o *ListOptions) normalize() error {
+	if o.Sort == "" {
+		o.Sort = SortByName
+	}
+	if _, ok := listViews[o.Sort]; !ok {
+		return NewValidationError(fmt.Sprintf("unsupported sort '%s'", o.Sort), nil)
+	}
+	if o.Limit == 0 {
+		o.Limit = DefaultPageLimit
+	}
+	if o.Limit < 0 || o.Limit > MaxPageLimit {
+		return NewValidationError(fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit), nil)
+	}
+	return nil
+}
+
+// decodeCursor parses an opaque cursor issued for the given sort order, returning nil for an empty cursor
+func decodeCursor(token string, sort string) (*pageCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, NewValidationError("invalid cursor", nil)
+	}
+
+	var c pageCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == "" || len(c.Key) == 0 {
+		return nil, NewValidationError("invalid cursor", nil)
+	}
+	if c.Sort != sort {
+		return nil, NewValidationError("cursor was issued for a different sort order", nil)
+	}
+	return &c, nil
+}
+
+// encodeCursor builds the opaque cursor pointing at the given view row
+func encodeCursor(sort string, key json.RawMessage, id string) string {
+	raw, _ := json.Marshal(pageCursor{Sort: sort, Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
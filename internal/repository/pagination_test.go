@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// pagedRepo returns a memory repository holding n products, priced in pairs so that
+// listings by price have to break ties on the document ID
+func pagedRepo(t *testing.T, n int) *MemoryProductRepo {
+	t.Helper()
+	repo := NewMemoryProductRepo()
+	for i := 0; i < n; i++ {
+		product := entity.Product{ID: fmt.Sprintf("p%02d", i), Name: fmt.Sprintf("Product %02d", n-i), Price: float64(i / 2)}
+		if _, err := repo.CreateProduct(context.Background(), product); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestGetAllProductsCursorRoundTrip(t *testing.T) {
+	repo := pagedRepo(t, 11)
+	ctx := context.Background()
+
+	for _, sort := range []string{SortByName, SortByPrice, SortByPriceDesc} {
+		t.Run(sort, func(t *testing.T) {
+			all, err := repo.GetAllProducts(ctx, ListOptions{Sort: sort, Limit: MaxPageLimit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all.Products) != 11 || all.NextCursor != "" {
+				t.Fatalf("got %d products and cursor %q in one page, want 11 and none", len(all.Products), all.NextCursor)
+			}
+
+			opts := ListOptions{Sort: sort, Limit: 4}
+			var ids []string
+			var sizes []int
+			for pages := 0; ; pages++ {
+				if pages == 5 {
+					t.Fatal("paging does not end")
+				}
+				page, err := repo.GetAllProducts(ctx, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.TotalRows != 11 || page.Limit != 4 {
+					t.Errorf("got total %d and limit %d, want 11 and 4", page.TotalRows, page.Limit)
+				}
+				sizes = append(sizes, len(page.Products))
+				for _, product := range page.Products {
+					ids = append(ids, product.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+
+			if fmt.Sprint(sizes) != "[4 4 3]" {
+				t.Errorf("got page sizes %v, want [4 4 3]", sizes)
+			}
+			// Pages put together are the single page listing: no duplicates, no gaps, same order
+			for i, product := range all.Products {
+				if i >= len(ids) || ids[i] != product.ID {
+					t.Fatalf("got pages %v, want the order of %v", ids, all.Products)
+				}
+			}
+		})
+	}
+}
+
+func TestGetAllProductsLastPage(t *testing.T) {
+	repo := pagedRepo(t, 4)
+	ctx := context.Background()
+
+	// A page ending exactly on the last product has no next cursor
+	page, err := repo.GetAllProducts(ctx, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = repo.GetAllProducts(ctx, ListOptions{Limit: 2, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Products) != 2 || page.NextCursor != "" {
+		t.Errorf("got %d products and cursor %q, want 2 and none", len(page.Products), page.NextCursor)
+	}
+
+	page, err = NewMemoryProductRepo().GetAllProducts(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Products == nil || len(page.Products) != 0 || page.NextCursor != "" {
+		t.Errorf("got %+v for an empty repository, want an empty page", page)
+	}
+}
+
+func TestGetAllProductsRejectsForeignCursors(t *testing.T) {
+	repo := pagedRepo(t, 5)
+	ctx := context.Background()
+
+	page, err := repo.GetAllProducts(ctx, ListOptions{Sort: SortByPrice, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sort := range []string{SortByName, SortByPriceDesc, ""} {
+		_, err := repo.GetAllProducts(ctx, ListOptions{Sort: sort, Limit: 2, Cursor: page.NextCursor})
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf("sort %q got error %v for a price cursor, want ErrValidation", sort, err)
+		}
+	}
+
+	noKey := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"name","id":"p00"}`))
+	for _, cursor := range []string{"not base64!", noKey, encodeCursor(SortByName, []byte(`"x"`), "")} {
+		if _, err := repo.GetAllProducts(ctx, ListOptions{Cursor: cursor}); !errors.Is(err, ErrValidation) {
+			t.Errorf("cursor %q got error %v, want ErrValidation", cursor, err)
+		}
+	}
+}
+
+func TestGetAllProductsLimit(t *testing.T) {
+	repo := pagedRepo(t, 3)
+	ctx := context.Background()
+
+	tests := []struct {
+		limit int
+		want  int
+		valid bool
+	}{
+		{0, DefaultPageLimit, true},
+		{1, 1, true},
+		{MaxPageLimit, MaxPageLimit, true},
+		{MaxPageLimit + 1, 0, false},
+		{-1, 0, false},
+	}
+	for _, tt := range tests {
+		page, err := repo.GetAllProducts(ctx, ListOptions{Limit: tt.limit})
+		if !tt.valid {
+			if !errors.Is(err, ErrValidation) {
+				t.Errorf("limit %d got error %v, want ErrValidation", tt.limit, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("limit %d: %v", tt.limit, err)
+		}
+		if page.Limit != tt.want {
+			t.Errorf("limit %d got page limit %d, want %d", tt.limit, page.Limit, tt.want)
+		}
+	}
+}
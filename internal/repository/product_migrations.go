@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// Batch sizes for MigrateProducts
+const (
+	DefaultMigrationBatchSize = 200
+	MaxMigrationBatchSize     = 1000
+)
+
+// maxMigrationAttempts bounds how often a document is re-read and re-migrated after a write conflict
+const maxMigrationAttempts = 3
+
+// ProductMigration upgrades a raw product document from schema version Version-1 to
+// Version. It works on the decoded JSON rather than entity.Product, because older
+// documents may not fit the current struct. Up must not touch _id or _rev.
+type ProductMigration struct {
+	Version     int
+	Description string
+	Up          func(doc map[string]interface{}) error
+}
+
+// productMigrations is the ordered registry of product migrations. Append new
+// migrations with the next version number; never edit or reorder released ones.
+var productMigrations = []ProductMigration{
+	{
+		Version:     1,
+		Description: "record schema_version on products written before documents were versioned",
+		Up:          func(doc map[string]interface{}) error { return nil },
+	},
+}
+
+// CurrentSchemaVersion is the schema version of newly written product documents
+var CurrentSchemaVersion = productMigrations[len(productMigrations)-1].Version
+
+// MigrationOptions controls a MigrateProducts run
+type MigrationOptions struct {
+	DryRun    bool
+	BatchSize int
+}
+
+// MigrationReport describes the outcome of MigrateProducts
+type MigrationReport struct {
+	TargetVersion int               `json:"target_version"`
+	DryRun        bool              `json:"dry_run"`
+	Scanned       int               `json:"scanned"`
+	UpToDate      int               `json:"up_to_date"`
+	Migrated      int               `json:"migrated"`
+	Retried       int               `json:"retried"`
+	FromVersions  map[int]int       `json:"from_versions"`
+	Failed        map[string]string `json:"failed"`
+}
+
+// normalize applies defaults and validates the options
+func (o *MigrationOptions) normalize() error {
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultMigrationBatchSize
+	}
+	if o.BatchSize < 0 || o.BatchSize > MaxMigrationBatchSize {
+		return NewValidationError(fmt.Sprintf("batch size must be between 1 and %d", MaxMigrationBatchSize), nil)
+	}
+	return nil
+}
+
+// MigrateProducts streams all product documents in batches, upgrades those below
+// CurrentSchemaVersion with the registered migrations and writes them back with
+// _bulk_docs. Documents changed concurrently are re-read and migrated again.
+// With opts.DryRun set the migrations run but nothing is written.
+func (r *ProductRepo) MigrateProducts(ctx context.Context, opts MigrationOptions) (*MigrationReport, error) {
+	db := r.db
+
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	for i, m := range productMigrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("product migration %q has version %d, expected %d", m.Description, m.Version, i+1)
+		}
+	}
+
+	report := &MigrationReport{
+		TargetVersion: CurrentSchemaVersion,
+		DryRun:        opts.DryRun,
+		FromVersions:  make(map[int]int),
+		Failed:        make(map[string]string),
+	}
+
+	startID := ""
+	for {
+		docs, next, err := scanDocs(ctx, db, startID, opts.BatchSize)
+		if err != nil {
+			return report, err
+		}
+
+		var pending []map[string]interface{}
+		for _, doc := range docs {
+			if !isProductDoc(doc) {
+				continue
+			}
+			report.Scanned++
+			from := schemaVersion(doc)
+			if from >= CurrentSchemaVersion {
+				report.UpToDate++
+				continue
+			}
+			report.FromVersions[from]++
+			if err := migrateDoc(doc); err != nil {
+				report.Failed[doc["_id"].(string)] = err.Error()
+				continue
+			}
+			pending = append(pending, doc)
+		}
+
+		if opts.DryRun {
+			report.Migrated += len(pending)
+		} else if err := writeMigrated(ctx, db, pending, report); err != nil {
+			return report, err
+		}
+
+		if next == "" {
+			break
+		}
+		startID = next
+	}
+
+	log.Printf("Product migration to schema version %d: %d scanned, %d migrated, %d failed",
+		report.TargetVersion, report.Scanned, report.Migrated, len(report.Failed))
+	return report, nil
+}
+
+// scanDocs reads one batch of documents ordered by ID, starting at startID. It returns
+// the ID the next batch starts at, or an empty string once the last batch has been read.
+func scanDocs(ctx context.Context, db *kivik.DB, startID string, batchSize int) ([]map[string]interface{}, string, error) {
+	// Fetch one extra row to learn where the next batch starts
+	opts := kivik.Options{
+		"include_docs": true,
+		"limit":        batchSize + 1,
+	}
+	if startID != "" {
+		opts["startkey"] = startID
+	}
+
+	rows, err := db.AllDocs(ctx, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list documents: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []map[string]interface{}
+	for n := 0; rows.Next(); n++ {
+		if n == batchSize {
+			return docs, rows.ID(), nil
+		}
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			log.Println("Failed to scan document:", rows.ID(), err)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to list documents: %w", err)
+	}
+	return docs, "", nil
+}
+
+// writeMigrated saves migrated documents with _bulk_docs. Documents rejected with a
+// conflict are re-read, migrated again and retried up to maxMigrationAttempts times.
+func writeMigrated(ctx context.Context, db *kivik.DB, docs []map[string]interface{}, report *MigrationReport) error {
+	for attempt := 1; len(docs) > 0; attempt++ {
+		batch := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			batch[i] = doc
+		}
+
+		bulk, err := db.BulkDocs(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to write migrated products: %w", err)
+		}
+
+		var conflicted []string
+		for bulk.Next() {
+			err := bulk.UpdateErr()
+			switch {
+			case err == nil:
+				report.Migrated++
+			case kivik.StatusCode(err) == 409 && attempt < maxMigrationAttempts:
+				conflicted = append(conflicted, bulk.ID())
+			default:
+				report.Failed[bulk.ID()] = err.Error()
+			}
+		}
+		if err := bulk.Err(); err != nil {
+			bulk.Close()
+			return fmt.Errorf("failed to read migration results: %w", err)
+		}
+		bulk.Close()
+
+		// Re-read conflicted documents; some may have been migrated or deleted meanwhile
+		docs = nil
+		for _, id := range conflicted {
+			report.Retried++
+			var doc map[string]interface{}
+			if err := db.Get(ctx, id).ScanDoc(&doc); err != nil {
+				if kivik.StatusCode(err) != 404 {
+					report.Failed[id] = err.Error()
+				}
+				continue
+			}
+			if schemaVersion(doc) >= CurrentSchemaVersion {
+				continue
+			}
+			if err := migrateDoc(doc); err != nil {
+				report.Failed[id] = err.Error()
+				continue
+			}
+			docs = append(docs, doc)
+		}
+	}
+	return nil
+}
+
+// migrateDoc applies every migration above the document's schema version in order
+func migrateDoc(doc map[string]interface{}) error {
+	for _, m := range productMigrations[schemaVersion(doc):] {
+		if err := m.Up(doc); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		doc["schema_version"] = m.Version
+	}
+	return nil
+}
+
+// schemaVersion returns the schema version of a raw document; unversioned documents are version 0
+func schemaVersion(doc map[string]interface{}) int {
+	switch version := doc["schema_version"].(type) {
+	case float64:
+		if version > 0 {
+			return int(version)
+		}
+	case int:
+		if version > 0 {
+			return version
+		}
+	}
+	return 0
+}
+
+// isProductDoc tells product documents apart from design documents, name reservations
+// and other typed documents stored in the products database
+func isProductDoc(doc map[string]interface{}) bool {
+	id, _ := doc["_id"].(string)
+	// Typed documents are recognised by their ID prefix too, since tombstones carry no type
+	for _, prefix := range []string{"_", nameReservationPrefix, eventIDPrefix, historyIDPrefix} {
+		if strings.HasPrefix(id, prefix) {
+			return false
+		}
+	}
+	_, typed := doc["type"]
+	return !typed
+}
@@ -1,270 +1,582 @@
-package repository
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"strings"
-
-	"e-learning/go-with-couchdb/internal/database"
-	"e-learning/go-with-couchdb/internal/entity"
-
-	"github.com/go-kivik/kivik/v3"
-	"github.com/google/uuid"
-)
-
-type ProductRepo struct{}
-
-// CreateProduct creates a new product, ensuring the name is unique
-func (r *ProductRepo) CreateProduct(ctx context.Context, product entity.Product) error {
-	db := database.GetDB("ishopdb")
-
-	if product.ID == "" {
-		product.ID = uuid.New().String()
-	}
-
-	// Check if a product with the same name already exists
-	exists, err := r.CheckProductNameExists(ctx, product.Name, "")
-	if err != nil {
-		log.Println("Error checking product name:", err)
-		return fmt.Errorf("failed to check product name: %w", err)
-	}
-	if exists {
-		log.Println("Product with name already exists:", product.Name)
-		return fmt.Errorf("product with name '%s' already exists", product.Name)
-	}
-
-	_, err = db.Put(ctx, product.ID, product)
-	if err != nil {
-		log.Println("Database error:", err)
-		return fmt.Errorf("failed to create product: %w", err)
-	}
-	return nil
-}
-
-// GetAllProducts retrieves all products from the database
-func (r *ProductRepo) GetAllProducts(ctx context.Context) ([]entity.Product, error) {
-	db := database.GetDB("ishopdb")
-
-	rows, err := db.AllDocs(ctx, kivik.Options{"include_docs": true})
-	if err != nil {
-		log.Println("Failed to retrieve products:", err)
-		return nil, fmt.Errorf("failed to retrieve products: %w", err)
-	}
-
-	var products []entity.Product
-	for rows.Next() {
-		// Get the document ID to check if it's a design document
-		docID := rows.ID()
-		if strings.HasPrefix(docID, "_design/") {
-			continue // Skip design documents
-		}
-
-		var product entity.Product
-		if err := rows.ScanDoc(&product); err != nil {
-			log.Println("Failed to scan product:", err)
-			continue
-		}
-		products = append(products, product)
-	}
-
-	return products, nil
-}
-
-// GetProductById retrieves a product by its ID
-func (r *ProductRepo) GetProductById(ctx context.Context, id string) (*entity.Product, error) {
-	db := database.GetDB("ishopdb")
-
-	row := db.Get(ctx, id)
-	if err := row.Err; err != nil {
-		if kivik.StatusCode(err) == 404 { // Not Found
-			return nil, fmt.Errorf("product with ID %s not found", id)
-		}
-		log.Println("Failed to retrieve product:", err)
-		return nil, fmt.Errorf("failed to retrieve product: %w", err)
-	}
-
-	var product entity.Product
-	if err := row.ScanDoc(&product); err != nil {
-		log.Println("Failed to scan product document:", err)
-		return nil, fmt.Errorf("failed to scan product document: %w", err)
-	}
-
-	return &product, nil
-}
-
-// UpdateProductById updates an existing product by ID
-func (r *ProductRepo) UpdateProductById(ctx context.Context, id string, updatedProduct entity.Product) error {
-	db := database.GetDB("ishopdb")
-
-	// Fetch the existing product
-	row := db.Get(ctx, id)
-	if err := row.Err; err != nil {
-		if kivik.StatusCode(err) == 404 {
-			return fmt.Errorf("product with ID %s not found", id)
-		}
-		log.Println("Failed to retrieve product:", err)
-		return fmt.Errorf("failed to retrieve product: %w", err)
-	}
-
-	var existingProduct entity.Product
-	if err := row.ScanDoc(&existingProduct); err != nil {
-		log.Println("Failed to scan product document:", err)
-		return fmt.Errorf("failed to scan product document: %w", err)
-	}
-
-	// Check for revision mismatch
-	if updatedProduct.Rev != existingProduct.Rev {
-		log.Println("Document revision mismatch. Please try again")
-		return fmt.Errorf("revision mismatch: expected %s, got %s", existingProduct.Rev, updatedProduct.Rev)
-	}
-
-	// Check if the updated name already exists (exclude current product)
-	if updatedProduct.Name != existingProduct.Name {
-		exists, err := r.CheckProductNameExists(ctx, updatedProduct.Name, id)
-		if err != nil {
-			log.Println("Error checking product name:", err)
-			return fmt.Errorf("failed to check product name: %w", err)
-		}
-		if exists {
-			log.Println("Product with name already exists:", updatedProduct.Name)
-			return fmt.Errorf("product with name '%s' already exists", updatedProduct.Name)
-		}
-	}
-
-	// Update fields
-	existingProduct.Name = updatedProduct.Name
-	existingProduct.Price = updatedProduct.Price
-
-	// Save the updated product
-	_, err := db.Put(ctx, id, existingProduct)
-	if err != nil {
-		log.Println("Failed to update product:", err)
-		return fmt.Errorf("failed to update product: %w", err)
-	}
-	return nil
-}
-
-// DeleteProductById deletes a product by its ID and revision
-func (r *ProductRepo) DeleteProductById(ctx context.Context, id string, rev string) error {
-	db := database.GetDB("ishopdb")
-
-	_, err := db.Delete(ctx, id, rev)
-	if err != nil {
-		if kivik.StatusCode(err) == 404 {
-			return fmt.Errorf("product with ID %s not found", id)
-		}
-		log.Println("Failed to delete product:", err)
-		return fmt.Errorf("failed to delete product: %w", err)
-	}
-	return nil
-}
-
-// BulkCreateProducts creates multiple products in a single operation
-func (r *ProductRepo) BulkCreateProducts(ctx context.Context, products []entity.Product) error {
-	db := database.GetDB("ishopdb")
-	var docs []interface{}
-
-	// Validate names for all products
-	for _, product := range products {
-		exists, err := r.CheckProductNameExists(ctx, product.Name, "")
-		if err != nil {
-			log.Println("Error checking product name:", err)
-			return fmt.Errorf("failed to check product name: %w", err)
-		}
-		if exists {
-			log.Println("Product with name already exists:", product.Name)
-			return fmt.Errorf("product with name '%s' already exists", product.Name)
-		}
-	}
-
-	// Prepare documents
-	for i, product := range products {
-		if product.ID == "" {
-			products[i].ID = uuid.New().String()
-		}
-		docs = append(docs, products[i])
-	}
-
-	_, err := db.BulkDocs(ctx, docs)
-	if err != nil {
-		log.Println("Failed to create products in bulk:", err)
-		return fmt.Errorf("failed to create products in bulk: %w", err)
-	}
-
-	return nil
-}
-
-// BulkUpdateProducts updates multiple products in a single operation
-func (r *ProductRepo) BulkUpdateProducts(ctx context.Context, products []entity.Product) error {
-	db := database.GetDB("ishopdb")
-	var docs []interface{}
-
-	// Validate names and prepare documents
-	for _, product := range products {
-		if product.ID == "" || product.Rev == "" {
-			log.Println("Product ID or Rev missing, skipping update for product:", product)
-			continue
-		}
-
-		// Fetch the existing product to get the current name
-		existing, err := r.GetProductById(ctx, product.ID)
-		if err != nil {
-			log.Println("Failed to fetch product for validation:", err)
-			continue
-		}
-
-		// Check if the name has changed and validate uniqueness
-		if product.Name != existing.Name {
-			exists, err := r.CheckProductNameExists(ctx, product.Name, product.ID)
-			if err != nil {
-				log.Println("Error checking product name:", err)
-				continue
-			}
-			if exists {
-				log.Println("Product with name already exists:", product.Name)
-				continue
-			}
-		}
-
-		docs = append(docs, product)
-	}
-
-	if len(docs) == 0 {
-		return fmt.Errorf("no valid products to update")
-	}
-
-	_, err := db.BulkDocs(ctx, docs)
-	if err != nil {
-		log.Println("Failed to update products in bulk:", err)
-		return fmt.Errorf("failed to update products in bulk: %w", err)
-	}
-
-	return nil
-}
-
-// CheckProductNameExists checks if a product with the given name already exists
-func (r *ProductRepo) CheckProductNameExists(ctx context.Context, name string, excludeID string) (bool, error) {
-	db := database.GetDB("ishopdb")
-	rows, err := db.Query(ctx, "_design/products", "_view/by_name", kivik.Options{
-		"key": name, // Exact match for the name
-	})
-	if err != nil {
-		log.Println("Failed to query products by name:", err)
-		return false, fmt.Errorf("failed to query products by name: %w", err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id string
-		if err := rows.ScanValue(&id); err != nil {
-			log.Println("Failed to scan row:", err)
-			continue
-		}
-		// If the found product's ID differs from excludeID, it’s a duplicate
-		if id != excludeID {
-			return true, nil
-		}
-	}
-	return false, nil
-}
\ No newline at end of file
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/database"
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+	"github.com/google/uuid"
+)
+
+// ProductRepo is the CouchDB backed ProductRepository
+type ProductRepo struct {
+	db *kivik.DB
+}
+
+// NewProductRepo creates a product repository on top of the given products database
+func NewProductRepo(db *kivik.DB) *ProductRepo {
+	return &ProductRepo{db: db}
+}
+
+// CreateProduct creates a new product. The name is claimed with a reservation
+// document first, so concurrent creates of the same name cannot both succeed.
+// It returns the stored product, including its generated ID and revision.
+func (r *ProductRepo) CreateProduct(ctx context.Context, product entity.Product) (*entity.Product, error) {
+	db := r.db
+
+	if product.ID == "" {
+		product.ID = uuid.New().String()
+	}
+	product.SchemaVersion = CurrentSchemaVersion
+	product.DeletedAt, product.DeletedBy = nil, ""
+	stamp(ctx, &product)
+
+	// A reservation already held by product.ID belongs to an existing product, whose
+	// ID the write below conflicts with; it is only released if reserved here
+	reserved, err := reserveName(ctx, db, product.Name, product.ID)
+	if err != nil {
+		log.Println("Failed to reserve product name:", product.Name, err)
+		return nil, err
+	}
+
+	rev, err := writeWithCompanions(ctx, db, product, companionDocs(nil, &product))
+	if err != nil {
+		if reserved {
+			releaseName(ctx, db, product.Name, product.ID)
+		}
+		if kivik.StatusCode(err) == 409 { // Conflict (ID already taken)
+			return nil, conflictError(fmt.Sprintf("product with ID %s already exists", product.ID), err)
+		}
+		log.Println("Database error:", err)
+		return nil, fmt.Errorf("failed to create product: %w", err)
+	}
+
+	product.Rev = rev
+	return &product, nil
+}
+
+// GetAllProducts retrieves one page of products, ordered by the view backing opts.Sort
+func (r *ProductRepo) GetAllProducts(ctx context.Context, opts ListOptions) (*ProductPage, error) {
+	db := r.db
+
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	view := listViews[opts.Sort]
+	// Fetch one extra row to find where the next page starts
+	queryOpts := kivik.Options{
+		"include_docs": true,
+		"limit":        opts.Limit + 1,
+		"descending":   view.descending,
+	}
+	if cursor != nil {
+		queryOpts["startkey"] = cursor.Key
+		queryOpts["startkey_docid"] = cursor.ID
+	}
+
+	rows, err := db.Query(ctx, "_design/products", "_view/"+view.name, queryOpts)
+	if err != nil {
+		log.Println("Failed to retrieve products:", err)
+		return nil, fmt.Errorf("failed to retrieve products: %w", err)
+	}
+	defer rows.Close()
+
+	page := &ProductPage{Products: []entity.Product{}, Limit: opts.Limit}
+	for rows.Next() {
+		if len(page.Products) == opts.Limit {
+			page.NextCursor = encodeCursor(opts.Sort, json.RawMessage(rows.Key()), rows.ID())
+			continue
+		}
+
+		var product entity.Product
+		if err := rows.ScanDoc(&product); err != nil {
+			log.Println("Failed to scan product:", err)
+			continue
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Failed to iterate products:", err)
+		return nil, fmt.Errorf("failed to retrieve products: %w", err)
+	}
+	page.TotalRows = rows.TotalRows()
+
+	return page, nil
+}
+
+// SearchProducts runs a filtered product search as a Mango query pinned to the index of its sort field
+func (r *ProductRepo) SearchProducts(ctx context.Context, q ProductQuery) (*SearchPage, error) {
+	db := r.db
+
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	sortField, descending := q.sortField()
+	direction := "asc"
+	if descending {
+		direction = "desc"
+	}
+	query := map[string]interface{}{
+		"selector":  q.selector(),
+		"sort":      []map[string]string{{sortField: direction}},
+		"limit":     q.Limit,
+		"use_index": []string{database.ProductIndexDesignDoc, database.ProductIndexes[sortField]},
+	}
+	if q.Cursor != "" {
+		query["bookmark"] = q.Cursor
+	}
+
+	rows, err := db.Find(ctx, query)
+	if err != nil {
+		if kivik.StatusCode(err) == 400 { // Bad Request (malformed selector or bookmark)
+			return nil, &Error{Kind: ErrValidation, Message: "invalid search: " + err.Error(), Err: err}
+		}
+		log.Println("Failed to search products:", err)
+		return nil, fmt.Errorf("failed to search products: %w", err)
+	}
+	defer rows.Close()
+
+	page := &SearchPage{Products: []entity.Product{}, Limit: q.Limit}
+	for rows.Next() {
+		var product entity.Product
+		if err := rows.ScanDoc(&product); err != nil {
+			log.Println("Failed to scan product:", err)
+			continue
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Failed to iterate products:", err)
+		return nil, fmt.Errorf("failed to search products: %w", err)
+	}
+
+	// Refuse to serve queries CouchDB could only answer with a full scan
+	if warning := rows.Warning(); strings.Contains(warning, "No matching index") {
+		log.Println("Rejected unindexed product search:", warning)
+		return nil, NewValidationError("search is not backed by an index", nil)
+	}
+	if len(page.Products) == q.Limit {
+		page.NextCursor = rows.Bookmark()
+	}
+
+	return page, nil
+}
+
+// GetProductById retrieves a product by its ID. Products in the trash are not found.
+func (r *ProductRepo) GetProductById(ctx context.Context, id string) (*entity.Product, error) {
+	product, err := r.getProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if product.Trashed() {
+		return nil, notFoundError(id, nil)
+	}
+	return product, nil
+}
+
+// getProduct retrieves a product by its ID, whether or not it is in the trash
+func (r *ProductRepo) getProduct(ctx context.Context, id string) (*entity.Product, error) {
+	db := r.db
+
+	row := db.Get(ctx, id)
+	if err := row.Err; err != nil {
+		if kivik.StatusCode(err) == 404 { // Not Found
+			return nil, notFoundError(id, err)
+		}
+		log.Println("Failed to retrieve product:", err)
+		return nil, fmt.Errorf("failed to retrieve product: %w", err)
+	}
+
+	var product entity.Product
+	if err := row.ScanDoc(&product); err != nil {
+		log.Println("Failed to scan product document:", err)
+		return nil, fmt.Errorf("failed to scan product document: %w", err)
+	}
+
+	return &product, nil
+}
+
+// UpdateProductById updates an existing product by ID
+func (r *ProductRepo) UpdateProductById(ctx context.Context, id string, updatedProduct entity.Product) error {
+	db := r.db
+
+	// Fetch the existing product
+	existing, err := r.GetProductById(ctx, id)
+	if err != nil {
+		return err
+	}
+	existingProduct := *existing
+
+	// Check for revision mismatch
+	if updatedProduct.Rev != existingProduct.Rev {
+		log.Println("Document revision mismatch. Please try again")
+		return revisionConflictError(existingProduct.Rev, updatedProduct.Rev, nil)
+	}
+
+	// Claim the new name before writing; the old one is released after a successful save
+	renamed := normalizeName(updatedProduct.Name) != normalizeName(existingProduct.Name)
+	if renamed {
+		if _, err := reserveName(ctx, db, updatedProduct.Name, id); err != nil {
+			log.Println("Failed to reserve product name:", updatedProduct.Name, err)
+			return err
+		}
+	}
+	oldName := existingProduct.Name
+	before := existingProduct
+
+	// Update fields
+	existingProduct.Name = updatedProduct.Name
+	existingProduct.Price = updatedProduct.Price
+	stamp(ctx, &existingProduct)
+
+	// Save the updated product together with its domain events and the previous version
+	_, err = writeWithCompanions(ctx, db, existingProduct, companionDocs(&before, &existingProduct))
+	if err != nil {
+		if renamed {
+			releaseName(ctx, db, updatedProduct.Name, id)
+		}
+		if kivik.StatusCode(err) == 409 { // Conflict (modified concurrently)
+			return revisionConflictError(existingProduct.Rev, updatedProduct.Rev, err)
+		}
+		log.Println("Failed to update product:", err)
+		return fmt.Errorf("failed to update product: %w", err)
+	}
+
+	if renamed {
+		releaseName(ctx, db, oldName, id)
+	}
+	return nil
+}
+
+// DeleteProductById moves a product to the trash by its ID and revision and releases
+// its name. The document is kept, marked with the deletion time and the actor in ctx,
+// until it is restored or purged.
+func (r *ProductRepo) DeleteProductById(ctx context.Context, id string, rev string) error {
+	db := r.db
+
+	existing, err := r.GetProductById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	trashed := trash(ctx, *existing)
+	trashed.Rev = rev
+	_, err = writeWithCompanions(ctx, db, trashed, companionDocs(existing, nil))
+	if err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return notFoundError(id, err)
+		}
+		if kivik.StatusCode(err) == 409 { // Conflict (stale revision)
+			return conflictError(fmt.Sprintf("revision %s is not the current revision of product %s", rev, id), err)
+		}
+		log.Println("Failed to delete product:", err)
+		return fmt.Errorf("failed to delete product: %w", err)
+	}
+
+	releaseName(ctx, db, existing.Name, id)
+	return nil
+}
+
+// BulkCreateProducts creates multiple products in a single _bulk_docs call and reports
+// the outcome of every item. Names are reserved before writing; an item whose name
+// is taken fails on its own unless opts.AllOrNothing is set, in which case nothing is written.
+func (r *ProductRepo) BulkCreateProducts(ctx context.Context, products []entity.Product, opts BulkOptions) ([]BulkItemResult, error) {
+	db := r.db
+	results := make([]BulkItemResult, len(products))
+	// reserved marks the items whose name reservation was made by this call, see CreateProduct
+	reserved := make([]bool, len(products))
+
+	// Reserve names for all products
+	for i := range products {
+		if products[i].ID == "" {
+			products[i].ID = uuid.New().String()
+		}
+		products[i].SchemaVersion = CurrentSchemaVersion
+		products[i].DeletedAt, products[i].DeletedBy = nil, ""
+		stamp(ctx, &products[i])
+		results[i] = BulkItemResult{Index: i, ID: products[i].ID}
+		acquired, err := reserveName(ctx, db, products[i].Name, products[i].ID)
+		if err != nil {
+			log.Println("Failed to reserve product name:", products[i].Name, err)
+			results[i].Err = err
+		}
+		reserved[i] = acquired
+	}
+
+	if opts.AllOrNothing && hasFailures(results) {
+		r.rollbackCreates(ctx, db, products, reserved, results)
+		return results, nil
+	}
+
+	// Prepare documents
+	var docs []interface{}
+	var indexes []int
+	var companions []interface{}
+	var owners []int
+	for i, product := range products {
+		if results[i].Err == nil {
+			docs = append(docs, product)
+			indexes = append(indexes, i)
+			for _, doc := range companionDocs(nil, &products[i]) {
+				companions = append(companions, doc)
+				owners = append(owners, i)
+			}
+		}
+	}
+	if len(docs) == 0 {
+		return results, nil
+	}
+
+	// Companion documents follow the products in the same call
+	bulk, err := db.BulkDocs(ctx, append(docs, companions...))
+	if err != nil {
+		log.Println("Failed to create products in bulk:", err)
+		for _, i := range indexes {
+			if reserved[i] {
+				releaseName(ctx, db, products[i].Name, products[i].ID)
+			}
+		}
+		return nil, fmt.Errorf("failed to create products in bulk: %w", err)
+	}
+	defer bulk.Close()
+
+	var written []writtenCompanion
+	for n := 0; bulk.Next(); n++ {
+		if n >= len(indexes) {
+			written = recordCompanion(written, owners[n-len(indexes)], bulk)
+			continue
+		}
+		i := indexes[n]
+		if err := bulk.UpdateErr(); err != nil {
+			log.Println("Failed to create product in bulk:", bulk.ID(), err)
+			results[i].Err = bulkWriteError(products[i].ID, err)
+			if reserved[i] {
+				releaseName(ctx, db, products[i].Name, products[i].ID)
+			}
+			continue
+		}
+		results[i].Rev = bulk.Rev()
+		created := products[i]
+		created.Rev = results[i].Rev
+		results[i].Product = &created
+	}
+	if err := bulk.Err(); err != nil {
+		log.Println("Failed to read bulk create results:", err)
+	}
+
+	if opts.AllOrNothing && hasFailures(results) {
+		r.rollbackCreates(ctx, db, products, reserved, results)
+	}
+	discardFailedCompanions(ctx, db, written, results)
+	return results, nil
+}
+
+// rollbackCreates undoes the successful items of an all-or-nothing bulk create:
+// written documents are deleted, names reserved by the call released, and the items marked aborted
+func (r *ProductRepo) rollbackCreates(ctx context.Context, db *kivik.DB, products []entity.Product, reserved []bool, results []BulkItemResult) {
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if result.Rev != "" {
+			if _, err := db.Delete(ctx, result.ID, result.Rev); err != nil {
+				log.Println("Failed to roll back bulk created product:", result.ID, err)
+			}
+		}
+		if reserved[i] || result.Rev != "" {
+			releaseName(ctx, db, products[i].Name, products[i].ID)
+		}
+	}
+	AbortPending(results)
+}
+
+// BulkUpdateProducts updates multiple products in a single _bulk_docs call and reports
+// the outcome of every item. Items with a missing or stale revision, an unknown ID
+// or a taken name fail on their own unless opts.AllOrNothing is set.
+func (r *ProductRepo) BulkUpdateProducts(ctx context.Context, products []entity.Product, opts BulkOptions) ([]BulkItemResult, error) {
+	db := r.db
+	results := make([]BulkItemResult, len(products))
+	previous := make([]entity.Product, len(products))
+
+	// Validate every item and claim new names
+	for i, product := range products {
+		results[i] = BulkItemResult{Index: i, ID: product.ID}
+		if product.ID == "" || product.Rev == "" {
+			results[i].Err = NewValidationError("product ID and _rev are required", nil)
+			continue
+		}
+
+		// Fetch the existing product to check the revision and current name
+		existing, err := r.GetProductById(ctx, product.ID)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if existing.Rev != product.Rev {
+			results[i].Err = revisionConflictError(existing.Rev, product.Rev, nil)
+			continue
+		}
+		previous[i] = *existing
+		products[i].SchemaVersion = existing.SchemaVersion
+		products[i].DeletedAt, products[i].DeletedBy = nil, ""
+		stamp(ctx, &products[i])
+
+		if normalizeName(product.Name) != normalizeName(existing.Name) {
+			if _, err := reserveName(ctx, db, product.Name, product.ID); err != nil {
+				log.Println("Failed to reserve product name:", product.Name, err)
+				results[i].Err = err
+				continue
+			}
+		}
+	}
+
+	if opts.AllOrNothing && hasFailures(results) {
+		r.rollbackUpdates(ctx, db, products, previous, results)
+		return results, nil
+	}
+
+	var docs []interface{}
+	var indexes []int
+	var companions []interface{}
+	var owners []int
+	for i, product := range products {
+		if results[i].Err == nil {
+			docs = append(docs, product)
+			indexes = append(indexes, i)
+			for _, doc := range companionDocs(&previous[i], &products[i]) {
+				companions = append(companions, doc)
+				owners = append(owners, i)
+			}
+		}
+	}
+	if len(docs) == 0 {
+		return results, nil
+	}
+
+	// Companion documents follow the products in the same call
+	bulk, err := db.BulkDocs(ctx, append(docs, companions...))
+	if err != nil {
+		log.Println("Failed to update products in bulk:", err)
+		for _, i := range indexes {
+			r.settleRename(ctx, db, products[i], previous[i].Name, false)
+		}
+		return nil, fmt.Errorf("failed to update products in bulk: %w", err)
+	}
+	defer bulk.Close()
+
+	var written []writtenCompanion
+	for n := 0; bulk.Next(); n++ {
+		if n >= len(indexes) {
+			written = recordCompanion(written, owners[n-len(indexes)], bulk)
+			continue
+		}
+		i := indexes[n]
+		if err := bulk.UpdateErr(); err != nil {
+			log.Println("Failed to update product in bulk:", bulk.ID(), err)
+			results[i].Err = bulkWriteError(products[i].ID, err)
+			r.settleRename(ctx, db, products[i], previous[i].Name, false)
+			continue
+		}
+		results[i].Rev = bulk.Rev()
+		r.settleRename(ctx, db, products[i], previous[i].Name, true)
+	}
+	if err := bulk.Err(); err != nil {
+		log.Println("Failed to read bulk update results:", err)
+	}
+
+	if opts.AllOrNothing && hasFailures(results) {
+		r.rollbackUpdates(ctx, db, products, previous, results)
+	}
+	discardFailedCompanions(ctx, db, written, results)
+	return results, nil
+}
+
+// rollbackUpdates undoes the successful items of an all-or-nothing bulk update.
+// Items validated but not written give back their new name; written items are
+// restored to their previous content as a new revision.
+func (r *ProductRepo) rollbackUpdates(ctx context.Context, db *kivik.DB, products []entity.Product, previous []entity.Product, results []BulkItemResult) {
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if result.Rev == "" {
+			r.settleRename(ctx, db, products[i], previous[i].Name, false)
+			continue
+		}
+
+		restored := previous[i]
+		restored.Rev = result.Rev
+		if normalizeName(products[i].Name) != normalizeName(restored.Name) {
+			if _, err := reserveName(ctx, db, restored.Name, restored.ID); err != nil {
+				log.Println("Failed to reclaim name while rolling back product:", restored.ID, err)
+				continue
+			}
+		}
+		if _, err := db.Put(ctx, restored.ID, restored); err != nil {
+			log.Println("Failed to roll back bulk updated product:", restored.ID, err)
+			continue
+		}
+		if normalizeName(products[i].Name) != normalizeName(restored.Name) {
+			releaseName(ctx, db, products[i].Name, restored.ID)
+		}
+	}
+	AbortPending(results)
+}
+
+// bulkWriteError converts a per-document _bulk_docs error into a domain error
+func bulkWriteError(id string, err error) error {
+	if kivik.StatusCode(err) == 409 {
+		return conflictError(fmt.Sprintf("document update conflict for product %s", id), err)
+	}
+	return fmt.Errorf("failed to write product %s: %w", id, err)
+}
+
+// settleRename releases whichever name a product no longer uses after a write:
+// the old one if the write succeeded, the newly reserved one if it failed
+func (r *ProductRepo) settleRename(ctx context.Context, db *kivik.DB, product entity.Product, oldName string, written bool) {
+	if normalizeName(product.Name) == normalizeName(oldName) {
+		return
+	}
+	if written {
+		releaseName(ctx, db, oldName, product.ID)
+		return
+	}
+	releaseName(ctx, db, product.Name, product.ID)
+}
+
+// CheckProductNameExists checks if another live product holds a reservation for the name
+func (r *ProductRepo) CheckProductNameExists(ctx context.Context, name string, excludeID string) (bool, error) {
+	db := r.db
+
+	var reservation nameReservation
+	if err := db.Get(ctx, reservationID(name)).ScanDoc(&reservation); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return false, nil
+		}
+		log.Println("Failed to read name reservation:", err)
+		return false, fmt.Errorf("failed to read name reservation: %w", err)
+	}
+	if reservation.ProductID == excludeID {
+		return false, nil
+	}
+
+	// A stale reservation, whose product is in the trash or gone, does not count
+	holder, err := reservationHolder(ctx, db, reservation.ProductID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check name reservation holder: %w", err)
+	}
+	return !reservationStale(reservation, holder, time.Now()), nil
+}
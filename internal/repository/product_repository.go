@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// ProductRepository defines the storage operations the product service depends on
+type ProductRepository interface {
+	CreateProduct(ctx context.Context, product entity.Product) (*entity.Product, error)
+	GetAllProducts(ctx context.Context, opts ListOptions) (*ProductPage, error)
+	SearchProducts(ctx context.Context, q ProductQuery) (*SearchPage, error)
+	GetProductById(ctx context.Context, id string) (*entity.Product, error)
+	UpdateProductById(ctx context.Context, id string, updatedProduct entity.Product) error
+	DeleteProductById(ctx context.Context, id string, rev string) error
+	BulkCreateProducts(ctx context.Context, products []entity.Product, opts BulkOptions) ([]BulkItemResult, error)
+	BulkUpdateProducts(ctx context.Context, products []entity.Product, opts BulkOptions) ([]BulkItemResult, error)
+	CheckProductNameExists(ctx context.Context, name string, excludeID string) (bool, error)
+	ListTrash(ctx context.Context, opts ListOptions) (*ProductPage, error)
+	GetTrashedProductById(ctx context.Context, id string) (*entity.Product, error)
+	RestoreProductById(ctx context.Context, id string, rev string) (*entity.Product, error)
+	PurgeTrash(ctx context.Context, opts PurgeOptions) (*PurgeReport, error)
+	GetProductHistory(ctx context.Context, id string, opts ListOptions) (*HistoryPage, error)
+	GetProductVersion(ctx context.Context, id string, rev string) (*entity.Product, error)
+	ListConflicts(ctx context.Context, opts ListOptions) (*ProductPage, error)
+	GetProductWithConflicts(ctx context.Context, id string) (*entity.Product, error)
+	GetProductConflicts(ctx context.Context, id string) (*ConflictSet, error)
+	DiscardConflicts(ctx context.Context, id string, losing []entity.Product) error
+	WatchProducts(ctx context.Context, since string) (ProductChangeFeed, error)
+}
+
+// Ensure both backends satisfy the interface
+var (
+	_ ProductRepository = (*ProductRepo)(nil)
+	_ ProductRepository = (*MemoryProductRepo)(nil)
+)
+
+// reservedIDPrefixes start the IDs of the documents kept next to products, and of
+// CouchDB's own documents such as design documents
+var reservedIDPrefixes = []string{nameReservationPrefix, historyIDPrefix, eventIDPrefix, "_"}
+
+// CheckProductID rejects client supplied product IDs that could overwrite one of the
+// documents kept next to products
+func CheckProductID(id string) error {
+	for _, prefix := range reservedIDPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return NewValidationError(fmt.Sprintf("_id must not start with '%s'", prefix), map[string]string{"_id": id})
+		}
+	}
+	return nil
+}
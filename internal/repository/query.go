@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// Operators accepted in product search conditions. OpPrefix is not a Mango operator;
+// it matches names starting with the value regardless of case, as names are unique
+// regardless of case, and is rewritten into an index-friendly $gte/$lt range.
+const (
+	OpEq     = "$eq"
+	OpGt     = "$gt"
+	OpGte    = "$gte"
+	OpLt     = "$lt"
+	OpLte    = "$lte"
+	OpPrefix = "$prefix"
+)
+
+// searchableFields lists the fields that have a Mango index and the operators allowed on them
+var searchableFields = map[string]map[string]bool{
+	"name":  {OpEq: true, OpGt: true, OpGte: true, OpLt: true, OpLte: true, OpPrefix: true},
+	"price": {OpEq: true, OpGt: true, OpGte: true, OpLt: true, OpLte: true},
+}
+
+// Condition is a single field/operator/value filter
+type Condition struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+// ProductQuery is a filtered product search, executed as a Mango (_find) query
+type ProductQuery struct {
+	Conditions []Condition
+	Sort       string
+	Limit      int
+	Cursor     string
+}
+
+// SearchPage is one page of product search results. NextCursor is a Mango bookmark.
+type SearchPage struct {
+	Products   []entity.Product `json:"products"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Limit      int              `json:"limit"`
+}
+
+// ParseSelector validates a client supplied selector of the form
+// {"price": {"$gte": 10}, "name": {"$prefix": "lap"}} and converts it to conditions.
+// Only indexed fields and comparison operators are accepted; combinators such as
+// $or, $regex or $elemMatch are rejected because they cannot be served by an index.
+func ParseSelector(selector map[string]interface{}) ([]Condition, error) {
+	fields := make([]string, 0, len(selector))
+	for field := range selector {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var conditions []Condition
+	for _, field := range fields {
+		ops, ok := searchableFields[field]
+		if !ok {
+			return nil, NewValidationError(fmt.Sprintf("field '%s' cannot be searched", field), nil)
+		}
+
+		switch value := selector[field].(type) {
+		case map[string]interface{}:
+			for op, operand := range value {
+				if !ops[op] {
+					return nil, NewValidationError(fmt.Sprintf("operator '%s' is not allowed on field '%s'", op, field), nil)
+				}
+				conditions = append(conditions, Condition{Field: field, Operator: op, Value: operand})
+			}
+		default:
+			// A bare value is an implicit $eq, as in Mango
+			conditions = append(conditions, Condition{Field: field, Operator: OpEq, Value: value})
+		}
+	}
+	return conditions, nil
+}
+
+// normalize applies defaults and validates the query
+func (q *ProductQuery) normalize() error {
+	if len(q.Conditions) == 0 {
+		return NewValidationError("at least one filter is required", nil)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range q.Conditions {
+		ops, ok := searchableFields[c.Field]
+		if !ok || !ops[c.Operator] {
+			return NewValidationError(fmt.Sprintf("operator '%s' is not allowed on field '%s'", c.Operator, c.Field), nil)
+		}
+		if err := checkOperand(c); err != nil {
+			return err
+		}
+		key := c.Field + c.Operator
+		if seen[key] {
+			return NewValidationError(fmt.Sprintf("operator '%s' is repeated on field '%s'", c.Operator, c.Field), nil)
+		}
+		seen[key] = true
+	}
+	if seen["name"+OpPrefix] && len(seen) > 1 {
+		for key := range seen {
+			if key != "name"+OpPrefix && strings.HasPrefix(key, "name$") {
+				return NewValidationError("'$prefix' cannot be combined with other operators on field 'name'", nil)
+			}
+		}
+	}
+
+	if q.Sort == "" {
+		// Sort on the first filtered field so the query can be served from its index
+		q.Sort = q.Conditions[0].Field
+	}
+	if _, ok := listViews[q.Sort]; !ok {
+		return NewValidationError(fmt.Sprintf("unsupported sort '%s'", q.Sort), nil)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxPageLimit {
+		return NewValidationError(fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit), nil)
+	}
+	return nil
+}
+
+// checkOperand ensures the operand type matches the field
+func checkOperand(c Condition) error {
+	switch c.Field {
+	case "name":
+		if _, ok := c.Value.(string); !ok {
+			return NewValidationError(fmt.Sprintf("value for name %s must be a string", c.Operator), nil)
+		}
+	case "price":
+		if _, ok := c.Value.(float64); !ok {
+			return NewValidationError(fmt.Sprintf("value for price %s must be a number", c.Operator), nil)
+		}
+	}
+	return nil
+}
+
+// sortField returns the document field and direction of the query's sort order
+func (q *ProductQuery) sortField() (string, bool) {
+	if q.Sort == SortByPriceDesc {
+		return "price", true
+	}
+	return q.Sort, false
+}
+
+// selector builds the Mango selector for the query. The sort field is always part
+// of the selector so that CouchDB can use its index for both filtering and sorting.
+func (q *ProductQuery) selector() map[string]interface{} {
+	fields := make(map[string]map[string]interface{})
+	for _, c := range q.Conditions {
+		if fields[c.Field] == nil {
+			fields[c.Field] = make(map[string]interface{})
+		}
+		if c.Operator == OpPrefix {
+			// CouchDB collates lowercase before uppercase, so the range from the lowercased
+			// prefix holds every case variant; it also holds variants differing in accents,
+			// which the regular expression filters out
+			prefix := strings.ToLower(c.Value.(string))
+			fields[c.Field][OpGte] = prefix
+			fields[c.Field][OpLt] = prefix + "\ufff0"
+			fields[c.Field]["$regex"] = "(?i)^" + regexp.QuoteMeta(c.Value.(string))
+			continue
+		}
+		fields[c.Field][c.Operator] = c.Value
+	}
+
+	sortField, _ := q.sortField()
+	if fields[sortField] == nil {
+		fields[sortField] = map[string]interface{}{OpGt: nil}
+	}
+
+	selector := make(map[string]interface{}, len(fields)+1)
+	for field, ops := range fields {
+		selector[field] = ops
+	}
+	// Products in the trash are never search results
+	selector["deleted_at"] = map[string]interface{}{"$exists": false}
+	return selector
+}
+
+// matches evaluates the query's conditions against a product
+func (q *ProductQuery) matches(product entity.Product) bool {
+	for _, c := range q.Conditions {
+		var cmp int
+		switch c.Field {
+		case "name":
+			value := c.Value.(string)
+			if c.Operator == OpPrefix {
+				if !strings.HasPrefix(strings.ToLower(product.Name), strings.ToLower(value)) {
+					return false
+				}
+				continue
+			}
+			cmp = collate(product.Name, value)
+		case "price":
+			cmp = collate(product.Price, c.Value.(float64))
+		}
+
+		ok := false
+		switch c.Operator {
+		case OpEq:
+			ok = cmp == 0
+		case OpGt:
+			ok = cmp > 0
+		case OpGte:
+			ok = cmp >= 0
+		case OpLt:
+			ok = cmp < 0
+		case OpLte:
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
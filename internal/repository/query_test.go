@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector map[string]interface{}
+		want     []Condition
+		valid    bool
+	}{
+		{
+			"bare value is $eq",
+			map[string]interface{}{"name": "Laptop"},
+			[]Condition{{"name", OpEq, "Laptop"}}, true,
+		},
+		{
+			"operators, fields in order",
+			map[string]interface{}{"price": map[string]interface{}{"$gte": 10.0}, "name": map[string]interface{}{"$prefix": "lap"}},
+			[]Condition{{"name", OpPrefix, "lap"}, {"price", OpGte, 10.0}}, true,
+		},
+		{"unindexed field", map[string]interface{}{"description": "x"}, nil, false},
+		{"combinator", map[string]interface{}{"$or": []interface{}{}}, nil, false},
+		{"regular expression", map[string]interface{}{"name": map[string]interface{}{"$regex": "^lap"}}, nil, false},
+		{"prefix of a number", map[string]interface{}{"price": map[string]interface{}{"$prefix": "1"}}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSelector(tt.selector)
+			if !tt.valid {
+				if !errors.Is(err, ErrValidation) {
+					t.Fatalf("got error %v, want ErrValidation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductQueryNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		query ProductQuery
+		valid bool
+	}{
+		{"no filter", ProductQuery{}, false},
+		{"string price", ProductQuery{Conditions: []Condition{{"price", OpGt, "10"}}}, false},
+		{"numeric name", ProductQuery{Conditions: []Condition{{"name", OpEq, 10.0}}}, false},
+		{"repeated operator", ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}, {"price", OpGt, 2.0}}}, false},
+		{"prefix with a range on name", ProductQuery{Conditions: []Condition{{"name", OpPrefix, "lap"}, {"name", OpLt, "m"}}}, false},
+		{"prefix with a price range", ProductQuery{Conditions: []Condition{{"name", OpPrefix, "lap"}, {"price", OpLt, 1000.0}}}, true},
+		{"unknown sort", ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}}, Sort: "created_at"}, false},
+		{"limit over the maximum", ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}}, Limit: MaxPageLimit + 1}, false},
+		{"negative limit", ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}}, Limit: -1}, false},
+		{"maximum limit", ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}}, Limit: MaxPageLimit}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.normalize()
+			if tt.valid && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrValidation) {
+				t.Errorf("got error %v, want ErrValidation", err)
+			}
+		})
+	}
+
+	q := ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}, {"name", OpPrefix, "lap"}}}
+	if err := q.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if q.Sort != SortByPrice || q.Limit != DefaultPageLimit {
+		t.Errorf("got sort %q and limit %d, want the first filtered field and the default limit", q.Sort, q.Limit)
+	}
+}
+
+func TestProductQuerySelector(t *testing.T) {
+	q := ProductQuery{Conditions: []Condition{{"name", OpPrefix, "Lap."}, {"price", OpLte, 1000.0}}, Sort: SortByPriceDesc}
+	want := map[string]interface{}{
+		"name":       map[string]interface{}{OpGte: "lap.", OpLt: "lap.￰", "$regex": `(?i)^Lap\.`},
+		"price":      map[string]interface{}{OpLte: 1000.0},
+		"deleted_at": map[string]interface{}{"$exists": false},
+	}
+	if got := q.selector(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got selector %v, want %v", got, want)
+	}
+
+	// The sort field is always part of the selector, so that its index is usable
+	q = ProductQuery{Conditions: []Condition{{"price", OpGt, 1.0}}, Sort: SortByName}
+	if got := q.selector()["name"]; !reflect.DeepEqual(got, map[string]interface{}{OpGt: nil}) {
+		t.Errorf("got name selector %v, want {$gt: null}", got)
+	}
+}
+
+func TestProductQueryPrefixIgnoresCase(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   bool
+	}{
+		{"Laptop Pro", "lap", true},
+		{"laptop pro", "LAP", true},
+		{"LAPTOP", "Laptop", true},
+		{"Lap", "lap", true},
+		{"Overlap", "lap", false},
+		{"La", "lap", false},
+	}
+	for _, tt := range tests {
+		q := ProductQuery{Conditions: []Condition{{"name", OpPrefix, tt.prefix}}}
+		if got := q.matches(entity.Product{Name: tt.name}); got != tt.want {
+			t.Errorf("%q with prefix %q: got %v, want %v", tt.name, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestMemorySearchProductsPaging(t *testing.T) {
+	repo := NewMemoryProductRepo()
+	ctx := context.Background()
+	for i := 1; i <= 7; i++ {
+		if _, err := repo.CreateProduct(ctx, entity.Product{ID: fmt.Sprintf("p%d", i), Name: fmt.Sprintf("Product %d", i), Price: float64(i * 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "cheap", Name: "Cheap", Price: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	q := ProductQuery{Conditions: []Condition{{"price", OpGte, 10.0}}, Sort: SortByPriceDesc, Limit: 3}
+	var prices []float64
+	var sizes []int
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatal("paging does not end")
+		}
+		page, err := repo.SearchProducts(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(page.Products))
+		for _, product := range page.Products {
+			prices = append(prices, product.Price)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if !reflect.DeepEqual(sizes, []int{3, 3, 1}) {
+		t.Errorf("got page sizes %v, want 3, 3, 1", sizes)
+	}
+	if want := []float64{70, 60, 50, 40, 30, 20, 10}; !reflect.DeepEqual(prices, want) {
+		t.Errorf("got prices %v, want %v", prices, want)
+	}
+
+	page, err := repo.SearchProducts(ctx, ProductQuery{Conditions: []Condition{{"price", OpGte, 10.0}}, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q = ProductQuery{Conditions: []Condition{{"price", OpGte, 10.0}}, Sort: SortByName, Cursor: page.NextCursor}
+	if _, err := repo.SearchProducts(ctx, q); !errors.Is(err, ErrValidation) {
+		t.Errorf("got error %v for a cursor of another sort, want ErrValidation", err)
+	}
+}
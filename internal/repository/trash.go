@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+)
+
+// trashSort identifies trash listing cursors; the trash is listed newest deletion first
+const trashSort = "-deleted_at"
+
+// Batch size limits for PurgeTrash
+const (
+	DefaultPurgeBatchSize = 100
+	MaxPurgeBatchSize     = 1000
+)
+
+// PurgeOptions selects the trashed products PurgeTrash removes. Products deleted before
+// Before are removed for good: with Hard set they are _purge'd, leaving no tombstone
+// behind, otherwise they are deleted.
+type PurgeOptions struct {
+	Before    time.Time
+	Hard      bool
+	BatchSize int
+	DryRun    bool
+}
+
+// PurgeReport describes the outcome of PurgeTrash
+type PurgeReport struct {
+	Purged []string          `json:"purged"`
+	Failed map[string]string `json:"failed,omitempty"`
+	DryRun bool              `json:"dry_run"`
+}
+
+// trash marks a product as soft deleted now by the actor in ctx. The time is kept with
+// second precision so that deletion times sort correctly as strings in the trash view.
+func trash(ctx context.Context, product entity.Product) entity.Product {
+	stamp(ctx, &product)
+	product.DeletedAt = product.UpdatedAt
+	product.DeletedBy = product.UpdatedBy
+	return product
+}
+
+// trashKey formats a time as a key of the trash view
+func trashKey(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// normalize applies defaults and validates the options
+func (o *PurgeOptions) normalize() error {
+	if o.Before.IsZero() {
+		return NewValidationError("purge cutoff time is required", nil)
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultPurgeBatchSize
+	}
+	if o.BatchSize < 0 || o.BatchSize > MaxPurgeBatchSize {
+		return NewValidationError(fmt.Sprintf("batch size must be between 1 and %d", MaxPurgeBatchSize), nil)
+	}
+	return nil
+}
+
+// ListTrash retrieves one page of soft deleted products, most recently deleted first.
+// opts.Sort is ignored.
+func (r *ProductRepo) ListTrash(ctx context.Context, opts ListOptions) (*ProductPage, error) {
+	db := r.db
+
+	opts.Sort = SortByName
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(opts.Cursor, trashSort)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to find where the next page starts
+	queryOpts := kivik.Options{
+		"include_docs": true,
+		"limit":        opts.Limit + 1,
+		"descending":   true,
+	}
+	if cursor != nil {
+		queryOpts["startkey"] = cursor.Key
+		queryOpts["startkey_docid"] = cursor.ID
+	}
+
+	rows, err := db.Query(ctx, "_design/products", "_view/trash", queryOpts)
+	if err != nil {
+		log.Println("Failed to retrieve trashed products:", err)
+		return nil, fmt.Errorf("failed to retrieve trashed products: %w", err)
+	}
+	defer rows.Close()
+
+	page := &ProductPage{Products: []entity.Product{}, Limit: opts.Limit}
+	for rows.Next() {
+		if len(page.Products) == opts.Limit {
+			page.NextCursor = encodeCursor(trashSort, json.RawMessage(rows.Key()), rows.ID())
+			continue
+		}
+
+		var product entity.Product
+		if err := rows.ScanDoc(&product); err != nil {
+			log.Println("Failed to scan product:", err)
+			continue
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Failed to iterate trashed products:", err)
+		return nil, fmt.Errorf("failed to retrieve trashed products: %w", err)
+	}
+	page.TotalRows = rows.TotalRows()
+
+	return page, nil
+}
+
+// GetTrashedProductById retrieves a product in the trash by its ID
+func (r *ProductRepo) GetTrashedProductById(ctx context.Context, id string) (*entity.Product, error) {
+	product, err := r.getProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !product.Trashed() {
+		return nil, resourceNotFoundError("trashed product", id, nil)
+	}
+	return product, nil
+}
+
+// RestoreProductById takes a product out of the trash by its ID and revision. Its name
+// is claimed again first, so the restore fails with ErrDuplicateName if another product
+// took the name in the meantime.
+func (r *ProductRepo) RestoreProductById(ctx context.Context, id string, rev string) (*entity.Product, error) {
+	db := r.db
+
+	existing, err := r.GetTrashedProductById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Rev != rev {
+		return nil, revisionConflictError(existing.Rev, rev, nil)
+	}
+
+	if _, err := reserveName(ctx, db, existing.Name, id); err != nil {
+		log.Println("Failed to reserve product name:", existing.Name, err)
+		return nil, err
+	}
+
+	restored := *existing
+	restored.DeletedAt, restored.DeletedBy = nil, ""
+	stamp(ctx, &restored)
+	newRev, err := writeWithCompanions(ctx, db, restored, companionDocs(existing, &restored))
+	if err != nil {
+		releaseName(ctx, db, existing.Name, id)
+		if kivik.StatusCode(err) == 409 { // Conflict (modified concurrently)
+			return nil, revisionConflictError(existing.Rev, rev, err)
+		}
+		log.Println("Failed to restore product:", err)
+		return nil, fmt.Errorf("failed to restore product: %w", err)
+	}
+
+	restored.Rev = newRev
+	return &restored, nil
+}
+
+// PurgeTrash removes the products that were moved to the trash before opts.Before,
+// together with their history. Plain deletes leave a tombstone marked "purged", which
+// the change feed skips since the deletion was already reported when the product was trashed.
+func (r *ProductRepo) PurgeTrash(ctx context.Context, opts PurgeOptions) (*PurgeReport, error) {
+	db := r.db
+
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	report := &PurgeReport{Purged: []string{}, Failed: make(map[string]string), DryRun: opts.DryRun}
+	var next *pageCursor
+	for {
+		// Fetch one extra row to find where the next batch starts; it is not removed
+		// by this batch, so the position stays valid
+		queryOpts := kivik.Options{
+			"include_docs":  true,
+			"endkey":        trashKey(opts.Before),
+			"inclusive_end": false,
+			"limit":         opts.BatchSize + 1,
+		}
+		if next != nil {
+			queryOpts["startkey"] = next.Key
+			queryOpts["startkey_docid"] = next.ID
+		}
+
+		rows, err := db.Query(ctx, "_design/products", "_view/trash", queryOpts)
+		if err != nil {
+			log.Println("Failed to query trashed products:", err)
+			return report, fmt.Errorf("failed to query trashed products: %w", err)
+		}
+		revs := make(map[string]string)
+		next = nil
+		for n := 0; rows.Next(); n++ {
+			if n == opts.BatchSize {
+				next = &pageCursor{Key: json.RawMessage(rows.Key()), ID: rows.ID()}
+				break
+			}
+			var doc struct {
+				Rev string `json:"_rev"`
+			}
+			if err := rows.ScanDoc(&doc); err != nil {
+				report.Failed[rows.ID()] = err.Error()
+				continue
+			}
+			revs[rows.ID()] = doc.Rev
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return report, fmt.Errorf("failed to query trashed products: %w", err)
+		}
+
+		if opts.DryRun {
+			for id := range revs {
+				report.Purged = append(report.Purged, id)
+			}
+		} else {
+			r.purgeBatch(ctx, revs, opts.Hard, report)
+		}
+		if next == nil {
+			break
+		}
+	}
+	sort.Strings(report.Purged)
+	return report, nil
+}
+
+// purgeBatch removes a batch of trashed products, given as ID to revision, together
+// with their history, and records the outcome in the report
+func (r *ProductRepo) purgeBatch(ctx context.Context, revs map[string]string, hard bool, report *PurgeReport) {
+	removed, failed := removeDocs(ctx, r.db, revs, hard)
+	for id, reason := range failed {
+		report.Failed[id] = reason
+	}
+	for _, id := range removed {
+		r.removeHistory(ctx, id, hard)
+	}
+	report.Purged = append(report.Purged, removed...)
+}
+
+// removeDocs deletes documents, given as ID to revision, or _purges them when hard is
+// set. It returns the IDs removed and the reasons the others were not.
+func removeDocs(ctx context.Context, db *kivik.DB, revs map[string]string, hard bool) ([]string, map[string]string) {
+	var removed []string
+	failed := make(map[string]string)
+	if len(revs) == 0 {
+		return removed, failed
+	}
+
+	if hard {
+		docRevs := make(map[string][]string, len(revs))
+		for id, rev := range revs {
+			docRevs[id] = []string{rev}
+		}
+		result, err := db.Purge(ctx, docRevs)
+		if err != nil {
+			log.Println("Failed to purge documents:", err)
+			for id := range revs {
+				failed[id] = err.Error()
+			}
+			return removed, failed
+		}
+		for id := range revs {
+			if len(result.Purged[id]) == 0 {
+				failed[id] = "not purged"
+				continue
+			}
+			removed = append(removed, id)
+		}
+		return removed, failed
+	}
+
+	var docs []interface{}
+	for id, rev := range revs {
+		docs = append(docs, map[string]interface{}{"_id": id, "_rev": rev, "_deleted": true, "purged": true})
+	}
+	bulk, err := db.BulkDocs(ctx, docs)
+	if err != nil {
+		log.Println("Failed to delete documents:", err)
+		for id := range revs {
+			failed[id] = err.Error()
+		}
+		return removed, failed
+	}
+	defer bulk.Close()
+
+	for bulk.Next() {
+		if err := bulk.UpdateErr(); err != nil {
+			failed[bulk.ID()] = err.Error()
+			continue
+		}
+		removed = append(removed, bulk.ID())
+	}
+	if err := bulk.Err(); err != nil {
+		log.Println("Failed to read delete results:", err)
+	}
+	return removed, failed
+}
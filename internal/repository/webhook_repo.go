@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"e-learning/go-with-couchdb/internal/entity"
+
+	"github.com/go-kivik/kivik/v3"
+	"github.com/google/uuid"
+)
+
+// Document types stored in the webhooks database
+const (
+	webhookDocType  = "webhook"
+	deliveryDocType = "webhook_delivery"
+)
+
+// webhookDoc is a webhook as stored in CouchDB, tagged with its document type
+type webhookDoc struct {
+	entity.Webhook
+	Type string `json:"type"`
+}
+
+// deliveryDoc is a webhook delivery as stored in CouchDB, tagged with its document type
+type deliveryDoc struct {
+	entity.WebhookDelivery
+	Type string `json:"type"`
+}
+
+// checkpointDoc is a _local document holding a change feed position
+type checkpointDoc struct {
+	ID  string `json:"_id"`
+	Rev string `json:"_rev,omitempty"`
+	Seq string `json:"seq"`
+}
+
+// WebhookRepo is the CouchDB backed WebhookRepository
+type WebhookRepo struct {
+	db *kivik.DB
+}
+
+// NewWebhookRepo creates a webhook repository on top of the given webhooks database
+func NewWebhookRepo(db *kivik.DB) *WebhookRepo {
+	return &WebhookRepo{db: db}
+}
+
+// CreateWebhook stores a new webhook and returns it with its ID and revision
+func (r *WebhookRepo) CreateWebhook(ctx context.Context, webhook entity.Webhook) (*entity.Webhook, error) {
+	if webhook.ID == "" {
+		webhook.ID = uuid.New().String()
+	}
+
+	rev, err := r.db.Put(ctx, webhook.ID, webhookDoc{Webhook: webhook, Type: webhookDocType})
+	if err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return nil, conflictError(fmt.Sprintf("webhook with ID %s already exists", webhook.ID), err)
+		}
+		log.Println("Failed to create webhook:", err)
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	webhook.Rev = rev
+	return &webhook, nil
+}
+
+// ListWebhooks returns all webhooks ordered by ID
+func (r *WebhookRepo) ListWebhooks(ctx context.Context) ([]entity.Webhook, error) {
+	rows, err := r.db.Query(ctx, "_design/webhooks", "_view/webhooks", kivik.Options{"include_docs": true})
+	if err != nil {
+		log.Println("Failed to query webhooks:", err)
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []entity.Webhook{}
+	for rows.Next() {
+		var webhook entity.Webhook
+		if err := rows.ScanDoc(&webhook); err != nil {
+			log.Println("Failed to scan webhook:", err)
+			continue
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// GetWebhookById retrieves a webhook by its ID
+func (r *WebhookRepo) GetWebhookById(ctx context.Context, id string) (*entity.Webhook, error) {
+	var doc webhookDoc
+	if err := r.db.Get(ctx, id).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return nil, resourceNotFoundError("webhook", id, err)
+		}
+		log.Println("Failed to retrieve webhook:", err)
+		return nil, fmt.Errorf("failed to retrieve webhook: %w", err)
+	}
+	if doc.Type != webhookDocType {
+		return nil, resourceNotFoundError("webhook", id, nil)
+	}
+	return &doc.Webhook, nil
+}
+
+// UpdateWebhookById replaces a webhook. webhook.Rev must be the current revision.
+func (r *WebhookRepo) UpdateWebhookById(ctx context.Context, id string, webhook entity.Webhook) (*entity.Webhook, error) {
+	webhook.ID = id
+	rev, err := r.db.Put(ctx, id, webhookDoc{Webhook: webhook, Type: webhookDocType})
+	if err != nil {
+		if kivik.StatusCode(err) == 409 {
+			return nil, conflictError(fmt.Sprintf("revision %s is not the current revision of webhook %s", webhook.Rev, id), err)
+		}
+		log.Println("Failed to update webhook:", err)
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	webhook.Rev = rev
+	return &webhook, nil
+}
+
+// DeleteWebhookById deletes a webhook. Its deliveries are kept for the delivery log.
+func (r *WebhookRepo) DeleteWebhookById(ctx context.Context, id string, rev string) error {
+	if _, err := r.db.Delete(ctx, id, rev); err != nil {
+		switch kivik.StatusCode(err) {
+		case 404:
+			return resourceNotFoundError("webhook", id, err)
+		case 409:
+			return conflictError(fmt.Sprintf("revision %s is not the current revision of webhook %s", rev, id), err)
+		}
+		log.Println("Failed to delete webhook:", err)
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// SaveDelivery creates or updates a delivery and returns it with its new revision
+func (r *WebhookRepo) SaveDelivery(ctx context.Context, delivery entity.WebhookDelivery) (*entity.WebhookDelivery, error) {
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+
+	rev, err := r.db.Put(ctx, delivery.ID, deliveryDoc{WebhookDelivery: delivery, Type: deliveryDocType})
+	if err != nil {
+		log.Println("Failed to save webhook delivery:", err)
+		return nil, fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+
+	delivery.Rev = rev
+	return &delivery, nil
+}
+
+// ListDeliveries returns the most recent deliveries of a webhook, newest first,
+// optionally restricted to one status
+func (r *WebhookRepo) ListDeliveries(ctx context.Context, webhookID string, status string, limit int) ([]entity.WebhookDelivery, error) {
+	view := "deliveries"
+	prefix := []interface{}{webhookID}
+	if status != "" {
+		view = "deliveries_by_status"
+		prefix = append(prefix, status)
+	}
+
+	// Descending order swaps the start and end keys; {} sorts after every string
+	return r.queryDeliveries(ctx, view, kivik.Options{
+		"include_docs": true,
+		"descending":   true,
+		"startkey":     append(append([]interface{}{}, prefix...), map[string]interface{}{}),
+		"endkey":       prefix,
+		"limit":        limit,
+	})
+}
+
+// ListPendingDeliveries returns all pending deliveries, the ones due first
+func (r *WebhookRepo) ListPendingDeliveries(ctx context.Context) ([]entity.WebhookDelivery, error) {
+	return r.queryDeliveries(ctx, "pending_deliveries", kivik.Options{"include_docs": true})
+}
+
+// queryDeliveries reads the deliveries of a view in view order
+func (r *WebhookRepo) queryDeliveries(ctx context.Context, view string, opts kivik.Options) ([]entity.WebhookDelivery, error) {
+	rows, err := r.db.Query(ctx, "_design/webhooks", "_view/"+view, opts)
+	if err != nil {
+		log.Println("Failed to query webhook deliveries:", err)
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []entity.WebhookDelivery{}
+	for rows.Next() {
+		var delivery entity.WebhookDelivery
+		if err := rows.ScanDoc(&delivery); err != nil {
+			log.Println("Failed to scan webhook delivery:", err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// GetCheckpoint returns the change feed position saved under name, or an empty string if there is none
+func (r *WebhookRepo) GetCheckpoint(ctx context.Context, name string) (string, error) {
+	var doc checkpointDoc
+	if err := r.db.Get(ctx, "_local/"+name).ScanDoc(&doc); err != nil {
+		if kivik.StatusCode(err) == 404 {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read checkpoint %s: %w", name, err)
+	}
+	return doc.Seq, nil
+}
+
+// SaveCheckpoint saves a change feed position under name. _local documents are
+// never replicated, so each database keeps its own position.
+func (r *WebhookRepo) SaveCheckpoint(ctx context.Context, name string, seq string) error {
+	id := "_local/" + name
+	var doc checkpointDoc
+	if err := r.db.Get(ctx, id).ScanDoc(&doc); err != nil && kivik.StatusCode(err) != 404 {
+		return fmt.Errorf("failed to read checkpoint %s: %w", name, err)
+	}
+
+	doc.ID = id
+	doc.Seq = seq
+	if _, err := r.db.Put(ctx, id, doc); err != nil {
+		return fmt.Errorf("failed to save checkpoint %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+
+	"e-learning/go-with-couchdb/internal/entity"
+)
+
+// Delivery log page size limits
+const (
+	DefaultDeliveryLimit = 50
+	MaxDeliveryLimit     = 500
+)
+
+// WebhookRepository stores webhook subscriptions, their deliveries and the
+// position of the delivery dispatcher in the product change feed
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook entity.Webhook) (*entity.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]entity.Webhook, error)
+	GetWebhookById(ctx context.Context, id string) (*entity.Webhook, error)
+	UpdateWebhookById(ctx context.Context, id string, webhook entity.Webhook) (*entity.Webhook, error)
+	DeleteWebhookById(ctx context.Context, id string, rev string) error
+	SaveDelivery(ctx context.Context, delivery entity.WebhookDelivery) (*entity.WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, webhookID string, status string, limit int) ([]entity.WebhookDelivery, error)
+	ListPendingDeliveries(ctx context.Context) ([]entity.WebhookDelivery, error)
+	GetCheckpoint(ctx context.Context, name string) (string, error)
+	SaveCheckpoint(ctx context.Context, name string, seq string) error
+}
+
+// Ensure both backends satisfy the interface
+var (
+	_ WebhookRepository = (*WebhookRepo)(nil)
+	_ WebhookRepository = (*MemoryWebhookRepo)(nil)
+)
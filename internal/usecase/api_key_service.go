@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/actor"
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// DefaultAPIKeyLifetime is the lifetime of API keys created without an expiry
+const DefaultAPIKeyLifetime = 90 * 24 * time.Hour
+
+// apiKeyUsageInterval limits how often the last-used time of a key is written
+const apiKeyUsageInterval = time.Minute
+
+// apiKeyPrefix starts every API key, which reads "ak_<id>_<secret>"
+const apiKeyPrefix = "ak_"
+
+var errInvalidAPIKey = fmt.Errorf("%w: invalid API key", auth.ErrUnauthenticated)
+
+// APIKeyService issues, rotates and revokes API keys and verifies the keys machine
+// clients send. Callers may only issue keys with scopes the access policy grants them;
+// without a policy any scope may be granted.
+type APIKeyService struct {
+	repo   repository.APIKeyRepository
+	access *auth.Policy
+}
+
+func NewAPIKeyService(repo repository.APIKeyRepository, access *auth.Policy) *APIKeyService {
+	return &APIKeyService{repo: repo, access: access}
+}
+
+// CreateAPIKey issues a key with the name, scopes and expiry of the given one, expiring
+// after DefaultAPIKeyLifetime unless set. It returns the stored key and the key itself,
+// which is not kept and cannot be shown again.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, key entity.APIKey) (*entity.APIKey, string, error) {
+	if err := checkScopes(key.Scopes); err != nil {
+		return nil, "", err
+	}
+	if err := s.checkGrantable(ctx, key.Scopes); err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if key.ExpiresAt == nil {
+		expiresAt := now.Add(DefaultAPIKeyLifetime)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := checkExpiry(key.ExpiresAt, now); err != nil {
+		return nil, "", err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := newAPIKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	created, err := s.repo.CreateAPIKey(ctx, entity.APIKey{
+		ID:        id,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		Hash:      hashAPIKey(secret),
+		ExpiresAt: key.ExpiresAt,
+		CreatedAt: now,
+		CreatedBy: actor.FromContext(ctx),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return created, secret, nil
+}
+
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	return s.repo.ListAPIKeys(ctx)
+}
+
+func (s *APIKeyService) GetAPIKeyById(ctx context.Context, id string) (*entity.APIKey, error) {
+	return s.repo.GetAPIKeyById(ctx, id)
+}
+
+// RotateAPIKey replaces the key of an API key at revision rev, which stops the old key
+// from working at once. The caller must hold the key's scopes, as when creating it. The
+// expiry is kept unless expiresAt is given. It returns the stored key and the new key.
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, id string, rev string, expiresAt *time.Time) (*entity.APIKey, string, error) {
+	existing, err := s.repo.GetAPIKeyById(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing.RevokedAt != nil {
+		return nil, "", repository.NewValidationError(fmt.Sprintf("API key %s is revoked", id), nil)
+	}
+	if err := s.checkGrantable(ctx, existing.Scopes); err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if expiresAt != nil {
+		existing.ExpiresAt = expiresAt
+	}
+	if err := checkExpiry(existing.ExpiresAt, now); err != nil {
+		return nil, "", err
+	}
+
+	secret, err := newAPIKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+	existing.Rev = rev
+	existing.Hash = hashAPIKey(secret)
+	existing.RotatedAt = &now
+	rotated, err := s.repo.UpdateAPIKeyById(ctx, id, *existing)
+	if err != nil {
+		return nil, "", err
+	}
+	return rotated, secret, nil
+}
+
+// RevokeAPIKey permanently disables an API key at revision rev. The key stays listed;
+// revoking it again changes nothing.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id string, rev string) (*entity.APIKey, error) {
+	existing, err := s.repo.GetAPIKeyById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing.RevokedAt != nil {
+		return existing, nil
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	existing.Rev = rev
+	existing.RevokedAt = &now
+	return s.repo.UpdateAPIKeyById(ctx, id, *existing)
+}
+
+// VerifyAPIKey checks a key sent in X-API-Key and returns the claims it stands for: the
+// subject "api-key:<id>" and the key's scopes. The last-used time is written at most once
+// per apiKeyUsageInterval; failing to write it does not fail the request.
+func (s *APIKeyService) VerifyAPIKey(ctx context.Context, key string) (auth.Claims, error) {
+	id, ok := apiKeyID(key)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	stored, err := s.repo.GetAPIKeyById(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(stored.Hash)) != 1 || stored.RevokedAt != nil {
+		return nil, errInvalidAPIKey
+	}
+	now := time.Now().UTC()
+	if stored.Expired(now) {
+		return nil, fmt.Errorf("%w: the API key has expired", auth.ErrUnauthenticated)
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= apiKeyUsageInterval {
+		usedAt := now.Truncate(time.Second)
+		stored.LastUsedAt = &usedAt
+		// A conflict means a concurrent request or a rotation wrote the key first
+		if _, err := s.repo.UpdateAPIKeyById(context.WithoutCancel(ctx), id, *stored); err != nil && !errors.Is(err, repository.ErrRevisionConflict) {
+			log.Println("Failed to record API key use:", id, err)
+		}
+	}
+
+	scopes := make([]interface{}, len(stored.Scopes))
+	for i, scope := range stored.Scopes {
+		scopes[i] = scope
+	}
+	return auth.Claims{"sub": "api-key:" + id, auth.ScopesClaim: scopes}, nil
+}
+
+// checkScopes only accepts well formed permissions, e.g. "products:read" or "products:*"
+func checkScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !auth.ValidGrant(scope) {
+			return repository.NewValidationError(fmt.Sprintf("invalid scope '%s', expected a permission such as products:read", scope), nil)
+		}
+	}
+	return nil
+}
+
+// checkGrantable rejects scopes the caller in ctx does not hold itself, so that keys
+// cannot be used to escalate privileges. Wildcard scopes need an equal or wider grant.
+func (s *APIKeyService) checkGrantable(ctx context.Context, scopes []string) error {
+	if s.access == nil {
+		return nil
+	}
+	claims, authenticated := auth.ClaimsFromContext(ctx)
+	for _, scope := range scopes {
+		if !s.access.Allows(claims, authenticated, scope) {
+			return &repository.Error{
+				Kind:    auth.ErrForbidden,
+				Message: fmt.Sprintf("cannot grant scope %s, which the caller does not hold", scope),
+				Details: map[string]string{"missing_permission": scope},
+			}
+		}
+	}
+	return nil
+}
+
+// checkExpiry requires an expiry in the future
+func checkExpiry(expiresAt *time.Time, now time.Time) error {
+	if expiresAt != nil && !expiresAt.After(now) {
+		return repository.NewValidationError("expires_at must be in the future", nil)
+	}
+	return nil
+}
+
+// newAPIKey generates a key for the API key with the given ID
+func newAPIKey(id string) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + id + "_" + secret, nil
+}
+
+// apiKeyID extracts the ID of the API key a key belongs to
+func apiKeyID(key string) (string, bool) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	return id, ok && strings.HasPrefix(key, apiKeyPrefix) && id != "" && secret != ""
+}
+
+// hashAPIKey hashes a key for storage. Keys carry 256 random bits, so a plain SHA-256
+// cannot be reversed by guessing and needs no salt or stretching.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
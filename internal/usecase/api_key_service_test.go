@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// withRoles returns a context of a caller holding the given roles
+func withRoles(roles ...string) context.Context {
+	granted := make([]interface{}, len(roles))
+	for i, role := range roles {
+		granted[i] = role
+	}
+	return auth.WithClaims(context.Background(), auth.Claims{"sub": "tester", "roles": granted})
+}
+
+func TestCreateAPIKeyRejectsScopesTheCallerLacks(t *testing.T) {
+	access := auth.DefaultPolicy()
+	access.Roles["key-manager"] = []string{auth.PermAPIKeysManage, auth.PermProductsRead}
+	service := NewAPIKeyService(repository.NewMemoryAPIKeyRepo(), access)
+
+	tests := []struct {
+		name   string
+		roles  []string
+		scopes []string
+		denied bool
+	}{
+		{"scope the caller holds", []string{"key-manager"}, []string{auth.PermProductsRead}, false},
+		{"scope beyond the caller", []string{"key-manager"}, []string{auth.PermProductsDelete}, true},
+		{"wildcard beyond the caller", []string{"key-manager"}, []string{"*"}, true},
+		{"wildcard within the caller", []string{"admin"}, []string{"products:*"}, false},
+		{"global wildcard beyond an admin", []string{"admin"}, []string{"*"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, secret, err := service.CreateAPIKey(withRoles(tt.roles...), entity.APIKey{Name: "importer", Scopes: tt.scopes})
+			if tt.denied {
+				if !errors.Is(err, auth.ErrForbidden) {
+					t.Fatalf("got error %v, want ErrForbidden", err)
+				}
+				return
+			}
+			if err != nil || secret == "" {
+				t.Fatalf("got error %v, want a new key", err)
+			}
+		})
+	}
+}
+
+func TestRotateAPIKeyRequiresTheKeyScopes(t *testing.T) {
+	access := auth.DefaultPolicy()
+	access.Roles["key-manager"] = []string{auth.PermAPIKeysManage}
+	service := NewAPIKeyService(repository.NewMemoryAPIKeyRepo(), access)
+
+	created, _, err := service.CreateAPIKey(withRoles("admin"), entity.APIKey{Name: "importer", Scopes: []string{auth.PermProductsBulk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := service.RotateAPIKey(withRoles("key-manager"), created.ID, created.Rev, nil); !errors.Is(err, auth.ErrForbidden) {
+		t.Fatalf("got error %v, want ErrForbidden", err)
+	}
+	if _, _, err := service.RotateAPIKey(withRoles("admin"), created.ID, created.Rev, nil); err != nil {
+		t.Fatalf("rotation by an admin failed: %v", err)
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	service := NewAPIKeyService(repository.NewMemoryAPIKeyRepo(), nil)
+	created, secret, err := service.CreateAPIKey(context.Background(), entity.APIKey{Name: "importer", Scopes: []string{auth.PermProductsRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := service.VerifyAPIKey(context.Background(), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject() != "api-key:"+created.ID {
+		t.Errorf("got subject %q", claims.Subject())
+	}
+	if _, err := service.VerifyAPIKey(context.Background(), secret+"0"); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("got error %v for a wrong key, want ErrUnauthenticated", err)
+	}
+
+	stored, _ := service.GetAPIKeyById(context.Background(), created.ID)
+	if _, err := service.RevokeAPIKey(context.Background(), created.ID, stored.Rev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.VerifyAPIKey(context.Background(), secret); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("got error %v for a revoked key, want ErrUnauthenticated", err)
+	}
+}
@@ -0,0 +1,29 @@
+package usecase
+
+import (
+	"context"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// AuditService records mutating API calls and exposes the audit log
+type AuditService struct {
+	repo repository.AuditRepository
+}
+
+func NewAuditService(repo repository.AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// RecordAuditEntries appends entries to the audit log
+func (s *AuditService) RecordAuditEntries(ctx context.Context, entries []entity.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.repo.SaveAuditEntries(ctx, entries)
+}
+
+func (s *AuditService) ListAuditEntries(ctx context.Context, q repository.AuditQuery) (*repository.AuditPage, error) {
+	return s.repo.ListAuditEntries(ctx, q)
+}
@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"fmt"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// Conflict resolution strategies accepted by NewConflictStrategy
+const (
+	StrategyLastWriterWins = "last_writer_wins"
+	StrategyMerge          = "merge"
+	StrategyManual         = "manual"
+)
+
+// ConflictStrategy decides the content of a product whose revisions conflict. The
+// result replaces the current revision and the other revisions are discarded.
+type ConflictStrategy interface {
+	Resolve(set repository.ConflictSet) (entity.Product, error)
+}
+
+// NewConflictStrategy returns the strategy with the given name; rev is the revision
+// to keep for manual resolution. An empty name selects last writer wins.
+func NewConflictStrategy(name string, rev string) (ConflictStrategy, error) {
+	switch name {
+	case "", StrategyLastWriterWins:
+		return LastWriterWins{}, nil
+	case StrategyMerge:
+		return FieldMerge{}, nil
+	case StrategyManual:
+		if rev == "" {
+			return nil, repository.NewValidationError("manual resolution requires the revision to keep", nil)
+		}
+		return ManualPick{Rev: rev}, nil
+	default:
+		return nil, repository.NewValidationError(fmt.Sprintf("unknown conflict resolution strategy '%s'", name), nil)
+	}
+}
+
+// LastWriterWins keeps the revision with the latest updated_at. Revisions without a
+// timestamp lose against any with one, and CouchDB's winner wins ties.
+type LastWriterWins struct{}
+
+func (LastWriterWins) Resolve(set repository.ConflictSet) (entity.Product, error) {
+	return latest(versions(set)), nil
+}
+
+// FieldMerge merges the revisions field by field. A field changed by only one revision
+// since the version they diverged from takes that change; a field changed by several
+// takes the value of the latest writer among them. Without a known base version every
+// field is taken from the latest writer.
+type FieldMerge struct{}
+
+func (FieldMerge) Resolve(set repository.ConflictSet) (entity.Product, error) {
+	all := versions(set)
+	merged := latest(all)
+	if set.Base == nil {
+		return merged, nil
+	}
+
+	merged.Name = changedBy(all, func(v entity.Product) bool { return v.Name != set.Base.Name }, *set.Base).Name
+	merged.Price = changedBy(all, func(v entity.Product) bool { return v.Price != set.Base.Price }, *set.Base).Price
+	return merged, nil
+}
+
+// ManualPick keeps the revision chosen by the caller
+type ManualPick struct {
+	Rev string
+}
+
+func (m ManualPick) Resolve(set repository.ConflictSet) (entity.Product, error) {
+	for _, version := range versions(set) {
+		if version.Rev == m.Rev {
+			return version, nil
+		}
+	}
+	return entity.Product{}, repository.NewValidationError(fmt.Sprintf("revision %s is not one of the conflicting revisions", m.Rev), nil)
+}
+
+// versions returns all revisions of a conflict set, CouchDB's winner first
+func versions(set repository.ConflictSet) []entity.Product {
+	return append([]entity.Product{set.Current}, set.Conflicts...)
+}
+
+// latest returns the most recently written version, the first one on ties
+func latest(versions []entity.Product) entity.Product {
+	best := versions[0]
+	for _, version := range versions[1:] {
+		if updatedAt(version).After(updatedAt(best)) {
+			best = version
+		}
+	}
+	return best
+}
+
+// changedBy returns the latest version for which changed holds, or base if there is none
+func changedBy(versions []entity.Product, changed func(entity.Product) bool, base entity.Product) entity.Product {
+	var changers []entity.Product
+	for _, version := range versions {
+		if changed(version) {
+			changers = append(changers, version)
+		}
+	}
+	if len(changers) == 0 {
+		return base
+	}
+	return latest(changers)
+}
+
+func updatedAt(product entity.Product) time.Time {
+	if product.UpdatedAt == nil {
+		return time.Time{}
+	}
+	return *product.UpdatedAt
+}
@@ -0,0 +1,224 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// version returns a product revision written at the given time; a zero time leaves updated_at unset
+func version(rev string, name string, price float64, at time.Time) entity.Product {
+	product := entity.Product{ID: "laptop", Rev: rev, Name: name, Price: price}
+	if !at.IsZero() {
+		product.UpdatedAt = &at
+	}
+	return product
+}
+
+func TestLastWriterWins(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+
+	tests := []struct {
+		name      string
+		current   entity.Product
+		conflicts []entity.Product
+		want      string
+	}{
+		{"later conflict", version("2-a", "A", 1, t0), []entity.Product{version("2-b", "B", 2, t1)}, "2-b"},
+		{"later current", version("2-a", "A", 1, t1), []entity.Product{version("2-b", "B", 2, t0)}, "2-a"},
+		{"tie goes to the current revision", version("2-a", "A", 1, t0), []entity.Product{version("2-b", "B", 2, t0)}, "2-a"},
+		{"conflict without updated_at", version("2-a", "A", 1, t0), []entity.Product{version("2-b", "B", 2, time.Time{})}, "2-a"},
+		{"current without updated_at", version("2-a", "A", 1, time.Time{}), []entity.Product{version("2-b", "B", 2, t0)}, "2-b"},
+		{"none with updated_at", version("2-a", "A", 1, time.Time{}), []entity.Product{version("2-b", "B", 2, time.Time{})}, "2-a"},
+		{"latest of several", version("2-a", "A", 1, t0), []entity.Product{version("2-b", "B", 2, t1), version("2-c", "C", 3, t1.Add(time.Second))}, "2-c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LastWriterWins{}.Resolve(repository.ConflictSet{Current: tt.current, Conflicts: tt.conflicts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Rev != tt.want {
+				t.Errorf("kept revision %s, want %s", got.Rev, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldMerge(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	base := version("1-base", "Laptop", 999, t0.Add(-time.Hour))
+
+	tests := []struct {
+		name      string
+		base      *entity.Product
+		current   entity.Product
+		conflicts []entity.Product
+		wantName  string
+		wantPrice float64
+	}{
+		{
+			"each side changed another field",
+			&base, version("2-a", "Laptop", 1099, t1), []entity.Product{version("2-b", "Laptop Pro", 999, t0)},
+			"Laptop Pro", 1099,
+		},
+		{
+			"both changed the same field, latest writer wins it",
+			&base, version("2-a", "Laptop", 1099, t0), []entity.Product{version("2-b", "Laptop", 1199, t1)},
+			"Laptop", 1199,
+		},
+		{
+			"both changed the same field at the same time",
+			&base, version("2-a", "Laptop", 1099, t0), []entity.Product{version("2-b", "Laptop", 1199, t0)},
+			"Laptop", 1099,
+		},
+		{
+			"changes without updated_at lose to timed ones",
+			&base, version("2-a", "Laptop Air", 999, time.Time{}), []entity.Product{version("2-b", "Laptop Pro", 999, t0)},
+			"Laptop Pro", 999,
+		},
+		{
+			"nothing changed",
+			&base, version("2-a", "Laptop", 999, t0), []entity.Product{version("2-b", "Laptop", 999, t1)},
+			"Laptop", 999,
+		},
+		{
+			"without base the latest writer wins every field",
+			nil, version("2-a", "Laptop", 1099, t1), []entity.Product{version("2-b", "Laptop Pro", 999, t0)},
+			"Laptop", 1099,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FieldMerge{}.Resolve(repository.ConflictSet{Current: tt.current, Conflicts: tt.conflicts, Base: tt.base})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.wantName || got.Price != tt.wantPrice {
+				t.Errorf("got %s at %v, want %s at %v", got.Name, got.Price, tt.wantName, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestManualPick(t *testing.T) {
+	set := repository.ConflictSet{
+		Current:   version("2-a", "Laptop", 999, time.Time{}),
+		Conflicts: []entity.Product{version("2-b", "Laptop Pro", 1299, time.Time{})},
+	}
+	for _, rev := range []string{"2-a", "2-b"} {
+		got, err := ManualPick{Rev: rev}.Resolve(set)
+		if err != nil || got.Rev != rev {
+			t.Errorf("picking %s got %+v, %v", rev, got, err)
+		}
+	}
+	if _, err := (ManualPick{Rev: "2-c"}).Resolve(set); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("got error %v for an unknown revision, want ErrValidation", err)
+	}
+}
+
+func TestNewConflictStrategy(t *testing.T) {
+	tests := []struct {
+		name  string
+		rev   string
+		want  ConflictStrategy
+		valid bool
+	}{
+		{"", "", LastWriterWins{}, true},
+		{StrategyLastWriterWins, "", LastWriterWins{}, true},
+		{StrategyMerge, "", FieldMerge{}, true},
+		{StrategyManual, "2-a", ManualPick{Rev: "2-a"}, true},
+		{StrategyManual, "", nil, false},
+		{"coin_flip", "", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := NewConflictStrategy(tt.name, tt.rev)
+		if !tt.valid {
+			if !errors.Is(err, repository.ErrValidation) {
+				t.Errorf("NewConflictStrategy(%q, %q) got error %v, want ErrValidation", tt.name, tt.rev, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NewConflictStrategy(%q, %q) = %v, %v, want %v", tt.name, tt.rev, got, err, tt.want)
+		}
+	}
+}
+
+// conflictedLaptop creates a product that was updated to a new price while a replica
+// renamed its first revision, and returns the repository and service holding it
+func conflictedLaptop(t *testing.T, rename string) (*repository.MemoryProductRepo, *ProductService) {
+	t.Helper()
+	repo := repository.NewMemoryProductRepo()
+	service := NewProductService(repo)
+	ctx := context.Background()
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateProductById(ctx, "laptop", entity.Product{Rev: created.Rev, Name: "Laptop", Price: 1099}); err != nil {
+		t.Fatal(err)
+	}
+	renamedAt := time.Now().Add(time.Hour)
+	if _, err := repo.AddConflict("laptop", created.Rev, entity.Product{Name: rename, Price: 999, UpdatedAt: &renamedAt}); err != nil {
+		t.Fatal(err)
+	}
+	return repo, service
+}
+
+func TestProductServiceResolveConflicts(t *testing.T) {
+	repo, service := conflictedLaptop(t, "Laptop Pro")
+	ctx := context.Background()
+
+	resolved, err := service.ResolveProductConflicts(ctx, "laptop", FieldMerge{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Name != "Laptop Pro" || resolved.Price != 1099 || len(resolved.Conflicts) != 0 {
+		t.Errorf("got %+v, want the merged product without conflicts", resolved)
+	}
+	set, err := repo.GetProductConflicts(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Conflicts) != 0 {
+		t.Errorf("losing revisions %+v were not discarded", set.Conflicts)
+	}
+
+	// The resolved name is taken and the old one freed
+	if _, err := service.CreateProduct(ctx, entity.Product{ID: "copy", Name: "laptop pro", Price: 1}); !errors.Is(err, repository.ErrDuplicateName) {
+		t.Errorf("got error %v taking the resolved name, want ErrDuplicateName", err)
+	}
+	if _, err := service.CreateProduct(ctx, entity.Product{ID: "other", Name: "Laptop", Price: 1}); err != nil {
+		t.Errorf("old name still taken after the resolution: %v", err)
+	}
+	if _, err := service.ResolveProductConflicts(ctx, "laptop", LastWriterWins{}, nil); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("got error %v resolving a product without conflicts, want ErrValidation", err)
+	}
+}
+
+func TestProductServiceResolveConflictsOntoTakenName(t *testing.T) {
+	repo, service := conflictedLaptop(t, "Phone")
+	ctx := context.Background()
+	if _, err := service.CreateProduct(ctx, entity.Product{ID: "phone", Name: "Phone", Price: 599}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.ResolveProductConflicts(ctx, "laptop", LastWriterWins{}, nil); !errors.Is(err, repository.ErrDuplicateName) {
+		t.Fatalf("got error %v resolving onto a taken name, want ErrDuplicateName", err)
+	}
+	set, err := repo.GetProductConflicts(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Current.Name != "Laptop" || len(set.Conflicts) != 1 {
+		t.Errorf("got %+v, want the product and its conflict unchanged", set)
+	}
+}
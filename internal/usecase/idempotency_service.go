@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// IdempotencyConfig sets how long responses are kept for replays, how long a pending
+// request locks its key and how often expired responses are removed
+type IdempotencyConfig struct {
+	TTL           time.Duration
+	LockTimeout   time.Duration
+	PurgeInterval time.Duration
+}
+
+// DefaultIdempotencyConfig keeps responses for a day and removes expired ones hourly
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL:           24 * time.Hour,
+		LockTimeout:   time.Minute,
+		PurgeInterval: time.Hour,
+	}
+}
+
+// IdempotencyService records the responses to requests sent with an idempotency key
+// and replays them to retries of the same request
+type IdempotencyService struct {
+	repo repository.IdempotencyRepository
+	cfg  IdempotencyConfig
+}
+
+func NewIdempotencyService(repo repository.IdempotencyRepository, cfg IdempotencyConfig) *IdempotencyService {
+	return &IdempotencyService{repo: repo, cfg: cfg}
+}
+
+// BeginRequest claims an idempotency key of a client for a request identified by its
+// fingerprint. It returns a pending record if the request should run, or the completed
+// record of an earlier run to replay. It fails with ErrKeyReused if the key was used for
+// a different request and with ErrInProgress while the earlier run is still pending.
+// Expired records, and pending ones whose lock timed out, are taken over.
+func (s *IdempotencyService) BeginRequest(ctx context.Context, client string, key string, fingerprint string) (*entity.IdempotencyRecord, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	record := entity.IdempotencyRecord{
+		ID:          idempotencyID(client, key),
+		Fingerprint: fingerprint,
+		State:       entity.IdempotencyPending,
+		CreatedAt:   now,
+		LockedUntil: now.Add(s.cfg.LockTimeout),
+		ExpiresAt:   now.Add(s.cfg.TTL),
+	}
+
+	created, err := s.repo.CreateIdempotencyRecord(ctx, record)
+	if err == nil {
+		return created, nil
+	}
+	if !errors.Is(err, repository.ErrRevisionConflict) {
+		return nil, err
+	}
+
+	existing, err := s.repo.GetIdempotencyRecord(ctx, record.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) { // Abandoned in the meantime
+			return nil, repository.NewInProgressError(key)
+		}
+		return nil, err
+	}
+	expired := !now.Before(existing.ExpiresAt)
+	switch {
+	case !expired && existing.Fingerprint != fingerprint:
+		return nil, repository.NewKeyReusedError(key)
+	case existing.Completed() && !expired:
+		return existing, nil
+	case !existing.Completed() && !expired && now.Before(existing.LockedUntil):
+		return nil, repository.NewInProgressError(key)
+	}
+
+	// The revision check makes the takeover atomic against a concurrent one
+	record.Rev = existing.Rev
+	taken, err := s.repo.UpdateIdempotencyRecord(ctx, record)
+	if err != nil {
+		if errors.Is(err, repository.ErrRevisionConflict) {
+			return nil, repository.NewInProgressError(key)
+		}
+		return nil, err
+	}
+	return taken, nil
+}
+
+// CompleteRequest stores the response to a request claimed with BeginRequest
+func (s *IdempotencyService) CompleteRequest(ctx context.Context, record entity.IdempotencyRecord, status int, header map[string]string, body []byte) error {
+	record.State = entity.IdempotencyCompleted
+	record.StatusCode = status
+	record.Header = header
+	record.Body = string(body)
+	_, err := s.repo.UpdateIdempotencyRecord(ctx, record)
+	return err
+}
+
+// AbandonRequest frees the key of a request claimed with BeginRequest that did not get a
+// response worth replaying, so that a retry runs it again
+func (s *IdempotencyService) AbandonRequest(ctx context.Context, record entity.IdempotencyRecord) error {
+	return s.repo.DeleteIdempotencyRecord(ctx, record.ID, record.Rev)
+}
+
+// Run removes expired records every purge interval until ctx is cancelled
+func (s *IdempotencyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.repo.PurgeIdempotencyRecords(ctx, time.Now()); err != nil {
+			log.Println("Failed to purge idempotency records:", err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired idempotency records", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// idempotencyID derives the record ID of a client's idempotency key. Hashing keeps
+// arbitrary keys out of document IDs.
+func idempotencyID(client string, key string) string {
+	sum := sha256.Sum256([]byte(client + "\n" + key))
+	return "idempotency:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,115 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+func newTestIdempotencyService(cfg IdempotencyConfig) (*IdempotencyService, *repository.MemoryIdempotencyRepo) {
+	repo := repository.NewMemoryIdempotencyRepo()
+	return NewIdempotencyService(repo, cfg), repo
+}
+
+func TestIdempotencyServiceReplaysCompletedRequests(t *testing.T) {
+	service, _ := newTestIdempotencyService(DefaultIdempotencyConfig())
+	ctx := context.Background()
+
+	record, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Completed() {
+		t.Fatal("first request got a completed record")
+	}
+	header := map[string]string{"Location": "/api/v1/products/laptop"}
+	if err := service.CompleteRequest(ctx, *record, http.StatusCreated, header, []byte(`{"product":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replay.Completed() || replay.StatusCode != http.StatusCreated || replay.Header["Location"] != header["Location"] || replay.Body != `{"product":{}}` {
+		t.Errorf("got %+v, want the stored response", replay)
+	}
+
+	if _, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create phone"); !errors.Is(err, repository.ErrKeyReused) {
+		t.Errorf("got error %v for another request with the key, want ErrKeyReused", err)
+	}
+}
+
+func TestIdempotencyServiceLocksPendingRequests(t *testing.T) {
+	service, _ := newTestIdempotencyService(IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Hour})
+	ctx := context.Background()
+
+	record, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create laptop"); !errors.Is(err, repository.ErrInProgress) {
+		t.Fatalf("got error %v for a retry while pending, want ErrInProgress", err)
+	}
+
+	// An abandoned request runs again on retry
+	if err := service.AbandonRequest(ctx, *record); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Completed() {
+		t.Errorf("got %+v after abandoning, want a pending record", retried)
+	}
+}
+
+func TestIdempotencyServiceTakesOverTimedOutAndExpiredRecords(t *testing.T) {
+	service, repo := newTestIdempotencyService(DefaultIdempotencyConfig())
+	ctx := context.Background()
+	past := time.Now().UTC().Add(-time.Hour)
+
+	// A pending request whose lock timed out, e.g. because its server crashed
+	if _, err := repo.CreateIdempotencyRecord(ctx, entity.IdempotencyRecord{
+		ID: idempotencyID("sub:alice", "stuck"), Fingerprint: "create laptop", State: entity.IdempotencyPending,
+		CreatedAt: past, LockedUntil: past, ExpiresAt: time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := service.BeginRequest(ctx, "sub:alice", "stuck", "create laptop"); err != nil || record.Completed() {
+		t.Errorf("got %+v, %v for a timed out lock, want a pending record", record, err)
+	}
+
+	// An expired response no longer binds the key to its request
+	if _, err := repo.CreateIdempotencyRecord(ctx, entity.IdempotencyRecord{
+		ID: idempotencyID("sub:alice", "old"), Fingerprint: "create laptop", State: entity.IdempotencyCompleted,
+		StatusCode: http.StatusCreated, CreatedAt: past, LockedUntil: past, ExpiresAt: past,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := service.BeginRequest(ctx, "sub:alice", "old", "create phone"); err != nil || record.Completed() || record.Fingerprint != "create phone" {
+		t.Errorf("got %+v, %v for an expired key, want a pending record of the new request", record, err)
+	}
+}
+
+func TestIdempotencyServiceScopesKeysPerClient(t *testing.T) {
+	service, _ := newTestIdempotencyService(DefaultIdempotencyConfig())
+	ctx := context.Background()
+
+	if _, err := service.BeginRequest(ctx, "sub:alice", "key-1", "create laptop"); err != nil {
+		t.Fatal(err)
+	}
+	record, err := service.BeginRequest(ctx, "sub:bob", "key-1", "create phone")
+	if err != nil {
+		t.Fatalf("another client's key is taken: %v", err)
+	}
+	if record.Completed() {
+		t.Errorf("got %+v for another client, want a pending record", record)
+	}
+}
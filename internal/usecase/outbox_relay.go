@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// EventPublisher delivers domain events to their consumers. Adapters live in the
+// publisher package.
+type EventPublisher interface {
+	Publish(ctx context.Context, event entity.DomainEvent) error
+}
+
+// OutboxRelayConfig tunes how often and how much the relay reads from the outbox
+type OutboxRelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func DefaultOutboxRelayConfig() OutboxRelayConfig {
+	return OutboxRelayConfig{
+		PollInterval: time.Second,
+		BatchSize:    100,
+	}
+}
+
+// OutboxRelay publishes the domain events stored in the outbox and marks them dispatched.
+// Events are published in the order they occurred; a failed event is retried on the next
+// poll before any later event is published. Delivery is at least once.
+type OutboxRelay struct {
+	outbox    repository.OutboxRepository
+	publisher EventPublisher
+	cfg       OutboxRelayConfig
+}
+
+func NewOutboxRelay(outbox repository.OutboxRepository, publisher EventPublisher, cfg OutboxRelayConfig) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:    outbox,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// Run relays events until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while full batches come back
+		for ctx.Err() == nil {
+			n, err := r.RelayPending(ctx)
+			if err != nil {
+				log.Println("Failed to relay domain events:", err)
+				break
+			}
+			if n < r.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes one batch of pending events and returns how many were read.
+// It stops at the first event that cannot be published.
+func (r *OutboxRelay) RelayPending(ctx context.Context) (int, error) {
+	events, err := r.outbox.PendingEvents(ctx, r.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			if markErr := r.outbox.MarkFailed(ctx, event, err); markErr != nil {
+				log.Println("Failed to record domain event failure:", event.ID, markErr)
+			}
+			return len(events), err
+		}
+		if err := r.outbox.MarkDispatched(ctx, event); err != nil {
+			// The event was published; it is published again on the next poll
+			return len(events), err
+		}
+	}
+	return len(events), nil
+}
@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/publisher"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// relayAll relays batches until the outbox is empty
+func relayAll(t *testing.T, relay *OutboxRelay) {
+	t.Helper()
+	for {
+		n, err := relay.RelayPending(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func TestOutboxRelayPublishesProductEventsInOrder(t *testing.T) {
+	ctx := context.Background()
+	service := NewProductService(repository.NewMemoryProductRepo())
+	repo := service.repo.(*repository.MemoryProductRepo)
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateProductById(ctx, "laptop", entity.Product{Rev: created.Rev, Name: "Laptop Pro", Price: 1299}); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := service.GetProductById(ctx, "laptop")
+	if err := service.DeleteProductById(ctx, "laptop", updated.Rev); err != nil {
+		t.Fatal(err)
+	}
+	trashed, _ := service.GetTrashedProductById(ctx, "laptop")
+	if _, err := service.RestoreProductById(ctx, "laptop", trashed.Rev); err != nil {
+		t.Fatal(err)
+	}
+
+	events := publisher.NewMemory()
+	relayAll(t, NewOutboxRelay(repo, events, OutboxRelayConfig{BatchSize: 2}))
+
+	want := []string{
+		entity.EventNameProductCreated,
+		entity.EventNameProductRenamed,
+		entity.EventNameProductPriceChanged,
+		entity.EventNameProductDeleted,
+		entity.EventNameProductRestored,
+	}
+	published := events.Events()
+	if len(published) != len(want) {
+		t.Fatalf("published %d events, want %d: %+v", len(published), len(want), published)
+	}
+	for i, event := range published {
+		if event.Event != want[i] || event.AggregateID != "laptop" {
+			t.Errorf("event %d is %s of %s, want %s of laptop", i, event.Event, event.AggregateID, want[i])
+		}
+	}
+
+	var renamed struct {
+		OldName string `json:"old_name"`
+		NewName string `json:"new_name"`
+	}
+	if err := json.Unmarshal(published[1].Payload, &renamed); err != nil {
+		t.Fatal(err)
+	}
+	if renamed.OldName != "Laptop" || renamed.NewName != "Laptop Pro" {
+		t.Errorf("got rename payload %+v", renamed)
+	}
+
+	if pending, _ := repo.PendingEvents(ctx, 10); len(pending) != 0 {
+		t.Errorf("%d events left pending after relaying", len(pending))
+	}
+}
+
+func TestOutboxRelayRetriesFailedEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryProductRepo()
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateProduct(ctx, entity.Product{ID: "mouse", Name: "Mouse", Price: 25}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := publisher.NewMemory()
+	events.SetErr(errors.New("broker unavailable"))
+	relay := NewOutboxRelay(repo, events, OutboxRelayConfig{BatchSize: 10})
+	if _, err := relay.RelayPending(ctx); err == nil {
+		t.Fatal("relaying to a failing publisher succeeded")
+	}
+
+	// The failed event stays first in line, with the failure recorded
+	pending, err := repo.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].AggregateID != "laptop" || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("got pending events %+v", pending)
+	}
+	if pending[1].Attempts != 0 {
+		t.Errorf("event after the failed one was attempted")
+	}
+
+	events.SetErr(nil)
+	relayAll(t, relay)
+	published := events.Events()
+	if len(published) != 2 || published[0].AggregateID != "laptop" || published[1].AggregateID != "mouse" {
+		t.Errorf("got published events %+v", published)
+	}
+}
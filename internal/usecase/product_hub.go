@@ -0,0 +1,244 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// subscriberBuffer is the number of events queued per subscriber before it counts as too slow
+const subscriberBuffer = 64
+
+// followRetryDelay is the pause before the shared change feed is reopened after a failure
+const followRetryDelay = 2 * time.Second
+
+// ErrSlowSubscriber ends a subscription whose queue overflowed. Dropping it keeps one
+// slow consumer from stalling the feed for everybody else; the client should reconnect.
+var ErrSlowSubscriber = errors.New("subscriber too slow, events dropped")
+
+// ProductFilter selects the product changes a subscription receives. Empty criteria
+// match everything. Deletions carry no document, so they always pass the price range.
+type ProductFilter struct {
+	ProductIDs []string `json:"product_ids,omitempty"`
+	MinPrice   *float64 `json:"min_price,omitempty"`
+	MaxPrice   *float64 `json:"max_price,omitempty"`
+}
+
+// Validate checks that the filter is well formed
+func (f ProductFilter) Validate() error {
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		return repository.NewValidationError("min_price must not be greater than max_price", nil)
+	}
+	return nil
+}
+
+// Matches reports whether a change passes the filter
+func (f ProductFilter) Matches(change repository.ProductChange) bool {
+	if len(f.ProductIDs) > 0 {
+		found := false
+		for _, id := range f.ProductIDs {
+			if id == change.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if change.Product == nil {
+		return true
+	}
+	if f.MinPrice != nil && change.Product.Price < *f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != nil && change.Product.Price > *f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// HubEvent is a change delivered to a subscription, with the IDs of its filters that matched
+type HubEvent struct {
+	Filters []string
+	Change  repository.ProductChange
+}
+
+// Subscription receives the product changes matching any of its named filters
+type Subscription struct {
+	events chan HubEvent
+	done   chan struct{}
+
+	mu      sync.Mutex
+	filters map[string]ProductFilter
+	err     error
+}
+
+// Events returns the queue of matching changes
+func (s *Subscription) Events() <-chan HubEvent {
+	return s.events
+}
+
+// Done is closed when the hub ends the subscription
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the hub ended the subscription
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// SetFilter adds or replaces a named filter
+func (s *Subscription) SetFilter(id string, filter ProductFilter) error {
+	if id == "" {
+		return repository.NewValidationError("subscription ID is required", nil)
+	}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters[id] = filter
+	return nil
+}
+
+// RemoveFilter removes a named filter, reporting whether it existed
+func (s *Subscription) RemoveFilter(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.filters[id]
+	delete(s.filters, id)
+	return ok
+}
+
+// match returns the IDs of the filters a change passes
+func (s *Subscription) match(change repository.ProductChange) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, filter := range s.filters {
+		if filter.Matches(change) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// end closes the subscription with a reason. Callers must hold the hub lock.
+func (s *Subscription) end(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// ProductHub fans a single shared product change feed out to many subscribers.
+// The feed is followed only while at least one subscription is open.
+type ProductHub struct {
+	repo repository.ProductRepository
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	cancel context.CancelFunc
+}
+
+// NewProductHub creates a hub following the change feed of the given repository
+func NewProductHub(repo repository.ProductRepository) *ProductHub {
+	return &ProductHub{
+		repo: repo,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe opens a subscription without filters; it receives nothing until one is set
+func (h *ProductHub) Subscribe() *Subscription {
+	sub := &Subscription{
+		events:  make(chan HubEvent, subscriberBuffer),
+		done:    make(chan struct{}),
+		filters: make(map[string]ProductFilter),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel = cancel
+		go h.follow(ctx)
+	}
+	return sub
+}
+
+// Unsubscribe closes a subscription and stops following the feed once none are left
+func (h *ProductHub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub, nil)
+}
+
+// remove ends a subscription. Callers must hold the lock.
+func (h *ProductHub) remove(sub *Subscription, err error) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	sub.end(err)
+	if len(h.subs) == 0 && h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// follow reads the shared change feed until ctx is cancelled, reopening it from the
+// last seen sequence when it fails
+func (h *ProductHub) follow(ctx context.Context) {
+	since := repository.SinceNow
+	for ctx.Err() == nil {
+		feed, err := h.repo.WatchProducts(ctx, since)
+		if err != nil {
+			log.Println("Failed to follow product changes:", err)
+		} else {
+			for feed.Next() {
+				change := feed.Change()
+				since = change.Seq
+				h.broadcast(change)
+			}
+			if err := feed.Err(); err != nil {
+				log.Println("Product change feed failed:", err)
+			}
+			feed.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(followRetryDelay):
+		}
+	}
+}
+
+// broadcast queues a change for every matching subscription without blocking. A
+// subscription whose queue is full is dropped with ErrSlowSubscriber.
+func (h *ProductHub) broadcast(change repository.ProductChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		filters := sub.match(change)
+		if len(filters) == 0 {
+			continue
+		}
+		select {
+		case sub.events <- HubEvent{Filters: filters, Change: change}:
+		default:
+			log.Println("Dropping slow product change subscriber")
+			h.remove(sub, fmt.Errorf("%w (%d queued)", ErrSlowSubscriber, subscriberBuffer))
+		}
+	}
+}
@@ -1,43 +1,312 @@
-package usecase
-
-import (
-	"context"
-	"e-learning/go-with-couchdb/internal/entity"
-	"e-learning/go-with-couchdb/internal/repository"
-)
-
-type ProductService struct {
-	repo *repository.ProductRepo
-}
-
-func NewProductService(repo *repository.ProductRepo) *ProductService {
-	return &ProductService{repo: repo}
-}
-
-func (s *ProductService) CreateProduct(ctx context.Context, product entity.Product) error {
-	return s.repo.CreateProduct(ctx, product)
-}
-
-func (s *ProductService) GetAllProducts(ctx context.Context) ([]entity.Product, error) {
-	return s.repo.GetAllProducts(ctx)
-}
-
-func (s *ProductService) GetProductById(ctx context.Context, id string) (*entity.Product, error) {
-	return s.repo.GetProductById(ctx, id)
-}
-
-func (s *ProductService) UpdateProductById(ctx context.Context, id string, product entity.Product) error {
-	return s.repo.UpdateProductById(ctx, id, product)
-}
-
-func (s *ProductService) DeleteProductById(ctx context.Context, id string, rev string) error {
-	return s.repo.DeleteProductById(ctx, id, rev)
-}
-
-func (s *ProductService) BulkCreateProducts(ctx context.Context, products []entity.Product) error {
-	return s.repo.BulkCreateProducts(ctx, products)
-}
-
-func (s *ProductService) BulkUpdateProducts(ctx context.Context, products []entity.Product) error {
-	return s.repo.BulkUpdateProducts(ctx, products)
-}
\ No newline at end of file
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"e-learning/go-with-couchdb/internal/audit"
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+type ProductService struct {
+	repo repository.ProductRepository
+	hub  *ProductHub
+}
+
+func NewProductService(repo repository.ProductRepository) *ProductService {
+	return &ProductService{repo: repo, hub: NewProductHub(repo)}
+}
+
+func (s *ProductService) CreateProduct(ctx context.Context, product entity.Product) (*entity.Product, error) {
+	created, err := s.repo.CreateProduct(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	audit.Record(ctx, entity.AuditProductCreated, created.ID, nil, created)
+	return created, nil
+}
+
+func (s *ProductService) GetAllProducts(ctx context.Context, opts repository.ListOptions) (*repository.ProductPage, error) {
+	return s.repo.GetAllProducts(ctx, opts)
+}
+
+func (s *ProductService) SearchProducts(ctx context.Context, q repository.ProductQuery) (*repository.SearchPage, error) {
+	return s.repo.SearchProducts(ctx, q)
+}
+
+func (s *ProductService) GetProductById(ctx context.Context, id string) (*entity.Product, error) {
+	return s.repo.GetProductById(ctx, id)
+}
+
+// UpdateProductById replaces the name and price of a product and returns the stored
+// product. product.Rev must be the current revision.
+func (s *ProductService) UpdateProductById(ctx context.Context, id string, product entity.Product) (*entity.Product, error) {
+	if err := s.repo.UpdateProductById(ctx, id, product); err != nil {
+		return nil, err
+	}
+	updated, err := s.repo.GetProductById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChange(ctx, entity.AuditProductUpdated, id, product.Rev, updated)
+	return updated, nil
+}
+
+func (s *ProductService) DeleteProductById(ctx context.Context, id string, rev string) error {
+	if err := s.repo.DeleteProductById(ctx, id, rev); err != nil {
+		return err
+	}
+	if audit.Enabled(ctx) {
+		trashed, err := s.repo.GetTrashedProductById(ctx, id)
+		if err != nil {
+			log.Println("Failed to read deleted product for the audit log:", id, err)
+		}
+		s.recordChange(ctx, entity.AuditProductDeleted, id, rev, trashed)
+	}
+	return nil
+}
+
+func (s *ProductService) BulkCreateProducts(ctx context.Context, products []entity.Product, opts repository.BulkOptions) ([]repository.BulkItemResult, error) {
+	results, err := s.repo.BulkCreateProducts(ctx, products, opts)
+	for _, result := range results {
+		if result.Err == nil && result.Product != nil {
+			audit.Record(ctx, entity.AuditProductCreated, result.ID, nil, result.Product)
+		}
+	}
+	return results, err
+}
+
+func (s *ProductService) BulkUpdateProducts(ctx context.Context, products []entity.Product, opts repository.BulkOptions) ([]repository.BulkItemResult, error) {
+	results, err := s.repo.BulkUpdateProducts(ctx, products, opts)
+	if !audit.Enabled(ctx) {
+		return results, err
+	}
+	// Audit the stored products rather than the request items
+	for _, result := range results {
+		if result.Err != nil || result.Index >= len(products) {
+			continue
+		}
+		updated, getErr := s.repo.GetProductById(ctx, result.ID)
+		if getErr != nil {
+			log.Println("Failed to read updated product for the audit log:", result.ID, getErr)
+			continue
+		}
+		s.recordChange(ctx, entity.AuditProductUpdated, result.ID, products[result.Index].Rev, updated)
+	}
+	return results, err
+}
+
+func (s *ProductService) ListTrash(ctx context.Context, opts repository.ListOptions) (*repository.ProductPage, error) {
+	return s.repo.ListTrash(ctx, opts)
+}
+
+func (s *ProductService) GetTrashedProductById(ctx context.Context, id string) (*entity.Product, error) {
+	return s.repo.GetTrashedProductById(ctx, id)
+}
+
+func (s *ProductService) RestoreProductById(ctx context.Context, id string, rev string) (*entity.Product, error) {
+	restored, err := s.repo.RestoreProductById(ctx, id, rev)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChange(ctx, entity.AuditProductRestored, id, rev, restored)
+	return restored, nil
+}
+
+func (s *ProductService) GetProductHistory(ctx context.Context, id string, opts repository.ListOptions) (*repository.HistoryPage, error) {
+	return s.repo.GetProductHistory(ctx, id, opts)
+}
+
+// RevertProductById writes the name and price of an earlier version of a product as a new
+// version. currentRev must be the current revision; the reverted product is returned.
+func (s *ProductService) RevertProductById(ctx context.Context, id string, toRev string, currentRev string) (*entity.Product, error) {
+	if toRev == "" {
+		return nil, repository.NewValidationError("the revision to revert to is required", nil)
+	}
+	if toRev == currentRev {
+		return nil, repository.NewValidationError(fmt.Sprintf("revision %s is already the current version", toRev), nil)
+	}
+
+	version, err := s.repo.GetProductVersion(ctx, id, toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := entity.Product{ID: id, Rev: currentRev, Name: version.Name, Price: version.Price}
+	if err := s.repo.UpdateProductById(ctx, id, reverted); err != nil {
+		return nil, err
+	}
+	updated, err := s.repo.GetProductById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChange(ctx, entity.AuditProductReverted, id, currentRev, updated)
+	return updated, nil
+}
+
+// recordChange records a write that replaced revision rev of a product in the audit
+// trail of the request. The replaced version is read back from the product history.
+func (s *ProductService) recordChange(ctx context.Context, action string, id string, rev string, after *entity.Product) {
+	if !audit.Enabled(ctx) {
+		return
+	}
+	before, err := s.repo.GetProductVersion(ctx, id, rev)
+	if err != nil {
+		log.Println("Failed to read replaced product version for the audit log:", id, rev, err)
+	}
+	audit.Record(ctx, action, id, before, after)
+}
+
+func (s *ProductService) ListConflicts(ctx context.Context, opts repository.ListOptions) (*repository.ProductPage, error) {
+	return s.repo.ListConflicts(ctx, opts)
+}
+
+func (s *ProductService) GetProductWithConflicts(ctx context.Context, id string) (*entity.Product, error) {
+	return s.repo.GetProductWithConflicts(ctx, id)
+}
+
+// ResolveProductConflicts resolves the conflicting revisions of a product with the given
+// strategy: its result becomes the current revision and the other revisions are discarded.
+// precondition checks the current revision, e.g. against If-Match, and may be nil.
+func (s *ProductService) ResolveProductConflicts(ctx context.Context, id string, strategy ConflictStrategy, precondition func(currentRev string) error) (*entity.Product, error) {
+	set, err := s.repo.GetProductConflicts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(set.Conflicts) == 0 {
+		return nil, repository.NewValidationError(fmt.Sprintf("product %s has no conflicts", id), nil)
+	}
+	if precondition != nil {
+		if err := precondition(set.Current.Rev); err != nil {
+			return nil, err
+		}
+	}
+
+	resolved, err := strategy.Resolve(*set)
+	if err != nil {
+		return nil, err
+	}
+
+	// The resolved content is written on top of the current revision unless it already matches
+	if resolved.Name != set.Current.Name || resolved.Price != set.Current.Price {
+		update := entity.Product{ID: id, Rev: set.Current.Rev, Name: resolved.Name, Price: resolved.Price}
+		if err := s.repo.UpdateProductById(ctx, id, update); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.DiscardConflicts(ctx, id, set.Conflicts); err != nil {
+		return nil, err
+	}
+
+	product, err := s.repo.GetProductWithConflicts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	audit.Record(ctx, entity.AuditProductResolved, id, &set.Current, product)
+	return product, nil
+}
+
+func (s *ProductService) WatchProducts(ctx context.Context, since string) (repository.ProductChangeFeed, error) {
+	return s.repo.WatchProducts(ctx, since)
+}
+
+// SubscribeChanges opens a subscription on the shared product change feed
+func (s *ProductService) SubscribeChanges() *Subscription {
+	return s.hub.Subscribe()
+}
+
+// UnsubscribeChanges closes a subscription opened with SubscribeChanges
+func (s *ProductService) UnsubscribeChanges(sub *Subscription) {
+	s.hub.Unsubscribe(sub)
+}
+
+// maxPatchAttempts bounds the read-patch-write retries on revision conflicts
+const maxPatchAttempts = 5
+
+// ProductPatch describes a partial update applied to the stored product document
+type ProductPatch struct {
+	// Apply transforms the JSON of the stored product into the patched JSON
+	Apply func(doc []byte) ([]byte, error)
+	// Precondition checks the current revision, e.g. against If-Match. It reports
+	// whether the request is conditional; conditional patches are never retried.
+	Precondition func(currentRev string) (bool, error)
+	// Validate checks the patched product before it is saved
+	Validate func(product entity.Product) error
+}
+
+// PatchProductById applies a patch to the stored product and saves it with revision checking.
+// Unconditional patches are re-applied to the latest revision when a concurrent write wins.
+func (s *ProductService) PatchProductById(ctx context.Context, id string, patch ProductPatch) (*entity.Product, error) {
+	for attempt := 1; ; attempt++ {
+		existing, err := s.repo.GetProductById(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		conditional := false
+		if patch.Precondition != nil {
+			if conditional, err = patch.Precondition(existing.Rev); err != nil {
+				return nil, err
+			}
+		}
+
+		patched, err := applyPatch(*existing, patch)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.repo.UpdateProductById(ctx, id, patched)
+		if err == nil {
+			updated, err := s.repo.GetProductById(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			s.recordChange(ctx, entity.AuditProductUpdated, id, existing.Rev, updated)
+			return updated, nil
+		}
+		if !errors.Is(err, repository.ErrRevisionConflict) {
+			return nil, err
+		}
+		if conditional {
+			return nil, repository.NewPreconditionFailedError("the product was modified concurrently", err)
+		}
+		if attempt == maxPatchAttempts {
+			log.Println("Giving up patching product after repeated conflicts:", id)
+			return nil, err
+		}
+	}
+}
+
+// applyPatch runs the patch against the JSON form of a product and validates the result
+func applyPatch(existing entity.Product, patch ProductPatch) (entity.Product, error) {
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		return entity.Product{}, fmt.Errorf("failed to encode product: %w", err)
+	}
+
+	patchedDoc, err := patch.Apply(doc)
+	if err != nil {
+		return entity.Product{}, repository.NewValidationError("Invalid patch: "+err.Error(), nil)
+	}
+
+	var patched entity.Product
+	if err := json.Unmarshal(patchedDoc, &patched); err != nil {
+		return entity.Product{}, repository.NewValidationError("Patched document is not a valid product: "+err.Error(), nil)
+	}
+	if patched.ID != existing.ID {
+		return entity.Product{}, repository.NewValidationError("_id cannot be changed", nil)
+	}
+	// The revision is managed by the service, whatever the patch did to it
+	patched.Rev = existing.Rev
+
+	if patch.Validate != nil {
+		if err := patch.Validate(patched); err != nil {
+			return entity.Product{}, err
+		}
+	}
+	return patched, nil
+}
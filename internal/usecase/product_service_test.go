@@ -0,0 +1,222 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/audit"
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+func newTestProductService() *ProductService {
+	return NewProductService(repository.NewMemoryProductRepo())
+}
+
+func TestProductServiceCreateAndGet(t *testing.T) {
+	service := newTestProductService()
+	ctx := context.Background()
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Rev == "" {
+		t.Error("created product has no revision")
+	}
+
+	got, err := service.GetProductById(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Laptop" || got.Price != 999 || got.Rev != created.Rev {
+		t.Errorf("got %+v, want the created product", got)
+	}
+
+	if _, err := service.CreateProduct(ctx, entity.Product{ID: "other", Name: "laptop", Price: 1}); !errors.Is(err, repository.ErrDuplicateName) {
+		t.Errorf("got error %v for a duplicate name, want ErrDuplicateName", err)
+	}
+	if _, err := service.GetProductById(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("got error %v for a missing product, want ErrNotFound", err)
+	}
+}
+
+func TestProductServiceUpdateChecksRevision(t *testing.T) {
+	service := newTestProductService()
+	ctx := context.Background()
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateProductById(ctx, "laptop", entity.Product{Rev: created.Rev, Name: "Laptop Pro", Price: 1299}); err != nil {
+		t.Fatal(err)
+	}
+	// The first revision is stale now
+	_, err = service.UpdateProductById(ctx, "laptop", entity.Product{Rev: created.Rev, Name: "Laptop Air", Price: 899})
+	if !errors.Is(err, repository.ErrRevisionConflict) {
+		t.Fatalf("got error %v for a stale revision, want ErrRevisionConflict", err)
+	}
+
+	got, err := service.GetProductById(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Laptop Pro" || got.Price != 1299 {
+		t.Errorf("got %+v, want the first update", got)
+	}
+}
+
+func TestProductServiceDeleteAndRestore(t *testing.T) {
+	service := newTestProductService()
+	ctx := context.Background()
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteProductById(ctx, "laptop", created.Rev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.GetProductById(ctx, "laptop"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("got error %v after delete, want ErrNotFound", err)
+	}
+
+	trashed, err := service.GetTrashedProductById(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trashed.Trashed() {
+		t.Fatal("deleted product is not marked as trashed")
+	}
+	restored, err := service.RestoreProductById(ctx, "laptop", trashed.Rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Trashed() || restored.Name != "Laptop" {
+		t.Errorf("got %+v, want the restored product", restored)
+	}
+}
+
+func TestProductServicePatchRetriesUnconditionalConflicts(t *testing.T) {
+	service := newTestProductService()
+	ctx := context.Background()
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A concurrent update lands between the first read and write of the patch
+	raced := false
+	patched, err := service.PatchProductById(ctx, "laptop", ProductPatch{
+		Apply: func(doc []byte) ([]byte, error) {
+			if !raced {
+				raced = true
+				if _, err := service.UpdateProductById(ctx, "laptop", entity.Product{Rev: created.Rev, Name: "Laptop Pro", Price: 999}); err != nil {
+					return nil, err
+				}
+			}
+			return mergePrice(doc, 1099)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Name != "Laptop Pro" || patched.Price != 1099 {
+		t.Errorf("got %+v, want the patch applied on top of the concurrent update", patched)
+	}
+}
+
+func TestProductServiceConditionalPatchFailsOnConflict(t *testing.T) {
+	service := newTestProductService()
+	ctx := context.Background()
+
+	created, err := service.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = service.PatchProductById(ctx, "laptop", ProductPatch{
+		Apply: func(doc []byte) ([]byte, error) {
+			if _, err := service.UpdateProductById(ctx, "laptop", entity.Product{Rev: created.Rev, Name: "Laptop Pro", Price: 999}); err != nil {
+				return nil, err
+			}
+			return mergePrice(doc, 1099)
+		},
+		Precondition: func(currentRev string) (bool, error) { return true, nil },
+	})
+	if !errors.Is(err, repository.ErrPrecondition) {
+		t.Fatalf("got error %v, want ErrPrecondition", err)
+	}
+}
+
+// mergePrice sets the price of a product document
+func mergePrice(doc []byte, price float64) ([]byte, error) {
+	var product entity.Product
+	if err := json.Unmarshal(doc, &product); err != nil {
+		return nil, err
+	}
+	product.Price = price
+	return json.Marshal(product)
+}
+
+// auditedChanges runs fn with an audit trail and returns the changes it recorded
+func auditedChanges(fn func(ctx context.Context)) []audit.Change {
+	trail := &audit.Trail{}
+	fn(audit.WithTrail(context.Background(), trail))
+	return trail.Changes()
+}
+
+func TestProductServicePatchRecordsTheStoredChange(t *testing.T) {
+	service := newTestProductService()
+	if _, err := service.CreateProduct(context.Background(), entity.Product{ID: "laptop", Name: "Laptop", Price: 999}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := auditedChanges(func(ctx context.Context) {
+		_, err := service.PatchProductById(ctx, "laptop", ProductPatch{
+			Apply: func(doc []byte) ([]byte, error) { return mergePrice(doc, 1099) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(changes) != 1 || changes[0].Action != entity.AuditProductUpdated {
+		t.Fatalf("got changes %+v, want one update", changes)
+	}
+	price := changes[0].Changes["price"]
+	if price.Before != 999.0 || price.After != 1099.0 || len(changes[0].Changes) != 1 {
+		t.Errorf("got field changes %+v, want only the price from 999 to 1099", changes[0].Changes)
+	}
+}
+
+func TestProductServiceUpdateRecordsTheStoredProduct(t *testing.T) {
+	service := newTestProductService()
+	created, err := service.CreateProduct(context.Background(), entity.Product{ID: "laptop", Name: "Laptop", Price: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The request body names a different ID and claims a deletion; neither is stored
+	deletedAt := time.Now()
+	var updated *entity.Product
+	changes := auditedChanges(func(ctx context.Context) {
+		updated, err = service.UpdateProductById(ctx, "laptop", entity.Product{ID: "other", Rev: created.Rev, Name: "Laptop Pro", Price: 999, DeletedAt: &deletedAt, DeletedBy: "mallory"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if updated.Rev == created.Rev || updated.Trashed() {
+		t.Errorf("got %+v, want the stored product", updated)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("got changes %+v, want one", changes)
+	}
+	if name := changes[0].Changes["name"]; name.Before != "Laptop" || name.After != "Laptop Pro" || len(changes[0].Changes) != 1 {
+		t.Errorf("got field changes %+v, want only the name", changes[0].Changes)
+	}
+}
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// TrashPurgeConfig sets how long products stay in the trash and how they are removed.
+// With Hard set expired products are _purge'd instead of deleted.
+type TrashPurgeConfig struct {
+	Retention time.Duration
+	Interval  time.Duration
+	Hard      bool
+}
+
+// DefaultTrashPurgeConfig keeps trashed products for 30 days and checks hourly
+func DefaultTrashPurgeConfig() TrashPurgeConfig {
+	return TrashPurgeConfig{
+		Retention: 30 * 24 * time.Hour,
+		Interval:  time.Hour,
+	}
+}
+
+// TrashPurger periodically removes products that have been in the trash longer than the retention period
+type TrashPurger struct {
+	repo repository.ProductRepository
+	cfg  TrashPurgeConfig
+}
+
+func NewTrashPurger(repo repository.ProductRepository, cfg TrashPurgeConfig) *TrashPurger {
+	return &TrashPurger{repo: repo, cfg: cfg}
+}
+
+// Run purges expired products every interval until ctx is cancelled
+func (p *TrashPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.PurgeExpired(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired removes the products trashed before the retention period
+func (p *TrashPurger) PurgeExpired(ctx context.Context) (*repository.PurgeReport, error) {
+	report, err := p.repo.PurgeTrash(ctx, repository.PurgeOptions{
+		Before: time.Now().Add(-p.cfg.Retention),
+		Hard:   p.cfg.Hard,
+	})
+	if err != nil {
+		log.Println("Failed to purge trashed products:", err)
+		return report, err
+	}
+	if len(report.Purged) > 0 || len(report.Failed) > 0 {
+		log.Printf("Purged %d trashed products, %d failed", len(report.Purged), len(report.Failed))
+	}
+	return report, nil
+}
@@ -0,0 +1,325 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// webhookCheckpoint names the saved position of the dispatcher in the product change feed
+const webhookCheckpoint = "webhook_dispatcher"
+
+// Headers sent with every webhook delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret, see SignWebhookPayload.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookDispatcherConfig tunes delivery concurrency and retries. The delay before
+// retry n is BaseDelay*2^(n-1), capped at MaxDelay, plus up to 20% jitter. Receivers
+// are only reached at addresses Targets permits.
+type WebhookDispatcherConfig struct {
+	Workers     int
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Timeout     time.Duration
+	Targets     WebhookTargets
+}
+
+// DefaultWebhookDispatcherConfig retries a failing delivery for roughly an hour
+func DefaultWebhookDispatcherConfig() WebhookDispatcherConfig {
+	return WebhookDispatcherConfig{
+		Workers:     4,
+		MaxAttempts: 8,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    30 * time.Minute,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// webhookPayload is the JSON body POSTed to webhook receivers
+type webhookPayload struct {
+	Event      string          `json:"event"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	ProductID  string          `json:"product_id"`
+	Rev        string          `json:"rev"`
+	Seq        string          `json:"seq"`
+	Product    *entity.Product `json:"product,omitempty"`
+}
+
+// WebhookDispatcher follows the product change feed and delivers every change to the
+// webhooks subscribed to it. Deliveries are stored before they are attempted, so
+// pending ones survive a restart; the feed position is saved after each change.
+type WebhookDispatcher struct {
+	products repository.ProductRepository
+	webhooks repository.WebhookRepository
+	cfg      WebhookDispatcherConfig
+	client   *http.Client
+	queue    chan entity.WebhookDelivery
+}
+
+func NewWebhookDispatcher(products repository.ProductRepository, webhooks repository.WebhookRepository, cfg WebhookDispatcherConfig) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		products: products,
+		webhooks: webhooks,
+		cfg:      cfg,
+		client:   cfg.Targets.client(cfg.Timeout),
+		queue:    make(chan entity.WebhookDelivery, cfg.Workers*16),
+	}
+}
+
+// SignWebhookPayload computes the signature of a delivery body, for receivers to verify
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers webhooks until ctx is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	for i := 0; i < d.cfg.Workers; i++ {
+		go d.work(ctx)
+	}
+
+	// Pick up deliveries left pending by a previous run
+	pending, err := d.webhooks.ListPendingDeliveries(ctx)
+	if err != nil {
+		log.Println("Failed to load pending webhook deliveries:", err)
+	}
+	for _, delivery := range pending {
+		d.schedule(ctx, delivery)
+	}
+
+	since, err := d.webhooks.GetCheckpoint(ctx, webhookCheckpoint)
+	if err != nil {
+		log.Println("Failed to load webhook checkpoint:", err)
+	}
+	if since == "" {
+		since = repository.SinceNow
+	}
+
+	for ctx.Err() == nil {
+		since = d.follow(ctx, since)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(followRetryDelay):
+		}
+	}
+}
+
+// follow handles changes from since until the feed ends or a change cannot be handled,
+// returning the position of the last handled change
+func (d *WebhookDispatcher) follow(ctx context.Context, since string) string {
+	feed, err := d.products.WatchProducts(ctx, since)
+	if err != nil {
+		log.Println("Failed to follow product changes for webhooks:", err)
+		return since
+	}
+	defer feed.Close()
+
+	for feed.Next() {
+		change := feed.Change()
+		if err := d.enqueueChange(ctx, change); err != nil {
+			// Stop without advancing, so the change is handled again after reopening
+			log.Println("Failed to create webhook deliveries:", err)
+			return since
+		}
+		since = change.Seq
+		if err := d.webhooks.SaveCheckpoint(ctx, webhookCheckpoint, since); err != nil {
+			log.Println("Failed to save webhook checkpoint:", err)
+		}
+	}
+	if err := feed.Err(); err != nil {
+		log.Println("Product change feed for webhooks failed:", err)
+	}
+	return since
+}
+
+// enqueueChange stores and schedules a delivery for every webhook subscribed to the change
+func (d *WebhookDispatcher) enqueueChange(ctx context.Context, change repository.ProductChange) error {
+	event := "product." + change.Type
+
+	webhooks, err := d.webhooks.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	payload, err := json.Marshal(webhookPayload{
+		Event:      event,
+		OccurredAt: now,
+		ProductID:  change.ID,
+		Rev:        change.Rev,
+		Seq:        change.Seq,
+		Product:    change.Product,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		delivery, err := d.webhooks.SaveDelivery(ctx, entity.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			ProductID:     change.ID,
+			Payload:       payload,
+			Status:        entity.DeliveryPending,
+			Attempts:      []entity.WebhookAttempt{},
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+		d.schedule(ctx, *delivery)
+	}
+	return nil
+}
+
+// schedule hands a delivery to the workers once it is due
+func (d *WebhookDispatcher) schedule(ctx context.Context, delivery entity.WebhookDelivery) {
+	enqueue := func() {
+		select {
+		case d.queue <- delivery:
+		case <-ctx.Done():
+		}
+	}
+
+	if delay := time.Until(delivery.NextAttemptAt); delay > 0 {
+		time.AfterFunc(delay, enqueue)
+		return
+	}
+	enqueue()
+}
+
+// work attempts queued deliveries until ctx is cancelled
+func (d *WebhookDispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case delivery := <-d.queue:
+			d.deliver(ctx, delivery)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver makes one attempt and records its outcome. Failed deliveries are retried
+// with exponential backoff until MaxAttempts, then kept as dead letters.
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery entity.WebhookDelivery) {
+	var attempt entity.WebhookAttempt
+	webhook, err := d.webhooks.GetWebhookById(ctx, delivery.WebhookID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		attempt = entity.WebhookAttempt{At: time.Now().UTC(), Error: "webhook no longer exists"}
+		delivery.Status = entity.DeliveryDeadLetter
+	case err != nil:
+		attempt = entity.WebhookAttempt{At: time.Now().UTC(), Error: err.Error()}
+	case webhook.Disabled:
+		attempt = entity.WebhookAttempt{At: time.Now().UTC(), Error: "webhook is disabled"}
+		delivery.Status = entity.DeliveryDeadLetter
+	default:
+		attempt = d.send(ctx, *webhook, delivery)
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the delivery stays pending and is resumed on the next start
+		return
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	now := time.Now().UTC()
+	switch {
+	case delivery.Status == entity.DeliveryDeadLetter:
+	case attempt.Error == "":
+		delivery.Status = entity.DeliverySucceeded
+	case len(delivery.Attempts) >= d.cfg.MaxAttempts:
+		delivery.Status = entity.DeliveryDeadLetter
+	default:
+		delivery.NextAttemptAt = now.Add(d.backoff(len(delivery.Attempts))).Truncate(time.Second)
+	}
+	if delivery.Status == entity.DeliveryDeadLetter {
+		log.Printf("Webhook delivery %s to %s moved to dead letters: %s", delivery.ID, delivery.WebhookID, attempt.Error)
+	}
+	delivery.UpdatedAt = now
+
+	saved, err := d.webhooks.SaveDelivery(ctx, delivery)
+	if err != nil {
+		log.Println("Failed to record webhook delivery attempt:", delivery.ID, err)
+		return
+	}
+	if saved.Status == entity.DeliveryPending {
+		d.schedule(ctx, *saved)
+	}
+}
+
+// send POSTs the signed payload to the webhook receiver. Any 2xx response counts as success.
+func (d *WebhookDispatcher) send(ctx context.Context, webhook entity.Webhook, delivery entity.WebhookDelivery) (attempt entity.WebhookAttempt) {
+	start := time.Now()
+	attempt.At = start.UTC()
+	defer func() {
+		attempt.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("receiver responded with status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// backoff returns the delay before the next attempt after the given number of attempts
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.BaseDelay
+	for i := 1; i < attempts && delay < d.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxDelay {
+		delay = d.cfg.MaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
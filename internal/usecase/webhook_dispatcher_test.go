@@ -0,0 +1,159 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// webhookReceiver records the deliveries it gets and answers with the given statuses in
+// turn, repeating the last one
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	status := rc.statuses[len(rc.statuses)-1]
+	if len(rc.requests) < len(rc.statuses) {
+		status = rc.statuses[len(rc.requests)]
+	}
+	rc.requests = append(rc.requests, req)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(status)
+}
+
+func (rc *webhookReceiver) received() ([]*http.Request, [][]byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]*http.Request{}, rc.requests...), append([][]byte{}, rc.bodies...)
+}
+
+// waitForDelivery polls a webhook's deliveries until one has the given status
+func waitForDelivery(t *testing.T, hooks repository.WebhookRepository, webhookID string, status string) entity.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := hooks.ListDeliveries(context.Background(), webhookID, status, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) > 0 {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %s delivery for webhook %s", status, webhookID)
+	return entity.WebhookDelivery{}
+}
+
+func TestWebhookDispatcherDeliversSignedEventsWithRetries(t *testing.T) {
+	products := repository.NewMemoryProductRepo()
+	hooks := repository.NewMemoryWebhookRepo()
+	targets, _ := ParseWebhookTargets("127.0.0.0/8")
+	webhooks := NewWebhookService(hooks, targets)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first receiver fails once, the second never recovers
+	flaky := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusNoContent}}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	down := &webhookReceiver{statuses: []int{http.StatusServiceUnavailable}}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+
+	const secret = "0123456789abcdef0123456789abcdef"
+	flakyHook, err := webhooks.CreateWebhook(ctx, entity.Webhook{URL: flakyServer.URL, Events: []string{entity.EventProductCreated}, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	downHook, err := webhooks.CreateWebhook(ctx, entity.Webhook{URL: downServer.URL, Events: []string{entity.EventProductCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Follow the feed from its start, so the product created below is not missed
+	if err := hooks.SaveCheckpoint(ctx, webhookCheckpoint, "0"); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := NewWebhookDispatcher(products, hooks, WebhookDispatcherConfig{
+		Workers:     2,
+		MaxAttempts: 3,
+		BaseDelay:   20 * time.Millisecond,
+		MaxDelay:    50 * time.Millisecond,
+		Timeout:     time.Second,
+		Targets:     targets,
+	})
+	go dispatcher.Run(ctx)
+
+	if _, err := products.CreateProduct(ctx, entity.Product{ID: "laptop", Name: "Laptop", Price: 999}); err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded := waitForDelivery(t, hooks, flakyHook.ID, entity.DeliverySucceeded)
+	if len(succeeded.Attempts) != 2 || succeeded.Attempts[0].StatusCode != http.StatusInternalServerError || succeeded.Attempts[1].Error != "" {
+		t.Errorf("got attempts %+v, want a failure then a success", succeeded.Attempts)
+	}
+
+	requests, bodies := flaky.received()
+	for i, req := range requests {
+		timestamp := req.Header.Get(WebhookTimestampHeader)
+		want := "sha256=" + SignWebhookPayload(secret, timestamp, bodies[i])
+		if req.Header.Get(WebhookSignatureHeader) != want {
+			t.Errorf("request %d has signature %q, want %q", i, req.Header.Get(WebhookSignatureHeader), want)
+		}
+		if req.Header.Get(WebhookEventHeader) != entity.EventProductCreated || req.Header.Get(WebhookDeliveryHeader) != succeeded.ID {
+			t.Errorf("request %d has headers %v", i, req.Header)
+		}
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(bodies[len(bodies)-1], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != entity.EventProductCreated || payload.ProductID != "laptop" {
+		t.Errorf("got payload %+v", payload)
+	}
+
+	dead := waitForDelivery(t, hooks, downHook.ID, entity.DeliveryDeadLetter)
+	if len(dead.Attempts) != 3 {
+		t.Errorf("dead letter after %d attempts, want 3", len(dead.Attempts))
+	}
+	if requests, _ := down.received(); len(requests) != 3 {
+		t.Errorf("failing receiver got %d requests, want 3", len(requests))
+	}
+}
+
+func TestWebhookDispatcherBackoff(t *testing.T) {
+	dispatcher := NewWebhookDispatcher(nil, nil, WebhookDispatcherConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		// Up to 20% jitter is added
+		if delay := dispatcher.backoff(tt.attempts); delay < tt.base || delay > tt.base+tt.base/5 {
+			t.Errorf("backoff after %d attempts = %v, want %v plus up to 20%%", tt.attempts, delay, tt.base)
+		}
+	}
+}
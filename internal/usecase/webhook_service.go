@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/entity"
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// WebhookService manages webhook subscriptions and exposes their delivery log
+type WebhookService struct {
+	repo    repository.WebhookRepository
+	targets WebhookTargets
+}
+
+func NewWebhookService(repo repository.WebhookRepository, targets WebhookTargets) *WebhookService {
+	return &WebhookService{repo: repo, targets: targets}
+}
+
+// CreateWebhook registers a webhook. A signing secret is generated unless one is given.
+func (s *WebhookService) CreateWebhook(ctx context.Context, webhook entity.Webhook) (*entity.Webhook, error) {
+	if err := s.targets.checkURL(ctx, webhook.URL); err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = secret
+	}
+
+	now := time.Now().UTC()
+	webhook.Rev = ""
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+	return s.repo.CreateWebhook(ctx, webhook)
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]entity.Webhook, error) {
+	return s.repo.ListWebhooks(ctx)
+}
+
+func (s *WebhookService) GetWebhookById(ctx context.Context, id string) (*entity.Webhook, error) {
+	return s.repo.GetWebhookById(ctx, id)
+}
+
+// UpdateWebhookById replaces a webhook's URL, events and state. webhook.Rev must be the
+// current revision. The secret is kept unless a new one is given.
+func (s *WebhookService) UpdateWebhookById(ctx context.Context, id string, webhook entity.Webhook) (*entity.Webhook, error) {
+	if webhook.Rev == "" {
+		return nil, repository.NewValidationError("_rev is required", nil)
+	}
+	if err := s.targets.checkURL(ctx, webhook.URL); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.GetWebhookById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	webhook.CreatedAt = existing.CreatedAt
+	webhook.UpdatedAt = time.Now().UTC()
+	return s.repo.UpdateWebhookById(ctx, id, webhook)
+}
+
+func (s *WebhookService) DeleteWebhookById(ctx context.Context, id string, rev string) error {
+	return s.repo.DeleteWebhookById(ctx, id, rev)
+}
+
+// ListDeliveries returns a webhook's most recent deliveries, optionally only those with the given status
+func (s *WebhookService) ListDeliveries(ctx context.Context, webhookID string, status string, limit int) ([]entity.WebhookDelivery, error) {
+	switch status {
+	case "", entity.DeliveryPending, entity.DeliverySucceeded, entity.DeliveryDeadLetter:
+	default:
+		return nil, repository.NewValidationError(fmt.Sprintf("unknown delivery status '%s'", status), nil)
+	}
+	if limit == 0 {
+		limit = repository.DefaultDeliveryLimit
+	}
+	if limit < 0 || limit > repository.MaxDeliveryLimit {
+		return nil, repository.NewValidationError(fmt.Sprintf("limit must be between 1 and %d", repository.MaxDeliveryLimit), nil)
+	}
+	return s.repo.ListDeliveries(ctx, webhookID, status, limit)
+}
+
+// generateSecret returns a random signing secret
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,109 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+// ErrWebhookTargetForbidden is reported when a webhook URL resolves to an address
+// receivers may not have
+var ErrWebhookTargetForbidden = errors.New("webhook target address is not allowed")
+
+// WebhookTargets decides which addresses webhooks may be delivered to. Loopback,
+// private, link-local and unspecified addresses are refused, so that webhooks cannot
+// reach the API's own host or internal services, unless they lie in one of the
+// Allowed networks.
+type WebhookTargets struct {
+	Allowed []*net.IPNet
+}
+
+// ParseWebhookTargets reads a comma separated list of networks webhooks may reach in
+// spite of being private, e.g. "10.1.0.0/16,192.168.5.7/32"
+func ParseWebhookTargets(value string) (WebhookTargets, error) {
+	var targets WebhookTargets
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return WebhookTargets{}, fmt.Errorf("invalid webhook network '%s': %w", entry, err)
+		}
+		targets.Allowed = append(targets.Allowed, network)
+	}
+	return targets, nil
+}
+
+// Permits reports whether webhooks may be delivered to an address
+func (t WebhookTargets) Permits(ip net.IP) bool {
+	for _, network := range t.Allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// checkURL only accepts absolute http and https URLs whose host resolves to permitted
+// addresses only
+func (t WebhookTargets) checkURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return repository.NewValidationError("url must be an absolute http or https URL", nil)
+	}
+
+	host := u.Hostname()
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			return repository.NewValidationError(fmt.Sprintf("url host '%s' cannot be resolved", host), nil)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !t.Permits(ip) {
+			return repository.NewValidationError("url must not point to a loopback, private or link-local address", map[string]string{"address": ip.String()})
+		}
+	}
+	return nil
+}
+
+// client returns an HTTP client that refuses to connect to addresses that are not
+// permitted. The check runs on every dial, so it also covers redirects and hosts whose
+// DNS records changed after the webhook was registered. Proxies are not used, as the
+// check would only see the proxy's address.
+func (t WebhookTargets) client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !t.Permits(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookTargetForbidden, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
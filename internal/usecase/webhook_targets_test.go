@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"e-learning/go-with-couchdb/internal/repository"
+)
+
+func TestWebhookTargetsPermits(t *testing.T) {
+	targets, err := ParseWebhookTargets("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"10.0.0.1", false},
+		{"10.1.2.3", true},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := targets.Permits(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Permits(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if _, err := ParseWebhookTargets("10.1.0.0"); err == nil {
+		t.Error("accepted a network without a prefix length")
+	}
+}
+
+func TestWebhookTargetsCheckURL(t *testing.T) {
+	var targets WebhookTargets
+	for _, raw := range []string{
+		"ftp://example.com/hook",
+		"/hook",
+		"http://127.0.0.1:8081/api/v1/products",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://192.168.0.10/hook",
+		"http://localhost:5984/_users",
+	} {
+		if err := targets.checkURL(context.Background(), raw); !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("%s: got error %v, want a validation error", raw, err)
+		}
+	}
+	if err := targets.checkURL(context.Background(), "https://93.184.216.34/hook"); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+}
+
+func TestWebhookTargetsClientRefusesForbiddenAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer receiver.Close()
+
+	var targets WebhookTargets
+	_, err := targets.client(time.Second).Get(receiver.URL)
+	if !errors.Is(err, ErrWebhookTargetForbidden) {
+		t.Fatalf("got error %v, want ErrWebhookTargetForbidden", err)
+	}
+
+	allowed, _ := ParseWebhookTargets("127.0.0.0/8")
+	resp, err := allowed.client(time.Second).Get(receiver.URL)
+	if err != nil {
+		t.Fatalf("allowed address refused: %v", err)
+	}
+	resp.Body.Close()
+}
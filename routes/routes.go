@@ -1,37 +1,105 @@
-package routes
-
-import (
-	"e-learning/go-with-couchdb/internal/controller"
-
-	"github.com/gin-gonic/gin"
-
-	"log"
-)
-
-func InitRoutes(controller *controller.ProductController) *gin.Engine {
-
-	// Create a new Gin router instance with default middleware
-	r := gin.Default()
-
-	// Set trusted proxies to only allow requests from specified IPs
-	err := r.SetTrustedProxies([]string{"127.0.0.1", "192.168.0.0/16", "::1"})
-	if err != nil {
-		log.Fatalf("Could not set trusted proxies: %v", err)
-	}
-
-	// Create a group of routes related to products,
-	productRouter := r.Group("/api/v1/products")
-	{
-		productRouter.POST("", controller.CreateProduct)
-		productRouter.GET("", controller.GetAllProducts)
-		productRouter.GET("/:_id", controller.GetProductById)
-		productRouter.PUT("/:_id", controller.UpdateProductById)
-		productRouter.DELETE("/:_id", controller.DeleteProductById)
-
-		// For bulk create and update
-		productRouter.POST("/bulk-create", controller.BulkCreateProducts)
-		productRouter.PUT("/bulk-update", controller.BulkUpdateProducts)
-	}
-
-	return r
+package routes
+
+import (
+	"e-learning/go-with-couchdb/internal/auth"
+	"e-learning/go-with-couchdb/internal/controller"
+	"e-learning/go-with-couchdb/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+
+	"log"
+)
+
+func InitRoutes(controller *controller.ProductController, webhookController *controller.WebhookController, auditController *controller.AuditController, apiKeyController *controller.APIKeyController, auditLog middleware.AuditLog, authPolicy middleware.AuthPolicy, rateLimits middleware.RateLimitPolicy, idempotencyStore middleware.IdempotencyStore) *gin.Engine {
+
+	// Create a new Gin router instance with default middleware
+	r := gin.Default()
+
+	// Set trusted proxies to only allow requests from specified IPs; rate limits key
+	// anonymous clients by the IP they report
+	err := r.SetTrustedProxies([]string{"127.0.0.1", "192.168.0.0/16", "::1"})
+	if err != nil {
+		log.Fatalf("Could not set trusted proxies: %v", err)
+	}
+
+	// Tag every request with an ID, echoed in the response and kept in the audit log
+	r.Use(middleware.RequestID())
+
+	// Record mutating calls in the audit log; it wraps the error handler to see final statuses
+	r.Use(middleware.Audit(auditLog, "/api/v1/products/_search"))
+
+	// Translate errors reported by handlers into a consistent JSON envelope
+	r.Use(middleware.ErrorHandler())
+
+	// Record who performs each request, e.g. as deleted_by of trashed products
+	r.Use(middleware.Actor())
+
+	// Every group is limited per client IP address ahead of authentication, and per
+	// authenticated caller after it
+	ipLimit := rateLimits.LimitIP()
+
+	// Create a group of routes related to products, authenticated as configured for the group.
+	// Each route requires a permission; restoring from the trash counts as deleting.
+	read := authPolicy.Require(auth.PermProductsRead)
+	write := authPolicy.Require(auth.PermProductsWrite)
+	remove := authPolicy.Require(auth.PermProductsDelete)
+	bulk := authPolicy.Require(auth.PermProductsBulk)
+	// Creates may be retried safely with an Idempotency-Key header
+	once := middleware.Idempotency(idempotencyStore)
+	products := r.Group("/api/v1/products", ipLimit, authPolicy.Group("products"))
+	productRouter := products.Group("", rateLimits.Limit(middleware.RateLimitDefault))
+	{
+		productRouter.POST("", write, once, controller.CreateProduct)
+		productRouter.GET("", read, controller.GetAllProducts)
+		productRouter.POST("/_search", read, controller.SearchProducts)
+		productRouter.GET("/changes", read, controller.StreamProductChanges)
+		productRouter.GET("/ws", read, controller.SubscribeProductChanges)
+		productRouter.GET("/trash", read, controller.ListTrash)
+		productRouter.GET("/conflicts", read, controller.ListConflicts)
+		productRouter.GET("/:_id", read, controller.GetProductById)
+		productRouter.PUT("/:_id", write, controller.UpdateProductById)
+		productRouter.PATCH("/:_id", write, controller.PatchProductById)
+		productRouter.DELETE("/:_id", remove, controller.DeleteProductById)
+		productRouter.POST("/:_id/restore", remove, controller.RestoreProductById)
+		productRouter.GET("/:_id/history", read, controller.GetProductHistory)
+		productRouter.POST("/:_id/revert", write, controller.RevertProductById)
+		productRouter.POST("/:_id/resolve", write, controller.ResolveProductConflicts)
+	}
+
+	// For bulk create and update, rate limited apart from the other routes
+	bulkRouter := products.Group("", rateLimits.Limit(middleware.RateLimitBulk))
+	{
+		bulkRouter.POST("/bulk-create", bulk, once, controller.BulkCreateProducts)
+		bulkRouter.PUT("/bulk-update", bulk, controller.BulkUpdateProducts)
+	}
+
+	// Webhook subscriptions and their delivery log, managed by admins
+	webhookRouter := r.Group("/api/v1/webhooks", ipLimit, authPolicy.Group("webhooks"), rateLimits.Limit(middleware.RateLimitDefault), authPolicy.Restrict(auth.PermWebhooksManage))
+	{
+		webhookRouter.POST("", webhookController.CreateWebhook)
+		webhookRouter.GET("", webhookController.ListWebhooks)
+		webhookRouter.GET("/:_id", webhookController.GetWebhookById)
+		webhookRouter.PUT("/:_id", webhookController.UpdateWebhookById)
+		webhookRouter.DELETE("/:_id", webhookController.DeleteWebhookById)
+		webhookRouter.GET("/:_id/deliveries", webhookController.ListDeliveries)
+	}
+
+	// Audit log of mutating calls, read by admins
+	auditRouter := r.Group("/api/v1/audit", ipLimit, authPolicy.Group("audit"), rateLimits.Limit(middleware.RateLimitDefault), authPolicy.Restrict(auth.PermAuditRead))
+	{
+		auditRouter.GET("", auditController.ListAuditEntries)
+	}
+
+	// API keys of machine clients, managed by admins. Like webhooks and the audit log they
+	// are closed to anonymous callers even when authentication is otherwise disabled.
+	apiKeyRouter := r.Group("/api/v1/api-keys", ipLimit, authPolicy.Group("api-keys"), rateLimits.Limit(middleware.RateLimitDefault), authPolicy.Restrict(auth.PermAPIKeysManage))
+	{
+		apiKeyRouter.POST("", apiKeyController.CreateAPIKey)
+		apiKeyRouter.GET("", apiKeyController.ListAPIKeys)
+		apiKeyRouter.GET("/:_id", apiKeyController.GetAPIKeyById)
+		apiKeyRouter.POST("/:_id/rotate", apiKeyController.RotateAPIKey)
+		apiKeyRouter.DELETE("/:_id", apiKeyController.RevokeAPIKey)
+	}
+
+	return r
 }
\ No newline at end of file